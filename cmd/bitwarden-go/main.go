@@ -17,6 +17,8 @@ import (
 	"github.com/h44z/bitwarden-go/internal/api"
 	"github.com/h44z/bitwarden-go/internal/common"
 	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+	"github.com/h44z/bitwarden-go/internal/storage"
 )
 
 func main() {
@@ -48,9 +50,21 @@ func main() {
 		}
 	}
 
+	// Setup attachment storage
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Setup push notifications for the mobile apps
+	pushNotifier, err := notifications.NewPushNotifier(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Setup HTTP handlers
 	tokenAuth := jwtauth.New("HS256", []byte(cfg.Security.SigningKey), nil)
-	apiHandler := api.New(db, cfg, tokenAuth)
+	apiHandler := api.New(db, cfg, tokenAuth, store, pushNotifier)
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
@@ -59,31 +73,48 @@ func main() {
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	})
 
+	// Client addresses are only taken from the headers of known proxies, they are used for rate limiting
+	realIP, err := api.RealIP(cfg.Core.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	router := chi.NewRouter()
 
 	// A good base middleware stack
 	router.Use(corsMiddleware.Handler)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
+	router.Use(realIP)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
 
 	// Set a timeout value on the request context (ctx), that will signal
 	// through ctx.Done() that the request has timed out and further
 	// processing should be stopped.
-	router.Use(middleware.Timeout(180 * time.Second))
+	requestTimeout := middleware.Timeout(180 * time.Second)
 
 	// Public routes
 	router.Group(func(r chi.Router) {
+		r.Use(requestTimeout)
+
 		if cfg.Core.DisableRegistration == false {
 			r.Post("/api/accounts/register", apiHandler.AccountRegister)
 		}
 		r.Post("/api/accounts/prelogin", apiHandler.AccountPrelogin)
+		r.Post("/api/accounts/verify-email-token", apiHandler.AccountVerifyEmailToken)
+		r.Post("/api/accounts/password-hint", apiHandler.AccountPasswordHint)
+		r.Post("/api/accounts/delete-recover", apiHandler.AccountDeleteRecover)
+		r.Post("/api/accounts/delete-recover-token", apiHandler.AccountDeleteRecoverToken)
 		r.Post("/identity/connect/token", apiHandler.AuthToken)
+		r.Post("/api/two-factor/send-email-login", apiHandler.TwoFactorSendEmailLogin)
+		r.Post("/api/two-factor/recover", apiHandler.TwoFactorRecover)
+		r.Get("/attachments/{cipherId}/{attachmentId}", apiHandler.AttachmentDownload)
 	})
 
 	// Protected routes
 	router.Group(func(r chi.Router) {
+		r.Use(requestTimeout)
+
 		// Seek, verify and validate JWT tokens
 		r.Use(jwtauth.Verifier(tokenAuth))
 
@@ -93,34 +124,142 @@ func main() {
 		// and tweak it, its not scary.
 		r.Use(jwtauth.Authenticator)
 
+		// Reject tokens of ended sessions
+		r.Use(apiHandler.ValidateSecurityStamp)
+
 		r.Get("/admin", func(w http.ResponseWriter, r *http.Request) {
 			_, claims, _ := jwtauth.FromContext(r.Context())
 			w.Write([]byte(fmt.Sprintf("protected area. hi %v", claims["user_id"])))
 		})
+
+		r.Get("/api/accounts/revision-date", apiHandler.AccountRevisionDate)
+		r.Post("/api/accounts/security-stamp", apiHandler.AccountSecurityStamp)
+		r.Get("/api/accounts/profile", apiHandler.AccountProfile)
+		r.Put("/api/accounts/profile", apiHandler.AccountProfileUpdate)
+		r.Post("/api/accounts/profile", apiHandler.AccountProfileUpdate)
+		r.Post("/api/accounts/key", apiHandler.AccountUpdateKey)
+		r.Post("/api/accounts/password", apiHandler.AccountPassword)
+		r.Post("/api/accounts/kdf", apiHandler.AccountKdf)
+		r.Post("/api/accounts/email-token", apiHandler.AccountEmailToken)
+		r.Post("/api/accounts/email", apiHandler.AccountEmail)
+		r.Post("/api/accounts/verify-email", apiHandler.AccountVerifyEmail)
+		r.Delete("/api/accounts", apiHandler.AccountDelete)
+		r.Post("/api/accounts/delete", apiHandler.AccountDelete)
+		r.Get("/api/sync", apiHandler.Sync)
+
+		r.Get("/api/folders", apiHandler.FolderList)
+		r.Post("/api/folders", apiHandler.FolderCreate)
+		r.Get("/api/folders/{id}", apiHandler.FolderGet)
+		r.Put("/api/folders/{id}", apiHandler.FolderUpdate)
+		r.Post("/api/folders/{id}", apiHandler.FolderUpdate)
+		r.Delete("/api/folders/{id}", apiHandler.FolderDelete)
+		r.Post("/api/folders/{id}/delete", apiHandler.FolderDelete)
+		// The android app wants the address like this, will be fixed in the next version. Issue #174
+		r.Get("/apifolders", apiHandler.FolderList)
+		r.Post("/apifolders", apiHandler.FolderCreate)
+
+		r.Get("/api/ciphers", apiHandler.CipherList)
+		r.Post("/api/ciphers", apiHandler.CipherCreate)
+		r.Post("/api/ciphers/create", apiHandler.CipherCreateWrapped)
+		r.Get("/api/ciphers/{id}", apiHandler.CipherGet)
+		r.Put("/api/ciphers/{id}", apiHandler.CipherUpdate)
+		r.Post("/api/ciphers/{id}", apiHandler.CipherUpdate)
+		r.Delete("/api/ciphers/{id}", apiHandler.CipherDelete)
+		r.Post("/api/ciphers/{id}/delete", apiHandler.CipherDelete)
+		r.Put("/api/ciphers/{id}/collections", apiHandler.CipherCollectionsUpdate)
+		r.Post("/api/ciphers/{id}/collections", apiHandler.CipherCollectionsUpdate)
+		r.Post("/api/ciphers/{id}/attachment", apiHandler.CipherAttachmentCreate)
+		r.Get("/api/ciphers/{id}/attachment/{attachmentId}", apiHandler.CipherAttachmentGet)
+		r.Delete("/api/ciphers/{id}/attachment/{attachmentId}", apiHandler.CipherAttachmentDelete)
+		r.Post("/api/ciphers/{id}/attachment/{attachmentId}/delete", apiHandler.CipherAttachmentDelete)
+
+		r.Get("/api/organizations", apiHandler.OrganizationList)
+		r.Post("/api/organizations", apiHandler.OrganizationCreate)
+		r.Get("/api/organizations/{id}", apiHandler.OrganizationGet)
+		r.Get("/api/organizations/{id}/keys", apiHandler.OrganizationKeys)
+		r.Post("/api/organizations/{id}/leave", apiHandler.OrganizationLeave)
+		r.Get("/api/organizations/{id}/users", apiHandler.OrganizationUserList)
+		r.Post("/api/organizations/{id}/users/invite", apiHandler.OrganizationUserInvite)
+		r.Post("/api/organizations/{id}/users/{organizationUserId}/accept", apiHandler.OrganizationUserAccept)
+		r.Post("/api/organizations/{id}/users/{organizationUserId}/confirm", apiHandler.OrganizationUserConfirm)
+		r.Delete("/api/organizations/{id}/users/{organizationUserId}", apiHandler.OrganizationUserDelete)
+		r.Post("/api/organizations/{id}/users/{organizationUserId}/delete", apiHandler.OrganizationUserDelete)
+		r.Get("/api/organizations/{id}/collections", apiHandler.OrganizationCollectionList)
+		r.Post("/api/organizations/{id}/collections", apiHandler.OrganizationCollectionCreate)
+		r.Get("/api/organizations/{id}/collections/{collectionId}", apiHandler.OrganizationCollectionGet)
+		r.Put("/api/organizations/{id}/collections/{collectionId}", apiHandler.OrganizationCollectionUpdate)
+		r.Post("/api/organizations/{id}/collections/{collectionId}", apiHandler.OrganizationCollectionUpdate)
+		r.Delete("/api/organizations/{id}/collections/{collectionId}", apiHandler.OrganizationCollectionDelete)
+		r.Post("/api/organizations/{id}/collections/{collectionId}/delete", apiHandler.OrganizationCollectionDelete)
+		r.Get("/api/organizations/{id}/collections/{collectionId}/users", apiHandler.OrganizationCollectionUsers)
+		r.Put("/api/organizations/{id}/collections/{collectionId}/users", apiHandler.OrganizationCollectionUsersUpdate)
+		r.Get("/api/collections", apiHandler.CollectionList)
+
+		r.Get("/api/devices", apiHandler.DeviceList)
+		r.Delete("/api/devices/{id}", apiHandler.DeviceDelete)
+		r.Post("/api/devices/{id}/deactivate", apiHandler.DeviceDelete)
+		r.Get("/api/devices/identifier/{identifier}", apiHandler.DeviceGetByIdentifier)
+		r.Put("/api/devices/identifier/{identifier}/clear-token", apiHandler.DeviceClearToken)
+		r.Post("/api/devices/identifier/{identifier}/clear-token", apiHandler.DeviceClearToken)
+		r.Put("/api/devices/identifier/{identifier}/token", apiHandler.DeviceUpdateToken)
+		r.Post("/api/devices/identifier/{identifier}/token", apiHandler.DeviceUpdateToken)
+
+		r.Get("/api/two-factor", apiHandler.TwoFactorList)
+		r.Post("/api/two-factor/get-authenticator", apiHandler.TwoFactorGetAuthenticator)
+		r.Post("/api/two-factor/authenticator", apiHandler.TwoFactorAuthenticator)
+		r.Put("/api/two-factor/authenticator", apiHandler.TwoFactorAuthenticator)
+		r.Post("/api/two-factor/get-email", apiHandler.TwoFactorGetEmail)
+		r.Post("/api/two-factor/send-email", apiHandler.TwoFactorSendEmail)
+		r.Post("/api/two-factor/email", apiHandler.TwoFactorEmail)
+		r.Put("/api/two-factor/email", apiHandler.TwoFactorEmail)
+		r.Post("/api/two-factor/get-webauthn", apiHandler.TwoFactorGetWebAuthn)
+		r.Post("/api/two-factor/get-webauthn-challenge", apiHandler.TwoFactorGetWebAuthnChallenge)
+		r.Post("/api/two-factor/webauthn", apiHandler.TwoFactorWebAuthn)
+		r.Put("/api/two-factor/webauthn", apiHandler.TwoFactorWebAuthn)
+		r.Delete("/api/two-factor/webauthn", apiHandler.TwoFactorDeleteWebAuthn)
+		r.Post("/api/two-factor/get-recover", apiHandler.TwoFactorGetRecover)
+		r.Post("/api/two-factor/disable", apiHandler.TwoFactorDisable)
+		r.Put("/api/two-factor/disable", apiHandler.TwoFactorDisable)
+		r.Get("/api/users/{id}/public-key", apiHandler.UserPublicKey)
 	})
 
-	/*
-		mux.Handle("/api/accounts/keys", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleKeysUpdate)))
-		mux.Handle("/api/accounts/profile", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleProfile)))
-		mux.Handle("/api/collections", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCollections)))
-		mux.Handle("/api/folders", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleFolder)))
-		mux.Handle("/api/folders/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleFolderUpdate)))
-		mux.Handle("/apifolders", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleFolder))) // The android app want's the address like this, will be fixed in the next version. Issue #174
-		mux.Handle("/api/sync", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleSync)))
-
-		mux.Handle("/api/ciphers/import", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleImport)))
-		mux.Handle("/api/ciphers", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCipher)))
-		mux.Handle("/api/ciphers/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCipherUpdate)))
+	// Vault imports with thousands of items need more time than the other requests
+	router.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(api.ImportTimeout))
+		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(jwtauth.Authenticator)
+		r.Use(apiHandler.ValidateSecurityStamp)
+
+		r.Post("/api/ciphers/import", apiHandler.CipherImport)
+	})
 
+	// Live sync notifications, WebSocket clients pass the access token in the URL
+	router.Group(func(r chi.Router) {
+		r.Use(requestTimeout)
+		r.Use(jwtauth.Verify(tokenAuth, api.TokenFromAccessTokenQuery, jwtauth.TokenFromHeader))
+		r.Use(jwtauth.Authenticator)
+		r.Use(apiHandler.ValidateSecurityStamp)
+
+		r.Get("/notifications/hub", apiHandler.NotificationsHub)
+	})
+
+	// Remove accounts that never verified their email address
+	if cfg.Core.DeleteUnverifiedAfterDays > 0 {
+		go func() {
+			for {
+				if err := apiHandler.DeleteUnverifiedAccounts(); err != nil {
+					log.Errorf("failed to delete unverified accounts: %s", err.Error())
+				}
+				time.Sleep(time.Hour)
+			}
+		}()
+	}
+
+	/*
 		if len(cfg.Core.VaultURL) > 4 {
 			proxy := common.Proxy{VaultURL: cfg.Core.VaultURL}
 			mux.Handle("/", http.HandlerFunc(proxy.Handler))
 		}
-
-		mux.Handle("/api/two-factor/get-authenticator", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.GetAuthenticator)))
-		mux.Handle("/api/two-factor/authenticator", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.VerifyAuthenticatorSecret)))
-		mux.Handle("/api/two-factor/disable", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleDisableTwoFactor)))
-		mux.Handle("/api/two-factor", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleTwoFactor)))
 	*/
 
 	// Startup HTTP server
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// userAccess contains everything needed to decide which ciphers a user may see or change. It is loaded once
+// per request.
+type userAccess struct {
+	user          *database.User
+	memberships   map[uint64]*database.OrganizationUser // confirmed memberships, by organization id
+	organizations map[uint64]*database.Organization
+	collections   map[uint64]*collectionAccess // collections visible to the user, by collection id
+
+	cipherCollections map[uint64][]uint64 // visible collections of each organization cipher
+}
+
+// collectionAccess describes the permissions of the user for a single collection.
+type collectionAccess struct {
+	collection    database.Collection
+	readOnly      bool
+	hidePasswords bool
+}
+
+// loadUserAccess loads the organization memberships and the visible collections of the user.
+func (a *API) loadUserAccess(user *database.User) (*userAccess, error) {
+	access := &userAccess{
+		user:              user,
+		memberships:       make(map[uint64]*database.OrganizationUser),
+		organizations:     make(map[uint64]*database.Organization),
+		collections:       make(map[uint64]*collectionAccess),
+		cipherCollections: make(map[uint64][]uint64),
+	}
+
+	memberships, err := a.db.GetConfirmedMemberships(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return access, nil
+	}
+
+	organizationIds := make([]uint64, len(memberships))
+	for i := range memberships {
+		organizationIds[i] = memberships[i].OrganizationId
+		access.memberships[memberships[i].OrganizationId] = &memberships[i]
+	}
+
+	var organizations []database.Organization
+	if err := a.db.DB.Where("id IN (?) AND enabled = ?", organizationIds, true).Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	for i := range organizations {
+		access.organizations[organizations[i].Id] = &organizations[i]
+	}
+	if len(organizations) == 0 {
+		return access, nil
+	}
+
+	if err := a.loadCollectionAccess(access); err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
+
+// loadCollectionAccess loads the collections the user may see and the ciphers assigned to them. Members with
+// access to all collections see every collection of the organization without restrictions.
+func (a *API) loadCollectionAccess(access *userAccess) error {
+	var collections []database.Collection
+	if err := a.db.DB.Where("organization_id IN (?)", access.organizationIds()).Find(&collections).Error; err != nil {
+		return err
+	}
+
+	membershipIds := make([]uint64, 0, len(access.memberships))
+	for _, membership := range access.memberships {
+		membershipIds = append(membershipIds, membership.Id)
+	}
+	var collectionUsers []database.CollectionUser
+	if err := a.db.DB.Where("organization_user_id IN (?)", membershipIds).Find(&collectionUsers).Error; err != nil {
+		return err
+	}
+	assigned := make(map[uint64]*database.CollectionUser, len(collectionUsers))
+	for i := range collectionUsers {
+		assigned[collectionUsers[i].CollectionId] = &collectionUsers[i]
+	}
+
+	collectionIds := make([]uint64, 0, len(collections))
+	for i := range collections {
+		if access.hasFullAccess(collections[i].OrganizationId) {
+			access.collections[collections[i].Id] = &collectionAccess{collection: collections[i]}
+		} else if collectionUser, ok := assigned[collections[i].Id]; ok {
+			access.collections[collections[i].Id] = &collectionAccess{
+				collection:    collections[i],
+				readOnly:      collectionUser.ReadOnly,
+				hidePasswords: collectionUser.HidePasswords,
+			}
+		} else {
+			continue
+		}
+		collectionIds = append(collectionIds, collections[i].Id)
+	}
+	if len(collectionIds) == 0 {
+		return nil
+	}
+
+	var collectionCiphers []database.CollectionCipher
+	if err := a.db.DB.Where("collection_id IN (?)", collectionIds).Find(&collectionCiphers).Error; err != nil {
+		return err
+	}
+	for _, collectionCipher := range collectionCiphers {
+		access.cipherCollections[collectionCipher.CipherId] =
+			append(access.cipherCollections[collectionCipher.CipherId], collectionCipher.CollectionId)
+	}
+
+	return nil
+}
+
+// organizationIds returns the ids of all organizations whose ciphers the user may see.
+func (u *userAccess) organizationIds() []uint64 {
+	ids := make([]uint64, 0, len(u.organizations))
+	for id := range u.organizations {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// hasFullAccess returns true if the user may see and change all ciphers and collections of the organization.
+func (u *userAccess) hasFullAccess(organizationId uint64) bool {
+	if _, ok := u.organizations[organizationId]; !ok {
+		return false
+	}
+	membership := u.memberships[organizationId]
+	return membership.AccessAll || membership.IsAdmin()
+}
+
+// cipherQuery restricts the query to ciphers the user may see.
+func (u *userAccess) cipherQuery(db *gorm.DB) *gorm.DB {
+	conditions := "user_id = ?"
+	values := []interface{}{u.user.Id}
+
+	var fullAccessIds []uint64
+	for id := range u.organizations {
+		if u.hasFullAccess(id) {
+			fullAccessIds = append(fullAccessIds, id)
+		}
+	}
+	if len(fullAccessIds) > 0 {
+		conditions += " OR organization_id IN (?)"
+		values = append(values, fullAccessIds)
+	}
+
+	if len(u.cipherCollections) > 0 {
+		cipherIds := make([]uint64, 0, len(u.cipherCollections))
+		for id := range u.cipherCollections {
+			cipherIds = append(cipherIds, id)
+		}
+		conditions += " OR id IN (?)"
+		values = append(values, cipherIds)
+	}
+
+	return db.Where(conditions, values...)
+}
+
+// canRead returns true if the user may see the cipher.
+func (u *userAccess) canRead(cipher *database.Cipher) bool {
+	if cipher.OrganizationId == nil {
+		return cipher.UserId != nil && *cipher.UserId == u.user.Id
+	}
+	if _, ok := u.organizations[*cipher.OrganizationId]; !ok {
+		return false
+	}
+
+	return u.hasFullAccess(*cipher.OrganizationId) || len(u.cipherCollections[cipher.Id]) > 0
+}
+
+// canEdit returns true if the user may change or delete the cipher. Organization ciphers can only be changed
+// through a collection that is neither read-only nor hides the passwords.
+func (u *userAccess) canEdit(cipher *database.Cipher) bool {
+	if !u.canRead(cipher) {
+		return false
+	}
+	if cipher.OrganizationId == nil || u.hasFullAccess(*cipher.OrganizationId) {
+		return true
+	}
+
+	for _, collectionId := range u.cipherCollections[cipher.Id] {
+		if collection := u.collections[collectionId]; !collection.readOnly && !collection.hidePasswords {
+			return true
+		}
+	}
+	return false
+}
+
+// canViewPassword returns true if the client should show the password of the cipher to the user.
+func (u *userAccess) canViewPassword(cipher *database.Cipher) bool {
+	if cipher.OrganizationId == nil || u.hasFullAccess(*cipher.OrganizationId) {
+		return true
+	}
+
+	for _, collectionId := range u.cipherCollections[cipher.Id] {
+		if !u.collections[collectionId].hidePasswords {
+			return true
+		}
+	}
+	return false
+}
+
+// canWriteCollection returns true if the user may assign ciphers to the collection.
+func (u *userAccess) canWriteCollection(collectionId uint64) bool {
+	collection, ok := u.collections[collectionId]
+	return ok && !collection.readOnly && !collection.hidePasswords
+}
+
+// canCreateIn checks if the user may store new ciphers in the given organization. A nil organizationId
+// refers to the personal vault. Members without access to all collections have to put new organization
+// ciphers into at least one collection they may write to, they would lose access to the cipher otherwise.
+func (u *userAccess) canCreateIn(organizationId *uint64, collectionIds []uint64) error {
+	if organizationId == nil {
+		if len(collectionIds) > 0 {
+			return errors.New("personal ciphers cannot be assigned to collections")
+		}
+		return nil
+	}
+	if _, ok := u.organizations[*organizationId]; !ok {
+		return errors.New("invalid organization")
+	}
+
+	for _, collectionId := range collectionIds {
+		if !u.canWriteCollection(collectionId) || u.collections[collectionId].collection.OrganizationId != *organizationId {
+			return errors.New("invalid collection")
+		}
+	}
+	if len(collectionIds) == 0 && !u.hasFullAccess(*organizationId) {
+		return errors.New("you must select at least one collection")
+	}
+	return nil
+}
+
+// organizationUseTotp returns true if the organization of the cipher allows its members to use TOTP codes.
+func (u *userAccess) organizationUseTotp(cipher *database.Cipher) bool {
+	if cipher.OrganizationId == nil {
+		return false
+	}
+	organization, ok := u.organizations[*cipher.OrganizationId]
+	return ok && organization.UseTotp
+}
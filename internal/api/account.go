@@ -2,20 +2,43 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
 
 	bw "github.com/h44z/bitwarden-go/internal/common"
 	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
 )
 
-// AccountPrelogin allows the client to know the number of KDF iterations to apply when hashing the master password.
+// KDF parameter bounds accepted from the clients
+const (
+	minPBKDF2Iterations  = 5000
+	maxPBKDF2Iterations  = 2000000
+	minArgon2Iterations  = 2
+	maxArgon2Iterations  = 10
+	minArgon2Memory      = 15 // MiB
+	maxArgon2Memory      = 1024
+	minArgon2Parallelism = 1
+	maxArgon2Parallelism = 16
+	defaultKdfIterations = 600000
+)
+
+// Password hint requests allowed per hour
+const (
+	passwordHintEmailLimit = 3
+	passwordHintIPLimit    = 10
+)
+
+// AccountPrelogin allows the client to know the KDF parameters to apply when hashing the master password.
 func (a *API) AccountPrelogin(w http.ResponseWriter, req *http.Request) {
 	var requestData struct {
-		email string
+		Email string `json:"email"`
 	}
 
 	// Get email
@@ -28,20 +51,24 @@ func (a *API) AccountPrelogin(w http.ResponseWriter, req *http.Request) {
 
 	// Get account data from DB
 	var user database.User
-	ok := a.db.DB.First(&user, "email = ?", requestData.email).RecordNotFound()
-	if !ok {
+	notFound := a.db.DB.First(&user, "email = ?", requestData.Email).RecordNotFound()
+	if notFound {
 		// Use some fallback values, do not leak information about a non existent user.
-		user.Kdf = 0
-		user.KdfIterations = 100000
+		user.Kdf = database.KdfTypePBKDF2
+		user.KdfIterations = defaultKdfIterations
 	}
 
 	// Return Kdf data
 	userKdfInformation := struct {
-		Kdf           int
-		KdfIterations int
+		Kdf            int
+		KdfIterations  int
+		KdfMemory      *int
+		KdfParallelism *int
 	}{
-		Kdf:           user.Kdf,
-		KdfIterations: user.KdfIterations,
+		Kdf:            user.Kdf,
+		KdfIterations:  user.KdfIterations,
+		KdfMemory:      user.KdfMemory,
+		KdfParallelism: user.KdfParallelism,
 	}
 
 	MustRespondJSON(w, &userKdfInformation)
@@ -60,9 +87,9 @@ func (a *API) AccountRegister(w http.ResponseWriter, req *http.Request) {
 
 	log.Infof(requestData.Email + " is trying to register")
 
-	// Check iterations
-	if requestData.KdfIterations < 5000 || requestData.KdfIterations > 100000 {
-		http.Error(w, "unsupported iteration count", http.StatusBadRequest)
+	// Check KDF parameters
+	if err := validateKdf(requestData.Kdf, requestData.KdfIterations, requestData.KdfMemory, requestData.KdfParallelism); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -81,4 +108,634 @@ func (a *API) AccountRegister(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Errorf("register email failed: %s", err.Error())
 	}
+
+	// The user cannot log in or join organizations before the address is verified, or the account is deleted
+	if a.cfg.Core.RequireEmailVerificationLogin || a.cfg.Core.RequireEmailVerificationOrgInvite ||
+		a.cfg.Core.DeleteUnverifiedAfterDays > 0 {
+		if err := a.sendEmailVerification(req, user); err != nil {
+			log.Errorf("register verification email failed: %s", err.Error())
+		}
+	}
+}
+
+// AccountPasswordHint emails the master password hint to the address. The response does not tell whether an
+// account with the address exists.
+func (a *API) AccountPasswordHint(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.PasswordHintRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("password hint decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(requestData.Email)
+	if email == "" {
+		http.Error(w, "email is missing", http.StatusBadRequest)
+		return
+	}
+	if !a.hintIPLimiter.Allow(clientIP(req)) || !a.hintEmailLimiter.Allow(strings.ToLower(email)) {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	var user database.User
+	found := !a.db.DB.Where("email = ?", email).First(&user).RecordNotFound()
+
+	// Without an email server the hint can be shown to the client, this reveals which addresses have a hint
+	if a.cfg.Core.ShowPasswordHint && a.cfg.Email.Host == "" {
+		if !found || user.MasterPasswordHint == "" {
+			http.Error(w, "this account has no master password hint", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Your master password hint is: "+user.MasterPasswordHint, http.StatusBadRequest)
+		return
+	}
+
+	if !found {
+		log.Infof("Password hint requested for unknown address %s", email)
+		return
+	}
+
+	body := bw.EmailPasswordHintMissing
+	if user.MasterPasswordHint != "" {
+		body = strings.Replace(bw.EmailPasswordHint, "{Hint}", user.MasterPasswordHint, -1)
+	}
+	// Send in the background, the response time would reveal the account otherwise
+	go func() {
+		if err := bw.SendEmail(a.cfg, "Your Master Password Hint", body, user.Email); err != nil {
+			log.Errorf("password hint email failed: %s", err.Error())
+		}
+	}()
+}
+
+// AccountRevisionDate returns the time of the last vault change in milliseconds since the epoch.
+// Clients poll this value to decide whether a full sync is required.
+func (a *API) AccountRevisionDate(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("revision date, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var revisionDate int64
+	if user.AccountRevisionDate != nil {
+		revisionDate = user.AccountRevisionDate.UnixNano() / int64(time.Millisecond)
+	}
+
+	MustRespondJSON(w, revisionDate)
+}
+
+// AccountSecurityStamp logs out all sessions of the authenticated user. The security stamp is rotated, which
+// invalidates the issued access tokens, and all refresh tokens and remembered devices are removed.
+func (a *API) AccountSecurityStamp(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("security stamp, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("security stamp decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		return rotateSecurityStamp(tx, user)
+	})
+	if err != nil {
+		log.Errorf("security stamp, failed to rotate stamp: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.notifyUser(req, notifications.LogOut, user.Id)
+
+	log.Infof("User %s deauthorized all sessions", user.Email)
+}
+
+// AccountProfile returns the profile of the authenticated user.
+func (a *API) AccountProfile(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("profile, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	a.respondProfile(w, user)
+}
+
+// AccountProfileUpdate changes the name, master password hint and culture of the authenticated user.
+func (a *API) AccountProfileUpdate(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("profile update, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.UpdateProfileRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("profile update decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requestData.Culture) > 10 {
+		http.Error(w, "culture is invalid", http.StatusBadRequest)
+		return
+	}
+
+	user.Name = truncateUTF8(requestData.Name, 50)
+	if requestData.MasterPasswordHint != nil {
+		user.MasterPasswordHint = database.TruncateMasterPasswordHint(*requestData.MasterPasswordHint)
+	}
+	if requestData.Culture != "" {
+		user.Culture = requestData.Culture
+	}
+	user.RevisionDate = time.Now()
+
+	err = a.db.DB.Model(user).UpdateColumns(map[string]interface{}{
+		"name":                 user.Name,
+		"master_password_hint": user.MasterPasswordHint,
+		"culture":              user.Culture,
+		"revision_date":        user.RevisionDate,
+	}).Error
+	if err != nil {
+		log.Errorf("profile update, failed to store user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.respondProfile(w, user)
+}
+
+// AccountUpdateKey rotates the encryption key of the authenticated user. The request contains the new key and
+// all personal ciphers and folders encrypted with it, everything is stored in one transaction. All sessions of
+// the user end afterwards.
+func (a *API) AccountUpdateKey(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("key update, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.UpdateKeyRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("key update decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Key) || !encStringPattern.MatchString(requestData.PrivateKey) {
+		http.Error(w, "key is invalid", http.StatusBadRequest)
+		return
+	}
+
+	var ciphers []database.Cipher
+	if err := a.db.DB.Where("user_id = ?", user.Id).Find(&ciphers).Error; err != nil {
+		log.Errorf("key update, failed to load ciphers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	var folders []database.Folder
+	if err := a.db.DB.Where("user_id = ?", user.Id).Find(&folders).Error; err != nil {
+		log.Errorf("key update, failed to load folders: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := validateKeyRotation(&requestData, ciphers, folders); err != nil {
+		log.Errorf("key update, invalid data: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	currentTime := time.Now()
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, folderRequest := range requestData.Folders {
+			err := tx.Model(&database.Folder{}).Where("id = ? AND user_id = ?", folderRequest.Id, user.Id).
+				UpdateColumns(map[string]interface{}{
+					"name":          folderRequest.Name,
+					"revision_date": currentTime,
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		storedCiphers := make(map[uint64]*database.Cipher, len(ciphers))
+		for i := range ciphers {
+			storedCiphers[ciphers[i].Id] = &ciphers[i]
+		}
+		for i := range requestData.Ciphers {
+			cipherRequest := &requestData.Ciphers[i]
+			cipher := storedCiphers[cipherRequest.Id]
+			if err := applyCipherRequest(cipher, &cipherRequest.CipherRequestModel, user.Id); err != nil {
+				return err
+			}
+
+			attachments := cipher.GetAttachments()
+			for attachmentId, attachment := range attachments {
+				attachment.FileName = cipherRequest.Attachments2[attachmentId].FileName
+				attachment.Key = cipherRequest.Attachments2[attachmentId].Key
+				attachments[attachmentId] = attachment
+			}
+			if err := cipher.SetAttachments(attachments); err != nil {
+				return err
+			}
+
+			cipher.RevisionDate = currentTime
+			if err := tx.Save(cipher).Error; err != nil {
+				return err
+			}
+		}
+
+		user.Key = requestData.Key
+		user.PrivateKey = requestData.PrivateKey
+		err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"key":         user.Key,
+			"private_key": user.PrivateKey,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := rotateSecurityStamp(tx, user); err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("key update, failed to store vault: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.notifyUser(req, notifications.LogOut, user.Id)
+
+	log.Infof("User %s rotated the encryption key", user.Email)
+}
+
+// AccountPassword changes the master password of the authenticated user. The key of the user is wrapped with
+// the new master password by the client. All sessions of the user end afterwards.
+func (a *API) AccountPassword(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("password change, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.PasswordRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("password change decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+	if requestData.NewMasterPasswordHash == "" {
+		http.Error(w, "new master password is missing", http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Key) {
+		http.Error(w, "key is invalid", http.StatusBadRequest)
+		return
+	}
+
+	columns := map[string]interface{}{
+		"key": requestData.Key,
+	}
+	if requestData.MasterPasswordHint != nil {
+		columns["master_password_hint"] = database.TruncateMasterPasswordHint(*requestData.MasterPasswordHint)
+	}
+
+	if err := a.changeMasterPassword(user, requestData.NewMasterPasswordHash, columns); err != nil {
+		log.Errorf("password change, failed to store password: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.notifyUser(req, notifications.LogOut, user.Id)
+
+	log.Infof("User %s changed the master password", user.Email)
+	if err := bw.SendEmail(a.cfg, "Master password changed", bw.EmailMasterPasswordChanged, user.Email); err != nil {
+		log.Errorf("password change email failed: %s", err.Error())
+	}
+}
+
+// AccountKdf changes the KDF settings of the authenticated user. The client derives the master password hash and
+// the wrapping of the key with the new settings. All sessions of the user end afterwards.
+func (a *API) AccountKdf(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("kdf change, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.KdfRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("kdf change decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+	if err := validateKdf(requestData.Kdf, requestData.KdfIterations, requestData.KdfMemory, requestData.KdfParallelism); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.NewMasterPasswordHash == "" {
+		http.Error(w, "new master password is missing", http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Key) {
+		http.Error(w, "key is invalid", http.StatusBadRequest)
+		return
+	}
+
+	err = a.changeMasterPassword(user, requestData.NewMasterPasswordHash, map[string]interface{}{
+		"key":             requestData.Key,
+		"kdf":             requestData.Kdf,
+		"kdf_iterations":  requestData.KdfIterations,
+		"kdf_memory":      requestData.KdfMemory,
+		"kdf_parallelism": requestData.KdfParallelism,
+	})
+	if err != nil {
+		log.Errorf("kdf change, failed to store kdf settings: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.notifyUser(req, notifications.LogOut, user.Id)
+
+	log.Infof("User %s changed the KDF settings", user.Email)
+}
+
+// UserPublicKey returns the public key of a user. Organization administrators need it to share the
+// organization key with a new member.
+func (a *API) UserPublicKey(w http.ResponseWriter, req *http.Request) {
+	userId, err := getIdParam(req, "id")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var user database.User
+	if err := a.db.DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	MustRespondJSON(w, &bw.UserKeyResponseModel{UserId: user.Id, PublicKey: user.PublicKey, Object: "userKey"})
+}
+
+// validateKeyRotation checks that the request re-encrypts exactly the given ciphers and folders of the user,
+// including the keys of all attachments.
+func validateKeyRotation(model *bw.UpdateKeyRequestModel, ciphers []database.Cipher, folders []database.Folder) error {
+	storedFolders := make(map[uint64]bool, len(folders))
+	for _, folder := range folders {
+		storedFolders[folder.Id] = true
+	}
+	seenFolders := make(map[uint64]bool, len(model.Folders))
+	for _, folder := range model.Folders {
+		if !storedFolders[folder.Id] || seenFolders[folder.Id] {
+			return errors.New("invalid folder: " + strconv.FormatUint(folder.Id, 10))
+		}
+		if folder.Name == "" {
+			return errors.New("folder " + strconv.FormatUint(folder.Id, 10) + ": name is missing")
+		}
+		seenFolders[folder.Id] = true
+	}
+	if len(seenFolders) != len(storedFolders) {
+		return errors.New("all folders have to be re-encrypted")
+	}
+
+	storedCiphers := make(map[uint64]*database.Cipher, len(ciphers))
+	for i := range ciphers {
+		storedCiphers[ciphers[i].Id] = &ciphers[i]
+	}
+	seenCiphers := make(map[uint64]bool, len(model.Ciphers))
+	for i := range model.Ciphers {
+		cipherRequest := &model.Ciphers[i]
+		cipherId := strconv.FormatUint(cipherRequest.Id, 10)
+		cipher, ok := storedCiphers[cipherRequest.Id]
+		if !ok || seenCiphers[cipherRequest.Id] {
+			return errors.New("invalid cipher: " + cipherId)
+		}
+		seenCiphers[cipherRequest.Id] = true
+
+		if err := validateCipherRequest(&cipherRequest.CipherRequestModel); err != nil {
+			return errors.New("cipher " + cipherId + ": " + err.Error())
+		}
+		if cipherRequest.Type != cipher.Type || cipherRequest.OrganizationId != nil {
+			return errors.New("cipher " + cipherId + ": type or organization cannot be changed")
+		}
+		if cipherRequest.FolderId != nil && !storedFolders[*cipherRequest.FolderId] {
+			return errors.New("cipher " + cipherId + ": invalid folder")
+		}
+
+		attachments := cipher.GetAttachments()
+		if len(cipherRequest.Attachments2) != len(attachments) {
+			return errors.New("cipher " + cipherId + ": all attachment keys have to be re-encrypted")
+		}
+		for attachmentId, attachment := range cipherRequest.Attachments2 {
+			if _, ok := attachments[attachmentId]; !ok {
+				return errors.New("cipher " + cipherId + ": invalid attachment " + attachmentId)
+			}
+			if !encStringPattern.MatchString(attachment.FileName) || !encStringPattern.MatchString(attachment.Key) {
+				return errors.New("cipher " + cipherId + ": attachment " + attachmentId + " is not encrypted")
+			}
+		}
+	}
+	if len(seenCiphers) != len(storedCiphers) {
+		return errors.New("all ciphers have to be re-encrypted")
+	}
+
+	return nil
+}
+
+// respondProfile writes the profile of the user in the structure the clients expect.
+func (a *API) respondProfile(w http.ResponseWriter, user *database.User) {
+	profileResponse, err := a.profileResponseFromUser(user)
+	if err != nil {
+		log.Errorf("failed to load profile of %s: %s", user.Email, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	MustRespondJSON(w, &profileResponse)
+}
+
+// validateKdf checks the KDF parameters chosen by a client. Memory and parallelism only apply to Argon2id.
+func validateKdf(kdf, iterations int, memory, parallelism *int) error {
+	switch kdf {
+	case database.KdfTypePBKDF2:
+		if iterations < minPBKDF2Iterations || iterations > maxPBKDF2Iterations {
+			return errors.New("unsupported iteration count")
+		}
+		if memory != nil || parallelism != nil {
+			return errors.New("memory and parallelism are not supported by PBKDF2")
+		}
+	case database.KdfTypeArgon2id:
+		if iterations < minArgon2Iterations || iterations > maxArgon2Iterations {
+			return errors.New("unsupported iteration count")
+		}
+		if memory == nil || *memory < minArgon2Memory || *memory > maxArgon2Memory {
+			return errors.New("unsupported memory size")
+		}
+		if parallelism == nil || *parallelism < minArgon2Parallelism || *parallelism > maxArgon2Parallelism {
+			return errors.New("unsupported parallelism")
+		}
+	default:
+		return errors.New("unsupported kdf")
+	}
+	return nil
+}
+
+// changeMasterPassword stores the new master password with the columns derived from it and ends all sessions of
+// the user. The update only succeeds if the password the request was verified with is still stored.
+func (a *API) changeMasterPassword(user *database.User, password string, columns map[string]interface{}) error {
+	masterPassword, err := database.HashMasterPassword(password)
+	if err != nil {
+		return err
+	}
+	columns["master_password"] = masterPassword
+
+	return a.db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&database.User{}).Where("id = ? AND master_password = ?", user.Id, user.MasterPassword).
+			UpdateColumns(columns)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errors.New("master password was changed concurrently")
+		}
+		return rotateSecurityStamp(tx, user)
+	})
+}
+
+// deleteUser removes the user with all personal data: folders, personal ciphers with their attachments, devices,
+// security keys, grants and organization memberships. The last confirmed owner of an organization cannot be
+// deleted.
+func (a *API) deleteUser(user *database.User) error {
+	// Loaded within the transaction, so attachments uploaded concurrently are deleted as well
+	var ciphers []database.Cipher
+	err := a.db.DB.Transaction(func(tx *gorm.DB) error {
+		var memberships []database.OrganizationUser
+		if err := tx.Where("user_id = ?", user.Id).Find(&memberships).Error; err != nil {
+			return err
+		}
+		for _, membership := range memberships {
+			if membership.Type != database.OrganizationUserTypeOwner ||
+				membership.Status != database.OrganizationUserStatusConfirmed {
+				continue
+			}
+			var owners int
+			err := tx.Model(&database.OrganizationUser{}).
+				Where("organization_id = ? AND type = ? AND status = ?", membership.OrganizationId,
+					database.OrganizationUserTypeOwner, database.OrganizationUserStatusConfirmed).
+				Count(&owners).Error
+			if err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return errLastOwner
+			}
+		}
+		for _, membership := range memberships {
+			if err := tx.Where("organization_user_id = ?", membership.Id).Delete(&database.CollectionUser{}).Error; err != nil {
+				return err
+			}
+		}
+
+		// Organization ciphers keep the folders and favorite flags of their former members
+		settingsCiphers, err := database.GetCiphersWithUserSettings(tx, user.Id)
+		if err != nil {
+			return err
+		}
+		for i := range settingsCiphers {
+			if settingsCiphers[i].OrganizationId == nil {
+				continue
+			}
+			if err := settingsCiphers[i].SetFolderId(user.Id, nil); err != nil {
+				return err
+			}
+			if err := settingsCiphers[i].SetFavorite(user.Id, false); err != nil {
+				return err
+			}
+			err := tx.Model(&settingsCiphers[i]).UpdateColumns(map[string]interface{}{
+				"folders":   settingsCiphers[i].Folders,
+				"favorites": settingsCiphers[i].Favorites,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("user_id = ?", user.Id).Find(&ciphers).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&database.OrganizationUser{}, &database.Cipher{}, &database.Folder{},
+			&database.Device{}, &database.U2f{}} {
+			if err := tx.Where("user_id = ?", user.Id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("subject_id = ?", strconv.FormatUint(user.Id, 10)).Delete(&database.Grant{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, cipher := range ciphers {
+		for attachmentId := range cipher.GetAttachments() {
+			if err := a.storage.Delete(database.AttachmentStorageName(cipher.Id, attachmentId)); err != nil {
+				log.Errorf("failed to delete attachment %s of user %s: %s", attachmentId, user.Email, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// rotateSecurityStamp assigns a new security stamp to the user and removes all grants of the user. Every
+// session of the user ends, the clients have to log in again.
+func rotateSecurityStamp(tx *gorm.DB, user *database.User) error {
+	securityStamp, err := newSecurityStamp()
+	if err != nil {
+		return err
+	}
+
+	user.SecurityStamp = securityStamp
+	user.RevisionDate = time.Now()
+	err = tx.Model(user).UpdateColumns(map[string]interface{}{
+		"security_stamp": user.SecurityStamp,
+		"revision_date":  user.RevisionDate,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("subject_id = ?", strconv.FormatUint(user.Id, 10)).Delete(&database.Grant{}).Error
 }
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// deleteRecoverExpiry is the time a user has to click the link that deletes the account.
+const deleteRecoverExpiry = 24 * time.Hour
+
+// Delete recover requests allowed per hour
+const (
+	deleteRecoverEmailLimit = 3
+	deleteRecoverIPLimit    = 10
+)
+
+// AccountDelete deletes the authenticated user with all personal data. The last owner of an organization has to
+// hand over the organization first.
+func (a *API) AccountDelete(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("account delete, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("account delete decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	a.completeAccountDeletion(w, req, user)
+}
+
+// AccountDeleteRecover sends a link that deletes the account to the address. Users that forgot the master
+// password use it. The response does not tell whether an account with the address exists.
+func (a *API) AccountDeleteRecover(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.DeleteRecoverRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("delete recover decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(requestData.Email)
+	if email == "" {
+		http.Error(w, "email is missing", http.StatusBadRequest)
+		return
+	}
+	if !a.deleteRecoverIPLimiter.Allow(clientIP(req)) || !a.deleteRecoverEmailLimiter.Allow(strings.ToLower(email)) {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	var user database.User
+	if a.db.DB.Where("email = ?", email).First(&user).RecordNotFound() {
+		log.Infof("Account deletion requested for unknown address %s", email)
+		return
+	}
+
+	token, err := a.deleteRecoverToken(&user)
+	if err != nil {
+		log.Errorf("delete recover, failed to sign token: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	vaultURL := a.cfg.Core.VaultURL
+	if vaultURL == "" {
+		vaultURL = baseURL(req)
+	}
+	query := url.Values{}
+	query.Set("userId", strconv.FormatUint(user.Id, 10))
+	query.Set("token", token)
+	query.Set("email", user.Email)
+	body := strings.NewReplacer(
+		"{DeleteUrl}", strings.TrimRight(vaultURL, "/")+"/#/verify-recover-delete?"+query.Encode(),
+		"{Hours}", strconv.Itoa(int(deleteRecoverExpiry/time.Hour)),
+	).Replace(bw.EmailDeleteRecover)
+
+	// Send in the background, the response time would reveal the account otherwise
+	go func() {
+		if err := bw.SendEmail(a.cfg, "Delete Your Account", body, user.Email); err != nil {
+			log.Errorf("delete recover email failed: %s", err.Error())
+		}
+	}()
+}
+
+// AccountDeleteRecoverToken deletes the user the link of AccountDeleteRecover was sent to. The token proves that
+// the user received the email, the request does not need to be authenticated.
+func (a *API) AccountDeleteRecoverToken(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.DeleteRecoverTokenRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("delete recover token decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user database.User
+	if a.db.DB.Where("id = ?", requestData.UserId).First(&user).RecordNotFound() ||
+		!a.validDeleteRecoverToken(requestData.Token, &user) {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	a.completeAccountDeletion(w, req, &user)
+}
+
+// completeAccountDeletion deletes the user, logs out all clients and confirms the deletion by email.
+func (a *API) completeAccountDeletion(w http.ResponseWriter, req *http.Request, user *database.User) {
+	if err := a.deleteUser(user); err != nil {
+		if err == errLastOwner {
+			http.Error(w, "you are the only owner of an organization, transfer the ownership first", http.StatusBadRequest)
+			return
+		}
+		log.Errorf("account delete, failed to delete user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.notifyUser(req, notifications.LogOut, user.Id)
+
+	log.Infof("User %s deleted the account", user.Email)
+	if err := bw.SendEmail(a.cfg, "Account deleted", bw.EmailAccountDeleted, user.Email); err != nil {
+		log.Errorf("account delete email failed: %s", err.Error())
+	}
+}
+
+// deleteRecoverToken signs a token that allows the user to delete the account. It becomes invalid when the
+// security stamp of the user changes.
+func (a *API) deleteRecoverToken(user *database.User) (string, error) {
+	claims := jwt.MapClaims{
+		"deleteRecover": user.Id,
+		"email":         user.Email,
+		"stamp":         user.SecurityStamp,
+		"exp":           time.Now().Add(deleteRecoverExpiry).Unix(),
+	}
+	_, token, err := a.jwt.Encode(claims)
+	return token, err
+}
+
+// validDeleteRecoverToken checks that the token was issued by this server for the user.
+func (a *API) validDeleteRecoverToken(tokenString string, user *database.User) bool {
+	token, err := a.jwt.Decode(tokenString)
+	if err != nil || !token.Valid {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	userId, ok := claims["deleteRecover"].(float64)
+	return ok && uint64(userId) == user.Id && claims["email"] == user.Email && claims["stamp"] == user.SecurityStamp
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+func TestAccountDelete(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	api.db.DB.Model(user).UpdateColumn("max_storage_gb", 1)
+	member := createMember(t, api)
+	_, membership := createOrganization(t, api, user, member)
+	token := loginTokens(t, api)
+
+	folder := database.Folder{UserId: user.Id, Name: "encryptedname"}
+	if err := api.db.DB.Create(&folder).Error; err != nil {
+		t.Fatal(err)
+	}
+	cipher := createCipher(t, api, user)
+	rr := serveAuthenticated(t, api, user, api.CipherAttachmentCreate, attachmentUploadRequest(t, cipher.Id, "encrypted file"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("attachment upload failed: got %v %s", rr.Code, rr.Body.String())
+	}
+	var cipherResponse common.CipherResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &cipherResponse); err != nil {
+		t.Fatal(err)
+	}
+	attachmentId := cipherResponse.Attachments[0].Id
+
+	deleteAccount := func() int {
+		req, _ := http.NewRequest("DELETE", "/api/accounts", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+		return serveAuthenticated(t, api, user, api.AccountDelete, req).Code
+	}
+
+	// The only owner of an organization cannot leave it behind
+	if status := deleteAccount(); status != http.StatusBadRequest {
+		t.Fatalf("only owner of an organization was deleted: got %v", status)
+	}
+
+	api.db.DB.Model(membership).UpdateColumn("type", database.OrganizationUserTypeOwner)
+	if status := deleteAccount(); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if !api.db.DB.First(&database.User{}, user.Id).RecordNotFound() {
+		t.Error("user was not deleted")
+	}
+	var remaining int
+	for _, model := range []interface{}{&database.Folder{}, &database.Cipher{}, &database.OrganizationUser{}, &database.Device{}} {
+		api.db.DB.Model(model).Where("user_id = ?", user.Id).Count(&remaining)
+		if remaining != 0 {
+			t.Errorf("%T rows of the deleted user were kept: got %v", model, remaining)
+		}
+	}
+	if _, err := api.storage.Get(database.AttachmentStorageName(cipher.Id, attachmentId)); err == nil {
+		t.Error("attachment file was not deleted")
+	}
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token was not removed: got %v", status)
+	}
+	api.db.DB.Model(&database.OrganizationUser{}).Where("user_id = ?", member.Id).Count(&remaining)
+	if remaining != 1 {
+		t.Errorf("membership of the other owner was removed")
+	}
+}
+
+var deleteRecoverPattern = regexp.MustCompile(`verify-recover-delete\?email=[^&]+&token=([\w.-]+)&userId=(\d+)`)
+
+func TestAccountDeleteRecover(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	requestLink := func(email string) {
+		req, _ := http.NewRequest("POST", "/api/accounts/delete-recover", strings.NewReader(`{"email":"`+email+`"}`))
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.AccountDeleteRecover).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+			t.Errorf("unexpected response for %s: got %v %s", email, rr.Code, rr.Body.String())
+		}
+	}
+	requestLink("unknown@test.com")
+	requestLink(user.Email)
+
+	var match []string
+	select {
+	case message := <-messages:
+		if match = deleteRecoverPattern.FindStringSubmatch(message); match == nil {
+			t.Fatalf("message does not contain a deletion link: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+
+	deleteWithToken := func(userId uint64, token string) int {
+		req, _ := http.NewRequest("POST", "/api/accounts/delete-recover-token", strings.NewReader(
+			`{"userId":`+strconv.FormatUint(userId, 10)+`,"token":"`+token+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.AccountDeleteRecoverToken).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if status := deleteWithToken(user.Id+1, match[1]); status != http.StatusBadRequest {
+		t.Errorf("token was accepted for another user: got %v", status)
+	}
+	if status := deleteWithToken(user.Id, match[1]); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !api.db.DB.First(&database.User{}, user.Id).RecordNotFound() {
+		t.Error("user was not deleted")
+	}
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "account and all of its data were deleted") {
+			t.Errorf("unexpected notification: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no notification was sent")
+	}
+}
+
+func TestAccountDeleteCleanup(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	member := createMember(t, api)
+	orgId, membership := createOrganization(t, api, user, member)
+	api.db.DB.Model(membership).UpdateColumn("type", database.OrganizationUserTypeOwner)
+	cipher := createCipher(t, api, user)
+
+	folderId := uint64(1)
+	orgCipher := database.Cipher{OrganizationId: &orgId, Type: 2, Data: `{"name":"note"}`, CreationDate: time.Now(),
+		RevisionDate: time.Now()}
+	for _, userId := range []uint64{user.Id, member.Id} {
+		orgCipher.SetFolderId(userId, &folderId)
+		orgCipher.SetFavorite(userId, true)
+	}
+	if err := api.db.DB.Create(&orgCipher).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload finishes right after the ciphers were loaded, it has to wait for a running transaction
+	uploaded := false
+	api.db.DB.Callback().Query().After("gorm:query").Register("test:upload", func(scope *gorm.Scope) {
+		if _, ok := scope.Value.(*[]database.Cipher); !ok || uploaded {
+			return
+		}
+		uploaded = true
+		name := database.AttachmentStorageName(cipher.Id, "late")
+		if err := api.storage.Put(name, strings.NewReader("late"), 4); err != nil {
+			t.Fatal(err)
+		}
+		stored := database.Cipher{}
+		api.db.DB.First(&stored, cipher.Id)
+		stored.SetAttachments(map[string]database.Attachment{"late": {FileName: testEncString, Key: testEncString, Size: 4}})
+		err := api.db.DB.Model(&stored).Where("attachments = ?", cipher.Attachments).
+			UpdateColumn("attachments", stored.Attachments).Error
+		if err != nil {
+			api.storage.Delete(name)
+		}
+	})
+	defer api.db.DB.Callback().Query().Remove("test:upload")
+
+	req, _ := http.NewRequest("DELETE", "/api/accounts", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+	if rr := serveAuthenticated(t, api, user, api.AccountDelete, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	if _, err := api.storage.Get(database.AttachmentStorageName(cipher.Id, "late")); err == nil {
+		t.Error("concurrently uploaded attachment file was not deleted")
+	}
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, orgCipher.Id)
+	if storedCipher.GetFolderId(user.Id) != nil || storedCipher.IsFavorite(user.Id) {
+		t.Errorf("settings of the deleted user were kept: %s %s", storedCipher.Folders, storedCipher.Favorites)
+	}
+	if storedCipher.GetFolderId(member.Id) == nil || !storedCipher.IsFavorite(member.Id) {
+		t.Errorf("settings of the other member were removed: %s %s", storedCipher.Folders, storedCipher.Favorites)
+	}
+}
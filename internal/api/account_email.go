@@ -0,0 +1,208 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// AccountEmailToken sends a code to the new email address of the authenticated user. The code has to be sent
+// back to AccountEmail to change the address. If the address already belongs to an account, its owner is told
+// instead, the response is the same.
+func (a *API) AccountEmailToken(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("email token, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.EmailTokenRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("email token decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(requestData.NewEmail)
+	if !strings.Contains(email, "@") || len(email) > 50 || strings.EqualFold(email, user.Email) {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+
+	if !a.db.DB.Where("email = ?", email).First(&database.User{}).RecordNotFound() {
+		log.Infof("User %s tried to change the email address to the registered address %s", user.Email, email)
+		if err := bw.SendEmail(a.cfg, "Email address change", bw.EmailChangeAddressTaken, email); err != nil {
+			log.Errorf("email token, failed to send email: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	code, err := a.issueEmailCodeGrant(user, database.GrantTypeEmailChange, email)
+	if err != nil {
+		log.Errorf("email token, failed to store code: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	body := strings.NewReplacer(
+		"{Code}", code,
+		"{Minutes}", strconv.Itoa(int(emailCodeExpiry/time.Minute)),
+	).Replace(bw.EmailChangeCode)
+	if err := bw.SendEmail(a.cfg, "Your Email Change Verification Code", body, email); err != nil {
+		log.Errorf("email token, failed to send email: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AccountEmail changes the email address of the authenticated user after the user entered the code sent by
+// AccountEmailToken. The address is the salt of the master password, the client derives the new master password
+// hash and the wrapping of the key with it. All sessions of the user end afterwards.
+func (a *API) AccountEmail(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("email change, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.EmailRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("email change decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+	if requestData.NewMasterPasswordHash == "" {
+		http.Error(w, "new master password is missing", http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Key) {
+		http.Error(w, "key is invalid", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(requestData.NewEmail)
+	valid, err := a.consumeEmailCodeGrant(user, database.GrantTypeEmailChange, email, requestData.Token)
+	if err != nil {
+		log.Errorf("email change, failed to check code: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// The address may have been registered after the code was sent
+	if !valid || !a.db.DB.Where("email = ?", email).First(&database.User{}).RecordNotFound() {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	err = a.changeMasterPassword(user, requestData.NewMasterPasswordHash, map[string]interface{}{
+		"email":          email,
+		"email_verified": true,
+		"key":            requestData.Key,
+	})
+	if err != nil {
+		log.Errorf("email change, failed to store email: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.notifyUser(req, notifications.LogOut, user.Id)
+
+	log.Infof("User %s changed the email address to %s", user.Email, email)
+	if err := bw.SendEmail(a.cfg, "Email address changed", bw.EmailAddressChanged, user.Email); err != nil {
+		log.Errorf("email change email failed: %s", err.Error())
+	}
+}
+
+// issueEmailCodeGrant generates a code for the address and stores a hash of it as grant of the user. A pending
+// code of the same type becomes invalid.
+func (a *API) issueEmailCodeGrant(user *database.User, grantType, email string) (string, error) {
+	code, err := newEmailCode()
+	if err != nil {
+		return "", err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&emailMetaData{
+		Code:           hashEmailCode(code),
+		CodeEmail:      email,
+		CodeExpiration: time.Now().Add(emailCodeExpiry).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	grant := database.Grant{
+		Key:            base64.StdEncoding.EncodeToString(key),
+		Type:           grantType,
+		SubjectId:      strconv.FormatUint(user.Id, 10),
+		Data:           string(data),
+		CreationDate:   time.Now(),
+		ExpirationDate: time.Now().Add(emailCodeExpiry),
+	}
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subject_id = ? AND type = ?", grant.SubjectId, grantType).Delete(&database.Grant{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&grant).Error
+	})
+	return code, err
+}
+
+// consumeEmailCodeGrant checks the code against the pending code of the user for the address. Failed attempts
+// are counted, a code can only be used once.
+func (a *API) consumeEmailCodeGrant(user *database.User, grantType, email, code string) (bool, error) {
+	var grant database.Grant
+	err := a.db.DB.Where("subject_id = ? AND type = ?", strconv.FormatUint(user.Id, 10), grantType).First(&grant).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var metaData emailMetaData
+	if err := json.Unmarshal([]byte(grant.Data), &metaData); err != nil {
+		return false, err
+	}
+	valid := consumeEmailCode(&metaData, email, code, time.Now())
+
+	// Only the request that changes the stored grant may use the code
+	var result *gorm.DB
+	if metaData.Code == "" {
+		result = a.db.DB.Where("Key = ? AND data = ?", grant.Key, grant.Data).Delete(&database.Grant{})
+	} else {
+		data, err := json.Marshal(&metaData)
+		if err != nil {
+			return false, err
+		}
+		result = a.db.DB.Model(&database.Grant{}).Where("Key = ? AND data = ?", grant.Key, grant.Data).
+			UpdateColumn("data", string(data))
+	}
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return valid && result.RowsAffected == 1, nil
+}
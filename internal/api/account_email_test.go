@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+func TestAccountEmail(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+	other := database.User{Email: "taken@test.com", MasterPassword: "otherhash", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer api.db.DB.Delete(&other)
+
+	requestToken := func(email string) {
+		req, _ := http.NewRequest("POST", "/api/accounts/email-token", strings.NewReader(
+			`{"masterPasswordHash":"notarealhash","newEmail":"`+email+`"}`))
+		if rr := serveAuthenticated(t, api, user, api.AccountEmailToken, req); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	changeEmail := func(email, code string) int {
+		req, _ := http.NewRequest("POST", "/api/accounts/email", strings.NewReader(
+			`{"masterPasswordHash":"notarealhash","newMasterPasswordHash":"newhash","newEmail":"`+email+
+				`","token":"`+code+`","key":"`+testEncString+`"}`))
+		return serveAuthenticated(t, api, user, api.AccountEmail, req).Code
+	}
+
+	// A registered address is not revealed, its owner gets a notice instead of a code
+	requestToken(other.Email)
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "already belongs to an account") {
+			t.Errorf("unexpected email: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+	if status := changeEmail(other.Email, "000000"); status != http.StatusBadRequest {
+		t.Errorf("email was changed to a registered address: got %v", status)
+	}
+
+	// The code is only valid for the address it was sent to
+	requestToken("new@test.com")
+	code := receiveEmailCode(t, messages)
+	if status := changeEmail("other@test.com", code); status != http.StatusBadRequest {
+		t.Errorf("code was accepted for another address: got %v", status)
+	}
+	if status := changeEmail("new@test.com", code); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Email != "new@test.com" || !storedUser.EmailVerified || storedUser.Key != testEncString {
+		t.Errorf("email or key were not updated: got %v %v %v", storedUser.Email, storedUser.EmailVerified, storedUser.Key)
+	}
+	if err := validateCredentials(&storedUser, "newhash"); err != nil {
+		t.Errorf("new password is not accepted: %s", err.Error())
+	}
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token was not removed: got %v", status)
+	}
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "To: test@test.com") || !strings.Contains(message, "email address of your account was changed") {
+			t.Errorf("unexpected notification: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no notification was sent")
+	}
+
+	// The code can only be used once
+	if valid, err := api.consumeEmailCodeGrant(&storedUser, database.GrantTypeEmailChange, "new@test.com", code); err != nil || valid {
+		t.Errorf("code was accepted twice: %v %v", valid, err)
+	}
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// loginTokens logs in the test user and returns the issued tokens.
+func loginTokens(t *testing.T, api *API) common.TokenModel {
+	rr := passwordLogin(t, api, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login failed: got %v %s", rr.Code, rr.Body.String())
+	}
+	var token common.TokenModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// serveWithToken runs the handler behind the same middleware as the protected routes.
+func serveWithToken(api *API, accessToken string, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+	jwtauth.Verifier(api.jwt)(jwtauth.Authenticator(api.ValidateSecurityStamp(handler))).ServeHTTP(rr, req)
+	return rr
+}
+
+// refreshLogin requests a new access token with the refresh token.
+func refreshLogin(api *API, refreshToken string) int {
+	req, _ := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(
+		"grant_type=refresh_token&client_id=browser&scope=api offline_access&deviceType=3&deviceIdentifier=sample-device"+
+			"&deviceName=firefox&refresh_token="+url.QueryEscape(refreshToken)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(api.AuthToken).ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestAccountSecurityStamp(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+
+	req, _ := http.NewRequest("GET", "/api/accounts/revision-date", nil)
+	if rr := serveWithToken(api, token.AccessToken, api.AccountRevisionDate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/accounts/security-stamp", strings.NewReader(`{"masterPasswordHash":"wrong"}`))
+	if rr := serveAuthenticated(t, api, user, api.AccountSecurityStamp, req); rr.Code != http.StatusBadRequest {
+		t.Errorf("sessions were ended without the master password: got %v", rr.Code)
+	}
+	req, _ = http.NewRequest("POST", "/api/accounts/security-stamp", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+	if rr := serveAuthenticated(t, api, user, api.AccountSecurityStamp, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Neither the access token nor the refresh token can be used anymore
+	req, _ = http.NewRequest("GET", "/api/accounts/revision-date", nil)
+	if rr := serveWithToken(api, token.AccessToken, api.AccountRevisionDate, req); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token with an outdated security stamp was accepted: got %v", rr.Code)
+	}
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token was not removed: got %v", status)
+	}
+
+	// A new login works
+	token = loginTokens(t, api)
+	req, _ = http.NewRequest("GET", "/api/accounts/revision-date", nil)
+	if rr := serveWithToken(api, token.AccessToken, api.AccountRevisionDate, req); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAccountProfile(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	req, _ := http.NewRequest("GET", "/api/accounts/profile", nil)
+	rr := serveAuthenticated(t, api, user, api.AccountProfile, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var profile common.ProfileResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Id != user.Id || profile.Name != "Tester" || profile.MasterPasswordHint != "well..." ||
+		profile.Key != user.Key || profile.SecurityStamp != user.SecurityStamp || profile.Object != "profile" {
+		t.Errorf("handler returned unexpected profile: got %+v", profile)
+	}
+
+	// The hint is shortened like at registration
+	hint := strings.Repeat("h", 60)
+	req, _ = http.NewRequest("PUT", "/api/accounts/profile", strings.NewReader(
+		`{"name":"New Name","masterPasswordHint":"`+hint+`","culture":"de-DE"}`))
+	rr = serveAuthenticated(t, api, user, api.AccountProfileUpdate, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "New Name" || profile.MasterPasswordHint != hint[:47]+"..." || profile.Culture != "de-DE" {
+		t.Errorf("handler returned unexpected profile: got %+v", profile)
+	}
+
+	// A missing hint is kept
+	req, _ = http.NewRequest("PUT", "/api/accounts/profile", strings.NewReader(`{"name":"Other Name"}`))
+	if rr := serveAuthenticated(t, api, user, api.AccountProfileUpdate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Name != "Other Name" || storedUser.MasterPasswordHint != hint[:47]+"..." || storedUser.Culture != "de-DE" {
+		t.Errorf("profile was not stored: got %v %v %v", storedUser.Name, storedUser.MasterPasswordHint, storedUser.Culture)
+	}
+}
+
+func TestAccountUpdateKey(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+	cipher := createCipher(t, api, user)
+	folder := database.Folder{UserId: user.Id, Name: "oldname", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&folder).Error; err != nil {
+		t.Fatal(err)
+	}
+	cipherId := strconv.FormatUint(cipher.Id, 10)
+	folderId := strconv.FormatUint(folder.Id, 10)
+
+	updateKey := func(ciphers, folders string) int {
+		req, _ := http.NewRequest("POST", "/api/accounts/key", strings.NewReader(
+			`{"masterPasswordHash":"notarealhash","key":"`+testEncString+`","privateKey":"`+testEncString+`",`+
+				`"ciphers":[`+ciphers+`],"folders":[`+folders+`]}`))
+		return serveAuthenticated(t, api, user, api.AccountUpdateKey, req).Code
+	}
+	cipherJSON := `{"id":"` + cipherId + `","type":2,"name":"` + testEncString + `","folderId":"` + folderId + `","secureNote":{"type":0}}`
+	folderJSON := `{"id":"` + folderId + `","name":"` + testEncString + `"}`
+
+	// Every cipher and folder has to be part of the rotation
+	if status := updateKey("", folderJSON); status != http.StatusBadRequest {
+		t.Errorf("rotation without all ciphers was accepted: got %v", status)
+	}
+	if status := updateKey(cipherJSON, ""); status != http.StatusBadRequest {
+		t.Errorf("rotation without all folders was accepted: got %v", status)
+	}
+	if status := updateKey(cipherJSON+","+cipherJSON, folderJSON); status != http.StatusBadRequest {
+		t.Errorf("rotation with duplicate ciphers was accepted: got %v", status)
+	}
+
+	if status := updateKey(cipherJSON, folderJSON); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Key != testEncString || storedUser.PrivateKey != testEncString {
+		t.Errorf("keys were not stored: got %v %v", storedUser.Key, storedUser.PrivateKey)
+	}
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, cipher.Id)
+	if !strings.Contains(storedCipher.Data, testEncString) || storedCipher.GetFolderId(user.Id) == nil {
+		t.Errorf("cipher was not re-encrypted: got %v", storedCipher.Data)
+	}
+	var storedFolder database.Folder
+	api.db.DB.First(&storedFolder, folder.Id)
+	if storedFolder.Name != testEncString {
+		t.Errorf("folder was not re-encrypted: got %v", storedFolder.Name)
+	}
+
+	// Sessions using the old key ended
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token was not removed: got %v", status)
+	}
+}
+
+func TestAccountPassword(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+
+	changePassword := func(current string) int {
+		req, _ := http.NewRequest("POST", "/api/accounts/password", strings.NewReader(
+			`{"masterPasswordHash":"`+current+`","newMasterPasswordHash":"newhash","key":"`+testEncString+`"}`))
+		return serveAuthenticated(t, api, user, api.AccountPassword, req).Code
+	}
+	if status := changePassword("wrong"); status != http.StatusBadRequest {
+		t.Errorf("password was changed without the current password: got %v", status)
+	}
+	if status := changePassword("notarealhash"); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Key != testEncString || storedUser.SecurityStamp == "hmmm" {
+		t.Errorf("key or security stamp were not updated: got %v %v", storedUser.Key, storedUser.SecurityStamp)
+	}
+	if err := validateCredentials(&storedUser, "newhash"); err != nil {
+		t.Errorf("new password is not accepted: %s", err.Error())
+	}
+	if err := validateCredentials(&storedUser, "notarealhash"); err == nil {
+		t.Errorf("old password is still accepted")
+	}
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token was not removed: got %v", status)
+	}
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "master password of your account was changed") {
+			t.Errorf("unexpected notification: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no notification was sent")
+	}
+}
+
+func TestAccountKdf(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+
+	changeKdf := func(settings string) int {
+		req, _ := http.NewRequest("POST", "/api/accounts/kdf", strings.NewReader(
+			`{"masterPasswordHash":"notarealhash","newMasterPasswordHash":"newhash","key":"`+testEncString+`",`+settings+`}`))
+		return serveAuthenticated(t, api, user, api.AccountKdf, req).Code
+	}
+	invalidSettings := []string{
+		`"kdf":0,"kdfIterations":4999`,
+		`"kdf":0,"kdfIterations":600000,"kdfMemory":64`,
+		`"kdf":1,"kdfIterations":3,"kdfParallelism":4`,
+		`"kdf":1,"kdfIterations":3,"kdfMemory":2048,"kdfParallelism":4`,
+		`"kdf":2,"kdfIterations":3`,
+	}
+	for _, settings := range invalidSettings {
+		if status := changeKdf(settings); status != http.StatusBadRequest {
+			t.Errorf("invalid kdf settings %s were accepted: got %v", settings, status)
+		}
+	}
+	if status := changeKdf(`"kdf":1,"kdfIterations":3,"kdfMemory":64,"kdfParallelism":4`); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Kdf != database.KdfTypeArgon2id || storedUser.KdfIterations != 3 ||
+		storedUser.KdfMemory == nil || *storedUser.KdfMemory != 64 ||
+		storedUser.KdfParallelism == nil || *storedUser.KdfParallelism != 4 {
+		t.Errorf("kdf settings were not stored: got %+v", storedUser)
+	}
+	if err := validateCredentials(&storedUser, "newhash"); err != nil {
+		t.Errorf("new password is not accepted: %s", err.Error())
+	}
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token was not removed: got %v", status)
+	}
+
+	// The clients learn about the new settings before the login
+	prelogin := func(email string) map[string]interface{} {
+		req, _ := http.NewRequest("POST", "/api/accounts/prelogin", strings.NewReader(`{"email":"`+email+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.AccountPrelogin).ServeHTTP(rr, req)
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if response := prelogin(user.Email); response["Kdf"] != float64(1) || response["KdfIterations"] != float64(3) ||
+		response["KdfMemory"] != float64(64) || response["KdfParallelism"] != float64(4) {
+		t.Errorf("unexpected prelogin response: got %v", response)
+	}
+	if response := prelogin("unknown@test.com"); response["Kdf"] != float64(0) ||
+		response["KdfIterations"] != float64(defaultKdfIterations) || response["KdfMemory"] != nil {
+		t.Errorf("unexpected prelogin response for an unknown user: got %v", response)
+	}
+}
+
+func TestAccountPasswordHint(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB
+	createUser(t, api.db.DB)
+
+	requestHint := func(email, ip string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/accounts/password-hint", strings.NewReader(`{"email":"`+email+`"}`))
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.AccountPasswordHint).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Known and unknown addresses get the same response
+	for _, email := range []string{"test@test.com", "unknown@test.com"} {
+		if rr := requestHint(email, "10.0.0.1"); rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+			t.Errorf("unexpected response for %s: got %v %s", email, rr.Code, rr.Body.String())
+		}
+	}
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "To: test@test.com") || !strings.Contains(message, `Your hint is: "well..."`) {
+			t.Errorf("unexpected email: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+
+	// Requests are limited per address and per IP
+	for i := 1; i < passwordHintEmailLimit; i++ {
+		requestHint("test@test.com", "10.0.0.2")
+	}
+	if rr := requestHint("TEST@test.com", "10.0.0.3"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("requests for an address were not limited: got %v", rr.Code)
+	}
+	for i := 0; i < passwordHintIPLimit; i++ {
+		requestHint("unknown"+strconv.Itoa(i)+"@test.com", "10.0.0.4")
+	}
+	if rr := requestHint("other@test.com", "10.0.0.4"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("requests of an IP were not limited: got %v", rr.Code)
+	}
+
+	// Wait for the emails sent in the background before the configuration changes
+	for i := 1; i < passwordHintEmailLimit; i++ {
+		select {
+		case <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no email was sent")
+		}
+	}
+
+	// Without an email server the hint can be shown directly
+	api.cfg.Email.Host = ""
+	api.cfg.Core.ShowPasswordHint = true
+	api.hintEmailLimiter = newRateLimiter(passwordHintEmailLimit, time.Hour)
+	if rr := requestHint("test@test.com", "10.0.0.5"); !strings.Contains(rr.Body.String(), "well...") {
+		t.Errorf("hint was not shown: got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := requestHint("unknown@test.com", "10.0.0.5"); strings.Contains(rr.Body.String(), "well...") {
+		t.Errorf("unexpected response for an unknown address: got %v %s", rr.Code, rr.Body.String())
+	}
+}
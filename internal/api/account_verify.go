@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// emailVerificationExpiry is the time a user has to click the verification link.
+const emailVerificationExpiry = 24 * time.Hour
+
+// verifyEmailLimit is the number of verification links sent per hour on rejected logins.
+const verifyEmailLimit = 3
+
+// AccountVerifyEmail sends a verification link to the email address of the authenticated user.
+func (a *API) AccountVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("verify email, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if user.EmailVerified {
+		http.Error(w, "email address is already verified", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.sendEmailVerification(req, user); err != nil {
+		log.Errorf("verify email, failed to send email: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AccountVerifyEmailToken marks the email address of the user as verified. The token of the verification link
+// proves that the user received the email, the request does not need to be authenticated.
+func (a *API) AccountVerifyEmailToken(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.VerifyEmailRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("verify email token decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user database.User
+	if a.db.DB.Where("id = ?", requestData.UserId).First(&user).RecordNotFound() ||
+		!a.validEmailVerificationToken(requestData.Token, &user) {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+	if user.EmailVerified {
+		return
+	}
+
+	// The address may have changed since the link was sent
+	err := a.db.DB.Model(&database.User{}).Where("id = ? AND email = ?", user.Id, user.Email).
+		UpdateColumns(map[string]interface{}{
+			"email_verified": true,
+			"revision_date":  time.Now(),
+		}).Error
+	if err != nil {
+		log.Errorf("verify email token, failed to store user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("User %s verified the email address", user.Email)
+}
+
+// DeleteUnverifiedAccounts removes the accounts that did not verify their email address within the configured
+// number of days. It is called periodically, accounts that own an organization alone are kept. Only accounts
+// registered while the cleanup was enabled are affected, existing accounts were never asked to verify.
+func (a *API) DeleteUnverifiedAccounts() error {
+	if a.cfg.Core.DeleteUnverifiedAfterDays <= 0 {
+		return nil
+	}
+
+	var users []database.User
+	err := a.db.DB.Where("email_verified = ? AND email_verification_pending = ? AND creation_date < ?", false, true,
+		time.Now().AddDate(0, 0, -a.cfg.Core.DeleteUnverifiedAfterDays)).Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range users {
+		if err := a.deleteUser(&users[i]); err != nil {
+			log.Errorf("failed to delete unverified account %s: %s", users[i].Email, err.Error())
+			continue
+		}
+		log.Infof("Deleted account %s, the email address was not verified", users[i].Email)
+	}
+	return nil
+}
+
+// sendEmailVerification emails the verification link to the address of the user.
+func (a *API) sendEmailVerification(req *http.Request, user *database.User) error {
+	token, err := a.emailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	vaultURL := a.cfg.Core.VaultURL
+	if vaultURL == "" {
+		vaultURL = baseURL(req)
+	}
+	query := url.Values{}
+	query.Set("userId", strconv.FormatUint(user.Id, 10))
+	query.Set("token", token)
+
+	body := strings.NewReplacer(
+		"{VerifyUrl}", strings.TrimRight(vaultURL, "/")+"/#/verify-email?"+query.Encode(),
+		"{Hours}", strconv.Itoa(int(emailVerificationExpiry/time.Hour)),
+	).Replace(bw.EmailVerifyAddress)
+
+	return bw.SendEmail(a.cfg, "Verify your email address", body, user.Email)
+}
+
+// emailVerificationToken signs a token that allows the user to verify the current email address.
+func (a *API) emailVerificationToken(user *database.User) (string, error) {
+	claims := jwt.MapClaims{
+		"verifyEmail": user.Id,
+		"email":       user.Email,
+		"exp":         time.Now().Add(emailVerificationExpiry).Unix(),
+	}
+	_, token, err := a.jwt.Encode(claims)
+	return token, err
+}
+
+// validEmailVerificationToken checks that the token was issued by this server for the current address of the user.
+func (a *API) validEmailVerificationToken(tokenString string, user *database.User) bool {
+	token, err := a.jwt.Decode(tokenString)
+	if err != nil || !token.Valid {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	userId, ok := claims["verifyEmail"].(float64)
+	return ok && uint64(userId) == user.Id && claims["email"] == user.Email
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+var verifyTokenPattern = regexp.MustCompile(`verify-email\?token=([\w.-]+)&userId=(\d+)`)
+
+func TestAccountVerifyEmail(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	api.db.DB.Model(user).UpdateColumn("email_verified", false)
+
+	// Requiring a verified address for organizations does not affect the login
+	api.cfg.Core.RequireEmailVerificationOrgInvite = true
+	if rr := passwordLogin(t, api, ""); rr.Code != http.StatusOK {
+		t.Fatalf("unverified user cannot log in: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// A rejected login sends a new link, the user cannot request one without logging in
+	api.cfg.Core.RequireEmailVerificationLogin = true
+	for i := 0; i < verifyEmailLimit+1; i++ {
+		if rr := passwordLogin(t, api, ""); rr.Code != http.StatusBadRequest {
+			t.Fatalf("unverified user was logged in: got %v", rr.Code)
+		}
+	}
+	if sent := len(messages); sent != verifyEmailLimit {
+		t.Errorf("unexpected number of verification emails: got %v want %v", sent, verifyEmailLimit)
+	}
+	for len(messages) > 0 {
+		if message := <-messages; !verifyTokenPattern.MatchString(message) {
+			t.Errorf("message does not contain a verification link: %s", message)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "/api/accounts/verify-email", nil)
+	if rr := serveAuthenticated(t, api, user, api.AccountVerifyEmail, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var match []string
+	select {
+	case message := <-messages:
+		if match = verifyTokenPattern.FindStringSubmatch(message); match == nil {
+			t.Fatalf("message does not contain a verification link: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+	if match[2] != strconv.FormatUint(user.Id, 10) {
+		t.Errorf("link was issued for another user: got %v", match[2])
+	}
+
+	verify := func(userId uint64, token string) int {
+		req, _ := http.NewRequest("POST", "/api/accounts/verify-email-token", strings.NewReader(
+			`{"userId":`+strconv.FormatUint(userId, 10)+`,"token":"`+token+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.AccountVerifyEmailToken).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if status := verify(user.Id+1, match[1]); status != http.StatusBadRequest {
+		t.Errorf("token was accepted for another user: got %v", status)
+	}
+	if status := verify(user.Id, match[1]); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if rr := passwordLogin(t, api, ""); rr.Code != http.StatusOK {
+		t.Errorf("verified user cannot log in: got %v %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeleteUnverifiedAccounts(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	api.cfg.Core.DeleteUnverifiedAfterDays = 7
+
+	// Prepare DB
+	verified := createUser(t, api.db.DB)
+	api.db.DB.Model(verified).UpdateColumn("creation_date", time.Now().AddDate(0, 0, -30))
+	recent := database.User{Email: "recent@test.com", EmailVerificationPending: true, CreationDate: time.Now(),
+		RevisionDate: time.Now()}
+	stale := database.User{Email: "stale@test.com", EmailVerificationPending: true, CreationDate: time.Now().AddDate(0, 0, -8),
+		RevisionDate: time.Now()}
+	// Registered before the cleanup was enabled
+	existing := database.User{Email: "existing@test.com", CreationDate: time.Now().AddDate(0, 0, -60), RevisionDate: time.Now()}
+	for _, user := range []*database.User{&recent, &stale, &existing} {
+		if err := api.db.DB.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		defer api.db.DB.Delete(user)
+	}
+	folder := database.Folder{UserId: stale.Id, Name: "encryptedname"}
+	device := database.Device{UserId: stale.Id, Identifier: "stale-device"}
+	for _, value := range []interface{}{&folder, &device} {
+		if err := api.db.DB.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := api.DeleteUnverifiedAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	var users []database.User
+	api.db.DB.Order("id").Find(&users)
+	if len(users) != 3 || users[0].Id != verified.Id || users[1].Id != recent.Id || users[2].Id != existing.Id {
+		t.Errorf("unexpected remaining users: got %+v", users)
+	}
+	var remaining int
+	api.db.DB.Model(&database.Folder{}).Where("user_id = ?", stale.Id).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("folders of the deleted user were kept: got %v", remaining)
+	}
+	api.db.DB.Model(&database.Device{}).Where("user_id = ?", stale.Id).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("devices of the deleted user were kept: got %v", remaining)
+	}
+}
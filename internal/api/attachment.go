@@ -0,0 +1,356 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+	"github.com/h44z/bitwarden-go/internal/storage"
+)
+
+var (
+	errStorageExceeded = errors.New("not enough storage available")
+	errCipherModified  = errors.New("the cipher was modified concurrently, please retry")
+)
+
+// CipherAttachmentCreate stores an encrypted file for a cipher of the authenticated user. The upload is a
+// multipart form containing the encrypted attachment key and the file, the file name is encrypted too.
+func (a *API) CipherAttachmentCreate(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("attachment create, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("attachment create, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !access.canEdit(cipher) {
+		http.Error(w, "you do not have permission to edit this cipher", http.StatusForbidden)
+		return
+	}
+
+	freeStorage := availableStorage(access, cipher)
+	if freeStorage <= 0 {
+		http.Error(w, "not enough storage available", http.StatusBadRequest)
+		return
+	}
+
+	// Allow some overhead for the multipart encoding
+	req.Body = http.MaxBytesReader(w, req.Body, freeStorage+1024*1024)
+	if err := req.ParseMultipartForm(32 * 1024 * 1024); err != nil {
+		log.Errorf("attachment create decoding failed: %s", err.Error())
+		http.Error(w, "invalid or too large upload", http.StatusBadRequest)
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	file, header, err := req.FormFile("data")
+	if err != nil {
+		http.Error(w, "data is missing", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	attachmentKey := req.FormValue("key")
+	if header.Filename == "" || !encStringPattern.MatchString(header.Filename) ||
+		(attachmentKey != "" && !encStringPattern.MatchString(attachmentKey)) {
+		http.Error(w, "attachment contains unencrypted data", http.StatusBadRequest)
+		return
+	}
+	if header.Size > freeStorage {
+		http.Error(w, "not enough storage available", http.StatusBadRequest)
+		return
+	}
+
+	attachmentId, err := newAttachmentId()
+	if err != nil {
+		log.Errorf("attachment create, failed to read rand: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	storageName := database.AttachmentStorageName(cipher.Id, attachmentId)
+	if err := a.storage.Put(storageName, file, header.Size); err != nil {
+		log.Errorf("attachment create, failed to store file: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	attachments := cipher.GetAttachments()
+	attachments[attachmentId] = database.Attachment{
+		FileName: header.Filename,
+		Key:      attachmentKey,
+		Size:     header.Size,
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		// Other uploads may have used the storage since the check above
+		reserved, err := database.ReserveCipherStorage(tx, cipher, header.Size)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return errStorageExceeded
+		}
+		if err := storeCipherAttachments(tx, cipher, attachments); err != nil {
+			return err
+		}
+		return database.UpdateCipherRevisionDate(tx, cipher)
+	})
+	if err != nil {
+		a.storage.Delete(storageName) // do not leave orphaned files behind
+		switch err {
+		case errStorageExceeded:
+			http.Error(w, "not enough storage available", http.StatusBadRequest)
+		case errCipherModified:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Errorf("attachment create, failed to store cipher: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	a.notifyCipher(req, notifications.SyncCipherUpdate, cipher)
+
+	a.respondCipher(w, req, cipher, access)
+}
+
+// CipherAttachmentGet returns the meta data of an attachment, including a fresh download url.
+func (a *API) CipherAttachmentGet(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("attachment get, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("attachment get, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	attachmentId := chi.URLParam(req, "attachmentId")
+	attachment, ok := cipher.GetAttachments()[attachmentId]
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	attachmentResponse := a.attachmentResponseFromModel(req, cipher.Id, attachmentId, &attachment)
+	MustRespondJSON(w, &attachmentResponse)
+}
+
+// CipherAttachmentDelete removes an attachment from a cipher of the authenticated user.
+func (a *API) CipherAttachmentDelete(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("attachment delete, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("attachment delete, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !access.canEdit(cipher) {
+		http.Error(w, "you do not have permission to edit this cipher", http.StatusForbidden)
+		return
+	}
+
+	attachmentId := chi.URLParam(req, "attachmentId")
+	attachments := cipher.GetAttachments()
+	attachment, ok := attachments[attachmentId]
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	delete(attachments, attachmentId)
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := storeCipherAttachments(tx, cipher, attachments); err != nil {
+			return err
+		}
+		if err := database.UpdateCipherStorage(tx, cipher, -attachment.Size); err != nil {
+			return err
+		}
+		return database.UpdateCipherRevisionDate(tx, cipher)
+	})
+	if err == errCipherModified {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Errorf("attachment delete, failed to store cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyCipher(req, notifications.SyncCipherUpdate, cipher)
+
+	if err := a.storage.Delete(database.AttachmentStorageName(cipher.Id, attachmentId)); err != nil {
+		log.Errorf("attachment delete, failed to delete file: %s", err.Error())
+	}
+}
+
+// AttachmentDownload streams the encrypted content of an attachment. The clients do not send an access token
+// when downloading, the request is authorized by the signed token in the download url.
+func (a *API) AttachmentDownload(w http.ResponseWriter, req *http.Request) {
+	cipherId, err := getIdParam(req, "cipherId")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	attachmentId := chi.URLParam(req, "attachmentId")
+	storageName := database.AttachmentStorageName(cipherId, attachmentId)
+
+	token, err := a.jwt.Decode(req.URL.Query().Get("token"))
+	if err != nil || !token.Valid {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); !ok || claims["attachment"] != storageName {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	file, err := a.storage.Get(storageName)
+	if err == storage.ErrNotFound {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("attachment download, failed to load file: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, file); err != nil {
+		log.Errorf("attachment download, failed to send file: %s", err.Error())
+	}
+}
+
+// attachmentResponsesFromModel converts the attachments of the cipher to the structure the clients expect.
+func (a *API) attachmentResponsesFromModel(req *http.Request, cipher *database.Cipher) []bw.AttachmentResponseModel {
+	attachments := cipher.GetAttachments()
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	attachmentResponses := make([]bw.AttachmentResponseModel, 0, len(attachments))
+	for attachmentId, attachment := range attachments {
+		attachmentResponses = append(attachmentResponses,
+			a.attachmentResponseFromModel(req, cipher.Id, attachmentId, &attachment))
+	}
+	sort.Slice(attachmentResponses, func(i, j int) bool {
+		return attachmentResponses[i].Id < attachmentResponses[j].Id
+	})
+
+	return attachmentResponses
+}
+
+// attachmentResponseFromModel converts the attachment to the structure the clients expect. The download url
+// contains a signed token that is valid as long as an access token.
+func (a *API) attachmentResponseFromModel(req *http.Request, cipherId uint64, attachmentId string, attachment *database.Attachment) bw.AttachmentResponseModel {
+	storageName := database.AttachmentStorageName(cipherId, attachmentId)
+	claims := jwt.MapClaims{
+		"attachment": storageName,
+		"exp":        time.Now().Add(time.Second * time.Duration(a.cfg.Security.JWTExpire)).Unix(),
+	}
+	_, token, err := a.jwt.Encode(claims)
+	if err != nil {
+		log.Errorf("failed to sign attachment url: %s", err.Error())
+	}
+
+	return bw.AttachmentResponseModel{
+		Id:       attachmentId,
+		Url:      baseURL(req) + "/attachments/" + storageName + "?token=" + url.QueryEscape(token),
+		FileName: attachment.FileName,
+		Key:      attachment.Key,
+		Size:     strconv.FormatInt(attachment.Size, 10),
+		SizeName: sizeName(attachment.Size),
+		Object:   "attachment",
+	}
+}
+
+// storeCipherAttachments replaces the attachments of the cipher. Only the attachments and the revision date are
+// written, errCipherModified is returned if the attachments changed since the cipher was loaded.
+func storeCipherAttachments(tx *gorm.DB, cipher *database.Cipher, attachments map[string]database.Attachment) error {
+	oldAttachments := cipher.Attachments
+	if err := cipher.SetAttachments(attachments); err != nil {
+		return err
+	}
+	cipher.RevisionDate = time.Now()
+
+	result := tx.Model(&database.Cipher{}).Where("id = ? AND attachments = ?", cipher.Id, oldAttachments).
+		UpdateColumns(map[string]interface{}{
+			"attachments":   cipher.Attachments,
+			"revision_date": cipher.RevisionDate,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errCipherModified
+	}
+	return nil
+}
+
+// availableStorage returns the number of bytes the owner of the cipher may still use for attachments.
+func availableStorage(access *userAccess, cipher *database.Cipher) int64 {
+	if cipher.OrganizationId != nil {
+		organization := access.organizations[*cipher.OrganizationId]
+		return int64(organization.MaxStorageGb)*1024*1024*1024 - organization.Storage
+	}
+
+	return int64(access.user.MaxStorageGb)*1024*1024*1024 - access.user.Storage
+}
+
+// newAttachmentId generates a random, unguessable attachment id.
+func newAttachmentId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// sizeName formats a file size for humans.
+func sizeName(size int64) string {
+	units := []string{"Bytes", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.2f %s", value, units[unit])
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// attachmentUploadRequest builds the multipart request the clients send for attachment uploads.
+func attachmentUploadRequest(t *testing.T, cipherId uint64, content string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("key", testEncString)
+	part, err := writer.CreateFormFile("data", testEncString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/api/ciphers/"+strconv.FormatUint(cipherId, 10)+"/attachment", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return withURLParam(req, "id", strconv.FormatUint(cipherId, 10))
+}
+
+func createCipher(t *testing.T, api *API, user *database.User) *database.Cipher {
+	cipher := database.Cipher{UserId: &user.Id, Type: 2, Data: `{"name":"note"}`, CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&cipher).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &cipher
+}
+
+func TestAttachmentUploadDownloadDelete(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	api.db.DB.Model(user).UpdateColumn("max_storage_gb", 1)
+	cipher := createCipher(t, api, user)
+
+	// Upload
+	rr := serveAuthenticated(t, api, user, api.CipherAttachmentCreate, attachmentUploadRequest(t, cipher.Id, "encrypted file"))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+
+	var cipherResponse common.CipherResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &cipherResponse); err != nil {
+		t.Fatal(err)
+	}
+	if len(cipherResponse.Attachments) != 1 {
+		t.Fatalf("handler returned unexpected attachments: got %v", cipherResponse.Attachments)
+	}
+	attachment := cipherResponse.Attachments[0]
+	if attachment.Size != "14" || attachment.FileName != testEncString || attachment.Key != testEncString {
+		t.Errorf("handler returned unexpected attachment: got %v", attachment)
+	}
+
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Storage != 14 {
+		t.Errorf("storage usage was not updated: got %d want %d", storedUser.Storage, 14)
+	}
+
+	// Download with the signed url
+	downloadURL, _ := url.Parse(attachment.Url)
+	req, _ := http.NewRequest("GET", downloadURL.RequestURI(), nil)
+	req = withURLParam(req, "cipherId", strconv.FormatUint(cipher.Id, 10))
+	req = withURLParam(req, "attachmentId", attachment.Id)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(api.AttachmentDownload).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if rr.Body.String() != "encrypted file" {
+		t.Errorf("handler returned unexpected content: got %v", rr.Body.String())
+	}
+
+	// Download with a token for another attachment
+	req, _ = http.NewRequest("GET", strings.Replace(downloadURL.RequestURI(), attachment.Id, "other", 1), nil)
+	req = withURLParam(req, "cipherId", strconv.FormatUint(cipher.Id, 10))
+	req = withURLParam(req, "attachmentId", "other")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(api.AttachmentDownload).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+
+	// Delete
+	req, _ = http.NewRequest("DELETE", "/api/ciphers/"+strconv.FormatUint(cipher.Id, 10)+"/attachment/"+attachment.Id, nil)
+	req = withURLParam(req, "id", strconv.FormatUint(cipher.Id, 10))
+	req = withURLParam(req, "attachmentId", attachment.Id)
+	rr = serveAuthenticated(t, api, user, api.CipherAttachmentDelete, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Storage != 0 {
+		t.Errorf("storage usage was not updated: got %d want %d", storedUser.Storage, 0)
+	}
+	if _, err := api.storage.Get(database.AttachmentStorageName(cipher.Id, attachment.Id)); err == nil {
+		t.Errorf("attachment file was not deleted")
+	}
+}
+
+func TestAttachmentQuota(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB, only a few bytes are left
+	user := createUser(t, api.db.DB)
+	api.db.DB.Model(user).UpdateColumns(map[string]interface{}{"max_storage_gb": 1, "storage": 1024*1024*1024 - 10})
+	cipher := createCipher(t, api, user)
+
+	rr := serveAuthenticated(t, api, user, api.CipherAttachmentCreate, attachmentUploadRequest(t, cipher.Id, "encrypted file"))
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if strings.TrimSpace(rr.Body.String()) != "not enough storage available" {
+		t.Errorf("handler returned unexpected body: got %v", strings.TrimSpace(rr.Body.String()))
+	}
+
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, cipher.Id)
+	if len(storedCipher.GetAttachments()) != 0 {
+		t.Errorf("attachment was stored although the quota was exceeded")
+	}
+}
+
+func TestAttachmentConcurrentChanges(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB, only a few bytes are left
+	user := createUser(t, api.db.DB)
+	api.db.DB.Model(user).UpdateColumns(map[string]interface{}{"max_storage_gb": 1, "storage": 1024*1024*1024 - 10})
+	cipher := createCipher(t, api, user)
+
+	// A reservation beyond the quota must not change the used storage
+	if reserved, err := database.ReserveCipherStorage(api.db.DB, cipher, 11); err != nil || reserved {
+		t.Errorf("storage beyond the quota was reserved: %v, %v", reserved, err)
+	}
+	if reserved, err := database.ReserveCipherStorage(api.db.DB, cipher, 10); err != nil || !reserved {
+		t.Errorf("storage within the quota was not reserved: %v, %v", reserved, err)
+	}
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Storage != 1024*1024*1024 {
+		t.Errorf("unexpected storage: got %v", storedUser.Storage)
+	}
+
+	// The cipher data changes while the attachments are updated
+	staleCipher := *cipher
+	api.db.DB.Model(cipher).UpdateColumn("data", `{"name":"changed"}`)
+	attachments := map[string]database.Attachment{"a1": {FileName: "file", Key: "key", Size: 1}}
+	if err := storeCipherAttachments(api.db.DB, cipher, attachments); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second update based on the old attachments has to fail
+	if err := storeCipherAttachments(api.db.DB, &staleCipher, map[string]database.Attachment{}); err != errCipherModified {
+		t.Errorf("stale attachments were written: %v", err)
+	}
+
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, cipher.Id)
+	if storedCipher.Data != `{"name":"changed"}` {
+		t.Errorf("cipher data was overwritten: got %v", storedCipher.Data)
+	}
+	if _, ok := storedCipher.GetAttachments()["a1"]; !ok {
+		t.Errorf("attachment was not stored")
+	}
+}
+
+func TestCipherChangesDuringUpload(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	api.db.DB.Model(user).UpdateColumn("max_storage_gb", 1)
+	cipher := createCipher(t, api, user)
+	cipherId := strconv.FormatUint(cipher.Id, 10)
+
+	// An upload finishes right after the handler loaded the cipher
+	var upload map[string]database.Attachment
+	api.db.DB.Callback().Query().After("gorm:query").Register("test:upload", func(scope *gorm.Scope) {
+		if _, ok := scope.Value.(*database.Cipher); !ok || upload == nil {
+			return
+		}
+		attachments := upload
+		upload = nil
+		var size int64
+		for _, attachment := range attachments {
+			size += attachment.Size
+		}
+		stored := database.Cipher{}
+		api.db.DB.First(&stored, cipher.Id)
+		for id, attachment := range stored.GetAttachments() {
+			attachments[id] = attachment
+		}
+		stored.SetAttachments(attachments)
+		api.db.DB.Model(&stored).UpdateColumn("attachments", stored.Attachments)
+		database.UpdateStorage(api.db.DB, user.Id, size)
+	})
+	defer api.db.DB.Callback().Query().Remove("test:upload")
+
+	// Updating the cipher keeps the new attachment
+	upload = map[string]database.Attachment{"first": {FileName: testEncString, Key: testEncString, Size: 5}}
+	req, _ := http.NewRequest("PUT", "/api/ciphers/"+cipherId, strings.NewReader(
+		`{"type":2,"name":"`+testEncString+`","secureNote":{"type":0}}`))
+	req = withURLParam(req, "id", cipherId)
+	if rr := serveAuthenticated(t, api, user, api.CipherUpdate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, cipher.Id)
+	if _, ok := storedCipher.GetAttachments()["first"]; !ok {
+		t.Errorf("cipher update removed a concurrently uploaded attachment: %s", storedCipher.Attachments)
+	}
+
+	// Deleting the cipher releases the storage of all attachments
+	upload = map[string]database.Attachment{"second": {FileName: testEncString, Key: testEncString, Size: 7}}
+	req, _ = http.NewRequest("DELETE", "/api/ciphers/"+cipherId, nil)
+	req = withURLParam(req, "id", cipherId)
+	if rr := serveAuthenticated(t, api, user, api.CipherDelete, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.Storage != 0 {
+		t.Errorf("storage of deleted attachments is still counted: got %d want 0", storedUser.Storage)
+	}
+}
@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/h44z/bitwarden-go/internal/common"
@@ -14,6 +17,7 @@ import (
 	"github.com/h44z/bitwarden-go/internal/database"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -35,9 +39,11 @@ func (a *API) AuthToken(w http.ResponseWriter, req *http.Request) {
 
 	var user database.User
 	var grant database.Grant
+	var twoFactorToken string
+	var device *database.Device
 	if grantType == "refresh_token" {
 		refreshToken := req.PostForm["refresh_token"][0]
-		fail := a.db.DB.Where("Key = ?", refreshToken).First(&grant).RecordNotFound()
+		fail := a.db.DB.Where("Key = ? AND type = ?", refreshToken, database.GrantTypeRefreshToken).First(&grant).RecordNotFound()
 		if fail {
 			log.Error("Login failed, invalid refresh token")
 			time.Sleep(2 * time.Second) // delay response to avoid denial of service attacks
@@ -59,6 +65,17 @@ func (a *API) AuthToken(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		// Refresh tokens issued before devices were tracked are not linked to any device
+		device = &database.Device{}
+		fail = grant.DeviceId == 0 ||
+			a.db.DB.Where("id = ? AND user_id = ?", grant.DeviceId, user.Id).First(device).RecordNotFound()
+		if fail {
+			log.Errorf("Login failed, refresh token not linked to any device %s, %s", grant.Key, grant.SubjectId)
+			a.db.DB.Delete(&grant) // the device was revoked or is unknown
+			http.Error(w, "invalid refresh_token", http.StatusUnauthorized)
+			return
+		}
+
 		log.Infof("User %s is trying to refresh the access token for %s", user.Email, grant.ClientId)
 	} else if grantType == "password" {
 		username := req.PostForm["username"][0]
@@ -81,7 +98,36 @@ func (a *API) AuthToken(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// TODO: 2fa
+		if database.MasterPasswordNeedsRehash(user.MasterPassword) {
+			if err := a.rehashMasterPassword(&user, passwordHash); err != nil {
+				log.Errorf("Login, failed to rehash the master password of %s: %s", username, err.Error())
+			}
+		}
+
+		if a.cfg.Core.RequireEmailVerificationLogin && !user.EmailVerified {
+			log.Errorf("Login failed, email address not verified: %s", username)
+			// The user cannot request a new link without logging in, the credentials were checked above
+			if a.verifyEmailLimiter.Allow(strings.ToLower(user.Email)) {
+				if err := a.sendEmailVerification(req, &user); err != nil {
+					log.Errorf("Login, failed to send the verification email to %s: %s", username, err.Error())
+				}
+			}
+			http.Error(w, "email address is not verified, use the link sent to your email address", http.StatusBadRequest)
+			return
+		}
+
+		var ok bool
+		if twoFactorToken, ok = a.checkTwoFactor(w, req, &user); !ok {
+			return
+		}
+
+		deviceType, _ := strconv.Atoi(req.PostForm["deviceType"][0])
+		device, err = a.upsertDevice(&user, req.PostForm["deviceIdentifier"][0], deviceType, req.PostForm["deviceName"][0])
+		if err != nil {
+			log.Errorf("Login failed, unable to register device: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	if user.Email == "" {
@@ -105,7 +151,7 @@ func (a *API) AuthToken(w http.ResponseWriter, req *http.Request) {
 	claims["premium"] = user.Premium
 	claims["email_verified"] = user.EmailVerified
 	claims["sstamp"] = user.SecurityStamp
-	claims["device"] = req.PostForm["deviceIdentifier"][0]
+	claims["device"] = device.Identifier
 
 	_, tokenString, err := a.jwt.Encode(claims)
 
@@ -122,7 +168,8 @@ func (a *API) AuthToken(w http.ResponseWriter, req *http.Request) {
 		grant.ExpirationDate = time.Now().Add(time.Hour * 168) // 1 week
 		grant.ClientId = clientID
 		grant.SubjectId = strconv.FormatUint(user.Id, 10)
-		grant.Type = "refresh_token"
+		grant.DeviceId = device.Id
+		grant.Type = database.GrantTypeRefreshToken
 		if claimsJSON, err := json.Marshal(claims); err == nil {
 			grant.Data = string(claimsJSON)
 		}
@@ -134,12 +181,17 @@ func (a *API) AuthToken(w http.ResponseWriter, req *http.Request) {
 	}
 
 	tokenModel := common.TokenModel{
-		ClientId:     clientID,
-		AccessToken:  tokenString,
-		ExpiresIn:    a.cfg.Security.JWTExpire,
-		TokenType:    "Bearer",
-		RefreshToken: grant.Key,
-		Key:          user.Key,
+		ClientId:       clientID,
+		AccessToken:    tokenString,
+		ExpiresIn:      a.cfg.Security.JWTExpire,
+		TokenType:      "Bearer",
+		RefreshToken:   grant.Key,
+		Key:            user.Key,
+		TwoFactorToken: twoFactorToken,
+		Kdf:            user.Kdf,
+		KdfIterations:  user.KdfIterations,
+		KdfMemory:      user.KdfMemory,
+		KdfParallelism: user.KdfParallelism,
 	}
 	if clientID == "web" {
 		tokenModel.PrivateKey = user.PrivateKey
@@ -174,15 +226,21 @@ func validateLoginFields(req *http.Request) error {
 		return errors.New("scope is missing")
 	}
 
-	_, ok = req.PostForm["deviceIdentifier"]
+	deviceIdentifier, ok := req.PostForm["deviceIdentifier"]
 	if !ok {
 		return errors.New("deviceIdentifier is missing")
 	}
+	if len(deviceIdentifier[0]) == 0 || len(deviceIdentifier[0]) > 50 {
+		return errors.New("deviceIdentifier is invalid")
+	}
 
-	_, ok = req.PostForm["deviceType"]
+	deviceType, ok := req.PostForm["deviceType"]
 	if !ok {
 		return errors.New("deviceType is missing")
 	}
+	if _, err := strconv.Atoi(deviceType[0]); err != nil {
+		return errors.New("deviceType is invalid")
+	}
 
 	_, ok = req.PostForm["deviceName"]
 	if !ok {
@@ -202,14 +260,68 @@ func validateLoginFields(req *http.Request) error {
 	return nil
 }
 
+// rehashMasterPassword replaces the stored master password with a hash using the current parameters. Only the
+// value the password was verified against is replaced.
+func (a *API) rehashMasterPassword(user *database.User, password string) error {
+	masterPassword, err := database.HashMasterPassword(password)
+	if err != nil {
+		return err
+	}
+	err = a.db.DB.Model(&database.User{}).Where("id = ? AND master_password = ?", user.Id, user.MasterPassword).
+		UpdateColumn("master_password", masterPassword).Error
+	if err != nil {
+		return err
+	}
+	user.MasterPassword = masterPassword
+	return nil
+}
+
 // validateCredentials checks if the password matches the user record.
 func validateCredentials(user *database.User, password string) error {
 	if password == "" {
 		return errors.New("invalid credentials")
 	}
-	if user.MasterPassword != password {
+	if !database.VerifyMasterPassword(user.MasterPassword, password) {
 		return errors.New("invalid credentials")
 	}
 
 	return nil
 }
+
+// ValidateSecurityStamp rejects access tokens that were issued before the security stamp of the user changed
+// and access tokens of revoked devices. It has to run after the jwtauth authenticator, the loaded user is
+// passed on to the handlers.
+func (a *API) ValidateSecurityStamp(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, claims, err := jwtauth.FromContext(req.Context())
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		sub, _ := claims["sub"].(float64)
+		stamp, _ := claims["sstamp"].(string)
+
+		var user database.User
+		if err := a.db.DB.Where("id = ?", uint64(sub)).First(&user).Error; err != nil {
+			log.Errorf("Access token of unknown user %v: %s", claims["sub"], err.Error())
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(stamp), []byte(user.SecurityStamp)) != 1 {
+			log.Errorf("Access token of %s has an outdated security stamp", user.Email)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if identifier, ok := claims["device"].(string); ok {
+			var devices int
+			err := a.db.DB.Model(&database.Device{}).Where("user_id = ? AND identifier = ?", user.Id, identifier).Count(&devices).Error
+			if err != nil || devices == 0 {
+				log.Errorf("Access token of %s belongs to a revoked device", user.Email)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), userContextKey, &user)))
+	})
+}
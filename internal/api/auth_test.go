@@ -10,9 +10,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
 
 	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+	"github.com/h44z/bitwarden-go/internal/storage"
 
 	"github.com/go-chi/jwtauth"
 	"github.com/h44z/bitwarden-go/internal/common"
@@ -22,22 +25,24 @@ func setup(t *testing.T) *API {
 	cfg, _ := common.LoadConfiguration("")
 	cfg.Database.Type = common.DatabaseTypeSQLite
 	cfg.Database.Location = "__test_db.sqlite"
+	cfg.Storage.Location = "__test_attachments"
 	tokenAuth := jwtauth.New("HS256", []byte(cfg.Security.SigningKey), nil)
 	db := database.New(cfg)
 	db.Open()
 	db.Initialize()
 
-	api := New(db, cfg, tokenAuth)
+	api := New(db, cfg, tokenAuth, storage.NewLocal(cfg.Storage.Location), notifications.NoopPushNotifier{})
 
 	t.Cleanup(func() {
 		db.Close()
 		os.Remove(cfg.Database.Location)
+		os.RemoveAll(cfg.Storage.Location)
 	})
 
 	return &api
 }
 
-func createUser(t *testing.T, db *gorm.DB) {
+func createUser(t *testing.T, db *gorm.DB) *database.User {
 	user := database.User{
 		Name:               "Tester",
 		Email:              "test@test.com",
@@ -61,6 +66,28 @@ func createUser(t *testing.T, db *gorm.DB) {
 	t.Cleanup(func() {
 		db.Delete(&user)
 	})
+
+	return &user
+}
+
+// serveAuthenticated runs the handler behind the JWT middleware with a valid access token for the given user.
+func serveAuthenticated(t *testing.T, api *API, user *database.User, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	claims := jwt.MapClaims{
+		"sub":    user.Id,
+		"email":  user.Email,
+		"sstamp": user.SecurityStamp,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+	_, tokenString, err := api.jwt.Encode(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	rr := httptest.NewRecorder()
+	jwtauth.Verifier(api.jwt)(jwtauth.Authenticator(api.ValidateSecurityStamp(handler))).ServeHTTP(rr, req)
+
+	return rr
 }
 
 func deleteRefreshTokens(t *testing.T, db *gorm.DB) {
@@ -369,3 +396,47 @@ func TestRefreshToken(t *testing.T) {
 			rr.Body.String(), "{\"client_id\":\"browser\",\"access_token\" ...")
 	}
 }
+
+func TestMasterPasswordHashing(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Registration stores a salted hash of the master password hash
+	req, _ := http.NewRequest("POST", "/api/accounts/register", strings.NewReader(
+		`{"name":"New","email":"new@test.com","masterPasswordHash":"clienthash","key":"`+testEncString+
+			`","kdf":0,"kdfIterations":600000}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(api.AccountRegister).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var registered, other database.User
+	api.db.DB.Where("email = ?", "new@test.com").First(&registered)
+	defer api.db.DB.Delete(&registered)
+	if strings.Contains(registered.MasterPassword, "clienthash") || database.MasterPasswordNeedsRehash(registered.MasterPassword) {
+		t.Errorf("master password was not hashed: got %v", registered.MasterPassword)
+	}
+	if validateCredentials(&registered, "clienthash") != nil || validateCredentials(&registered, "otherhash") == nil {
+		t.Error("hashed master password was not verified correctly")
+	}
+
+	// The same password gets another salt
+	other.MasterPassword, _ = database.HashMasterPassword("clienthash")
+	if other.MasterPassword == registered.MasterPassword {
+		t.Error("master password hashes are not salted")
+	}
+
+	// Legacy rows are upgraded on the next login
+	user := createUser(t, api.db.DB)
+	if rr := passwordLogin(t, api, ""); rr.Code != http.StatusOK {
+		t.Fatalf("login with a legacy row failed: got %v %s", rr.Code, rr.Body.String())
+	}
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.MasterPassword == "notarealhash" || database.MasterPasswordNeedsRehash(storedUser.MasterPassword) {
+		t.Errorf("legacy master password was not rehashed: got %v", storedUser.MasterPassword)
+	}
+	if rr := passwordLogin(t, api, ""); rr.Code != http.StatusOK {
+		t.Errorf("login after the rehash failed: got %v %s", rr.Code, rr.Body.String())
+	}
+}
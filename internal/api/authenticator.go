@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+const (
+	totpPeriod = 30 // seconds
+	totpDrift  = 1  // accepted steps before and after the current one
+)
+
+// authenticatorMetaData is stored with the authenticator provider of a user.
+type authenticatorMetaData struct {
+	Key          string `json:"Key"`
+	LastUsedStep int64  `json:"LastUsedStep,omitempty"` // steps up to this one were used already
+}
+
+// TwoFactorGetAuthenticator returns the authenticator secret of the authenticated user. If the authenticator
+// is not enabled yet, a new secret is generated which has to be confirmed with a code.
+func (a *API) TwoFactorGetAuthenticator(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("get authenticator, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("get authenticator decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	response := bw.TwoFactorAuthenticatorResponseModel{Object: "twoFactorAuthenticator"}
+	if provider, ok := user.GetTwoFactorProviders()[database.TwoFactorProviderAuthenticator]; ok && provider.Enabled {
+		var metaData authenticatorMetaData
+		if err := json.Unmarshal(provider.MetaData, &metaData); err != nil {
+			log.Errorf("get authenticator, invalid meta data for %s: %s", user.Email, err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		response.Enabled = true
+		response.Key = metaData.Key
+	} else {
+		key, err := newTotpKey()
+		if err != nil {
+			log.Errorf("get authenticator, failed to read rand: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		response.Key = key
+	}
+
+	MustRespondJSON(w, &response)
+}
+
+// TwoFactorAuthenticator enables the authenticator provider. The user has to prove that the authenticator
+// app was set up correctly by sending a valid code for the new secret.
+func (a *API) TwoFactorAuthenticator(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("authenticator, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.TwoFactorAuthenticatorRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("authenticator decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	key := strings.ToUpper(strings.Replace(requestData.Key, " ", "", -1))
+	secret, err := decodeTotpKey(key)
+	if err != nil || len(secret) < 10 {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	step, ok := validateTotp(secret, requestData.Token, 0, time.Now())
+	if !ok {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	metaData, err := json.Marshal(&authenticatorMetaData{Key: key, LastUsedStep: step})
+	if err != nil {
+		log.Errorf("authenticator, failed to encode meta data: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	providers := user.GetTwoFactorProviders()
+	providers[database.TwoFactorProviderAuthenticator] = database.TwoFactorProvider{Enabled: true, MetaData: metaData}
+	if err := a.storeTwoFactorProviders(user, providers); err != nil {
+		log.Errorf("authenticator, failed to store providers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	MustRespondJSON(w, &bw.TwoFactorAuthenticatorResponseModel{Enabled: true, Key: key, Object: "twoFactorAuthenticator"})
+}
+
+// validateAuthenticatorToken checks a code of the authenticator app. Every code can only be used once: the
+// step of the accepted code is stored, the update only succeeds if no other login used the code meanwhile.
+func (a *API) validateAuthenticatorToken(user *database.User, token string) (bool, error) {
+	providers := user.GetTwoFactorProviders()
+	provider, ok := providers[database.TwoFactorProviderAuthenticator]
+	if !ok || !provider.Enabled {
+		return false, nil
+	}
+
+	var metaData authenticatorMetaData
+	if err := json.Unmarshal(provider.MetaData, &metaData); err != nil {
+		return false, err
+	}
+	secret, err := decodeTotpKey(metaData.Key)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := validateTotp(secret, token, metaData.LastUsedStep, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	metaData.LastUsedStep = step
+	if provider.MetaData, err = json.Marshal(&metaData); err != nil {
+		return false, err
+	}
+	providers[database.TwoFactorProviderAuthenticator] = provider
+
+	return a.updateTwoFactorProviders(user, providers)
+}
+
+// newTotpKey generates a new random authenticator secret in the base32 format authenticator apps expect.
+func newTotpKey() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// decodeTotpKey converts a base32 authenticator secret to bytes.
+func decodeTotpKey(key string) ([]byte, error) {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(key, "="))
+}
+
+// totpCode calculates the six digit code of a time step as specified in RFC 6238.
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// validateTotp checks the code against the current time step and the steps within the drift window. Steps
+// up to lastUsedStep are rejected to prevent replay attacks. The matching step is returned.
+func validateTotp(secret []byte, code string, lastUsedStep int64, now time.Time) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != 6 {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpDrift; step <= current+totpDrift; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
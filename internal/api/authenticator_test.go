@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+)
+
+// passwordLogin sends a password login request for the test user, extra form fields can be appended.
+func passwordLogin(t *testing.T, api *API, extra string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(
+		"grant_type=password"+
+			"&username=test@test.com"+
+			"&password=notarealhash"+
+			"&scope=api offline_access"+
+			"&client_id=browser"+
+			"&deviceType=3"+
+			"&deviceIdentifier=sample-device"+
+			"&deviceName=firefox"+extra))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(api.AuthToken).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTotpCode(t *testing.T) {
+	// Test vectors of RFC 6238, truncated to six digits
+	secret := []byte("12345678901234567890")
+	for unix, code := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924"} {
+		if got := totpCode(secret, unix/totpPeriod); got != code {
+			t.Errorf("unexpected code for %d: got %s want %s", unix, got, code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+	if _, ok := validateTotp(secret, totpCode(secret, step-1), 0, now); !ok {
+		t.Errorf("code of the previous step was rejected")
+	}
+	if _, ok := validateTotp(secret, totpCode(secret, step-2), 0, now); ok {
+		t.Errorf("code outside of the drift window was accepted")
+	}
+	if _, ok := validateTotp(secret, totpCode(secret, step), step, now); ok {
+		t.Errorf("used code was accepted again")
+	}
+}
+
+func TestAuthenticatorLogin(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	// Get a new secret
+	req, _ := http.NewRequest("POST", "/api/two-factor/get-authenticator", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+	rr := serveAuthenticated(t, api, user, api.TwoFactorGetAuthenticator, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var authenticator common.TwoFactorAuthenticatorResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &authenticator); err != nil {
+		t.Fatal(err)
+	}
+	if authenticator.Enabled || authenticator.Key == "" {
+		t.Fatalf("handler returned unexpected authenticator: got %v", authenticator)
+	}
+	secret, _ := decodeTotpKey(authenticator.Key)
+	step := time.Now().Unix() / totpPeriod
+
+	// Enable it
+	req, _ = http.NewRequest("POST", "/api/two-factor/authenticator", strings.NewReader(
+		`{"masterPasswordHash":"notarealhash","key":"`+authenticator.Key+`","token":"`+totpCode(secret, step)+`"}`))
+	rr = serveAuthenticated(t, api, user, api.TwoFactorAuthenticator, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", status, http.StatusOK, rr.Body.String())
+	}
+
+	// A password alone is not enough anymore
+	rr = passwordLogin(t, api, "")
+	var challenge common.TwoFactorChallengeModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := challenge.TwoFactorProviders2["0"]; rr.Code != http.StatusBadRequest || !ok {
+		t.Fatalf("login did not ask for the second factor: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// The code used for enabling the authenticator cannot be used again
+	rr = passwordLogin(t, api, "&twoFactorProvider=0&twoFactorToken="+totpCode(secret, step))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("replayed code was accepted: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	rr = passwordLogin(t, api, "&twoFactorProvider=0&twoFactorToken="+totpCode(secret, step+1))
+	if status := rr.Code; status != http.StatusOK || !strings.Contains(rr.Body.String(), "access_token") {
+		t.Fatalf("login with a valid code failed: got %v %s", status, rr.Body.String())
+	}
+
+	rr = passwordLogin(t, api, "&twoFactorProvider=0&twoFactorToken="+totpCode(secret, step+1))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("replayed code was accepted: got %v want %v", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,690 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// encStringPattern matches the "<type>.<data>" format of the encrypted strings produced by the clients.
+var encStringPattern = regexp.MustCompile(`^[0-6]\.[A-Za-z0-9+/=]+(\|[A-Za-z0-9+/=]+){0,2}$`)
+
+// ImportTimeout limits the time of a vault import. Imports of thousands of items take longer than the timeout
+// of the other requests.
+const ImportTimeout = 30 * time.Minute
+
+// CipherList returns all ciphers of the authenticated user.
+func (a *API) CipherList(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var ciphers []database.Cipher
+	if err := access.cipherQuery(a.db.DB).Find(&ciphers).Error; err != nil {
+		log.Errorf("cipher list, failed to load ciphers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	cipherResponses := make([]bw.CipherResponseModel, 0, len(ciphers))
+	for i := range ciphers {
+		cipherResponse, err := a.cipherResponseFromModel(req, &ciphers[i], access)
+		if err != nil {
+			log.Errorf("cipher list, failed to decode cipher %d: %s", ciphers[i].Id, err.Error())
+			continue
+		}
+		cipherResponses = append(cipherResponses, cipherResponse)
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: cipherResponses, Object: "list"})
+}
+
+// CipherCreate stores a new cipher for the authenticated user.
+func (a *API) CipherCreate(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.CipherRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("cipher create decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.createCipher(w, req, &requestData, nil)
+}
+
+// CipherCreateWrapped stores a new cipher for the authenticated user. The cipher is wrapped together with the
+// collections it should be assigned to.
+func (a *API) CipherCreateWrapped(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.CipherCreateRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("cipher create decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.createCipher(w, req, &requestData.Cipher, requestData.CollectionIds)
+}
+
+func (a *API) createCipher(w http.ResponseWriter, req *http.Request, requestData *bw.CipherRequestModel, collectionIdStrings []string) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher create, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := access.user
+
+	if err := validateCipherRequest(requestData); err != nil {
+		log.Errorf("cipher create, invalid cipher: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.validateFolder(user, requestData.FolderId); err != nil {
+		log.Errorf("cipher create, invalid folder: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collectionIds, err := parseIds(collectionIdStrings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := access.canCreateIn(requestData.OrganizationId, collectionIds); err != nil {
+		log.Errorf("cipher create, invalid organization: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	currentTime := time.Now()
+	cipher := database.Cipher{
+		CreationDate: currentTime,
+		RevisionDate: currentTime,
+	}
+	if requestData.OrganizationId != nil {
+		cipher.OrganizationId = requestData.OrganizationId
+	} else {
+		cipher.UserId = &user.Id
+	}
+	if err := applyCipherRequest(&cipher, requestData, user.Id); err != nil {
+		log.Errorf("cipher create, failed to encode cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&cipher).Error; err != nil {
+			return err
+		}
+		for _, collectionId := range collectionIds {
+			if err := tx.Create(&database.CollectionCipher{CollectionId: collectionId, CipherId: cipher.Id}).Error; err != nil {
+				return err
+			}
+		}
+		return database.UpdateCipherRevisionDate(tx, &cipher)
+	})
+	if err != nil {
+		log.Errorf("cipher create, failed to store cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(collectionIds) > 0 {
+		access.cipherCollections[cipher.Id] = collectionIds
+	}
+	a.notifyCipher(req, notifications.SyncCipherCreate, &cipher)
+
+	a.respondCipher(w, req, &cipher, access)
+}
+
+// CipherGet returns a single cipher of the authenticated user.
+func (a *API) CipherGet(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher get, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("cipher get, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	a.respondCipher(w, req, cipher, access)
+}
+
+// CipherUpdate replaces the content of a cipher of the authenticated user.
+func (a *API) CipherUpdate(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher update, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := access.user
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("cipher update, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !access.canEdit(cipher) {
+		http.Error(w, "you do not have permission to edit this cipher", http.StatusForbidden)
+		return
+	}
+
+	var requestData bw.CipherRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("cipher update decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCipherRequest(&requestData); err != nil {
+		log.Errorf("cipher update, invalid cipher: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Type != cipher.Type {
+		http.Error(w, "cipher type cannot be changed", http.StatusBadRequest)
+		return
+	}
+	if !sameId(requestData.OrganizationId, cipher.OrganizationId) {
+		http.Error(w, "cipher organization cannot be changed", http.StatusBadRequest)
+		return
+	}
+
+	// Reject updates based on an outdated version of the cipher, one second of tolerance for rounding issues
+	if requestData.LastKnownRevisionDate != nil && cipher.RevisionDate.Sub(*requestData.LastKnownRevisionDate) > time.Second {
+		http.Error(w, "the cipher you are updating is out of date, please sync", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.validateFolder(user, requestData.FolderId); err != nil {
+		log.Errorf("cipher update, invalid folder: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cipher.RevisionDate = time.Now()
+	if err := applyCipherRequest(cipher, &requestData, user.Id); err != nil {
+		log.Errorf("cipher update, failed to encode cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		// Attachments are managed by their own endpoints, uploads may run concurrently
+		err := tx.Model(&database.Cipher{}).Where("id = ?", cipher.Id).UpdateColumns(map[string]interface{}{
+			"data":          cipher.Data,
+			"folders":       cipher.Folders,
+			"favorites":     cipher.Favorites,
+			"revision_date": cipher.RevisionDate,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return database.UpdateCipherRevisionDate(tx, cipher)
+	})
+	if err != nil {
+		log.Errorf("cipher update, failed to store cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyCipher(req, notifications.SyncCipherUpdate, cipher)
+
+	a.respondCipher(w, req, cipher, access)
+}
+
+// CipherDelete removes a cipher of the authenticated user.
+func (a *API) CipherDelete(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher delete, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("cipher delete, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !access.canEdit(cipher) {
+		http.Error(w, "you do not have permission to edit this cipher", http.StatusForbidden)
+		return
+	}
+
+	var attachments map[string]database.Attachment
+	var collectionIds []uint64
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		// Release the storage of the attachments stored now, uploads may have finished since the cipher was loaded
+		var storedCipher database.Cipher
+		if err := tx.First(&storedCipher, cipher.Id).Error; err != nil {
+			return err
+		}
+		attachments = storedCipher.GetAttachments()
+		var attachmentSize int64
+		for _, attachment := range attachments {
+			attachmentSize += attachment.Size
+		}
+
+		result := tx.Where("attachments = ?", storedCipher.Attachments).Delete(cipher)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errCipherModified
+		}
+		// The members of the collections are notified about the deletion
+		var err error
+		if collectionIds, err = cipherCollectionIds(tx, cipher); err != nil {
+			return err
+		}
+		if err := tx.Where("cipher_id = ?", cipher.Id).Delete(&database.CollectionCipher{}).Error; err != nil {
+			return err
+		}
+		if err := database.UpdateCipherStorage(tx, cipher, -attachmentSize); err != nil {
+			return err
+		}
+		return database.UpdateCipherRevisionDate(tx, cipher)
+	})
+	if err == errCipherModified {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Errorf("cipher delete, failed to delete cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyCipherInCollections(req, notifications.SyncLoginDelete, cipher, collectionIds)
+
+	for attachmentId := range attachments {
+		if err := a.storage.Delete(database.AttachmentStorageName(cipher.Id, attachmentId)); err != nil {
+			log.Errorf("cipher delete, failed to delete attachment %s: %s", attachmentId, err.Error())
+		}
+	}
+}
+
+// CipherCollectionsUpdate changes the collections an organization cipher is assigned to. Assignments to
+// collections the user may not write to are kept.
+func (a *API) CipherCollectionsUpdate(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher collections, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	cipher, err := a.getCipherFromRequest(req, access)
+	if err != nil {
+		log.Errorf("cipher collections, unable to load cipher: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if cipher.OrganizationId == nil {
+		http.Error(w, "personal ciphers cannot be assigned to collections", http.StatusBadRequest)
+		return
+	}
+	if !access.canEdit(cipher) {
+		http.Error(w, "you do not have permission to edit this cipher", http.StatusForbidden)
+		return
+	}
+
+	var requestData bw.CipherCollectionsRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("cipher collections decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	collectionIds, err := parseIds(requestData.CollectionIds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Clients send all collections of the cipher, assignments the user may not change are kept as they are
+	var assignedIds []uint64
+	err = a.db.DB.Model(&database.CollectionCipher{}).Where("cipher_id = ?", cipher.Id).
+		Pluck("collection_id", &assignedIds).Error
+	if err != nil {
+		log.Errorf("cipher collections, unable to load collections: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	keptIds := make(map[uint64]bool)
+	for _, collectionId := range assignedIds {
+		if !access.canWriteCollection(collectionId) {
+			keptIds[collectionId] = true
+		}
+	}
+	var changedIds []uint64
+	for _, collectionId := range collectionIds {
+		if !keptIds[collectionId] {
+			changedIds = append(changedIds, collectionId)
+		}
+	}
+	if len(changedIds) > 0 || len(keptIds) == 0 {
+		if err := access.canCreateIn(cipher.OrganizationId, changedIds); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var writable []uint64
+	for collectionId, collection := range access.collections {
+		if collection.collection.OrganizationId == *cipher.OrganizationId && access.canWriteCollection(collectionId) {
+			writable = append(writable, collectionId)
+		}
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if len(writable) > 0 {
+			err := tx.Where("cipher_id = ? AND collection_id IN (?)", cipher.Id, writable).
+				Delete(&database.CollectionCipher{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, collectionId := range changedIds {
+			if err := tx.Create(&database.CollectionCipher{CollectionId: collectionId, CipherId: cipher.Id}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(cipher).UpdateColumn("revision_date", time.Now()).Error; err != nil {
+			return err
+		}
+		return database.UpdateCipherRevisionDate(tx, cipher)
+	})
+	if err != nil {
+		log.Errorf("cipher collections, failed to store collections: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyCipher(req, notifications.SyncCipherUpdate, cipher)
+	a.notifyCipherRevoked(req, cipher, assignedIds)
+}
+
+// CipherImport stores a whole vault export of another password manager. All folders and ciphers are created
+// in a single transaction, the import either succeeds completely or not at all. The route has to be mounted
+// with ImportTimeout instead of the default request timeout.
+func (a *API) CipherImport(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("cipher import, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.ImportCiphersRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("cipher import decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate everything before touching the database
+	for i := range requestData.Folders {
+		if requestData.Folders[i].Name == "" {
+			http.Error(w, "folder "+strconv.Itoa(i)+": name is missing", http.StatusBadRequest)
+			return
+		}
+	}
+	for i := range requestData.Ciphers {
+		if err := validateCipherRequest(&requestData.Ciphers[i]); err != nil {
+			http.Error(w, "cipher "+strconv.Itoa(i)+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	cipherFolders := make(map[int]int, len(requestData.FolderRelationships)) // cipher index -> folder index
+	for _, relation := range requestData.FolderRelationships {
+		if relation.Key < 0 || relation.Key >= len(requestData.Ciphers) ||
+			relation.Value < 0 || relation.Value >= len(requestData.Folders) {
+			http.Error(w, "invalid folder relationship", http.StatusBadRequest)
+			return
+		}
+		cipherFolders[relation.Key] = relation.Value
+	}
+
+	log.Infof("User %s is importing %d ciphers and %d folders", user.Email,
+		len(requestData.Ciphers), len(requestData.Folders))
+
+	currentTime := time.Now()
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		folderIds := make([]uint64, len(requestData.Folders))
+		for i := range requestData.Folders {
+			folder := database.Folder{
+				UserId:       user.Id,
+				Name:         requestData.Folders[i].Name,
+				CreationDate: currentTime,
+				RevisionDate: currentTime,
+			}
+			if err := tx.Create(&folder).Error; err != nil {
+				return err
+			}
+			folderIds[i] = folder.Id
+		}
+
+		for i := range requestData.Ciphers {
+			// Abort if the client gave up or the request timed out, the transaction gets rolled back
+			if err := req.Context().Err(); err != nil {
+				return err
+			}
+
+			cipherRequest := &requestData.Ciphers[i]
+			cipherRequest.OrganizationId = nil
+			cipherRequest.FolderId = nil
+			if folderIndex, ok := cipherFolders[i]; ok {
+				cipherRequest.FolderId = &folderIds[folderIndex]
+			}
+
+			cipher := database.Cipher{
+				UserId:       &user.Id,
+				CreationDate: currentTime,
+				RevisionDate: currentTime,
+			}
+			if err := applyCipherRequest(&cipher, cipherRequest, user.Id); err != nil {
+				return err
+			}
+			if err := tx.Create(&cipher).Error; err != nil {
+				return err
+			}
+		}
+
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("cipher import, failed to store vault: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyUser(req, notifications.SyncVault, user.Id)
+}
+
+// getCipherFromRequest loads the cipher referenced by the id URL parameter. The user must be allowed to see the cipher.
+func (a *API) getCipherFromRequest(req *http.Request, access *userAccess) (*database.Cipher, error) {
+	cipherId, err := getIdParam(req, "id")
+	if err != nil {
+		return nil, errors.New("invalid cipher id")
+	}
+
+	var cipher database.Cipher
+	if err := a.db.DB.Where("id = ?", cipherId).First(&cipher).Error; err != nil {
+		return nil, err
+	}
+	if !access.canRead(&cipher) {
+		return nil, errors.New("cipher not accessible")
+	}
+
+	return &cipher, nil
+}
+
+// validateFolder checks that the folder, if set, exists and belongs to the given user.
+func (a *API) validateFolder(user *database.User, folderId *uint64) error {
+	if folderId == nil {
+		return nil
+	}
+
+	if a.db.DB.Where("id = ? AND user_id = ?", *folderId, user.Id).First(&database.Folder{}).RecordNotFound() {
+		return errors.New("invalid folder")
+	}
+
+	return nil
+}
+
+// respondCipher writes the cipher in the structure the clients expect.
+func (a *API) respondCipher(w http.ResponseWriter, req *http.Request, cipher *database.Cipher, access *userAccess) {
+	cipherResponse, err := a.cipherResponseFromModel(req, cipher, access)
+	if err != nil {
+		log.Errorf("failed to decode cipher %d: %s", cipher.Id, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	MustRespondJSON(w, &cipherResponse)
+}
+
+// validateCipherRequest checks that the request contains exactly the data of its cipher type and that all
+// fields contain encrypted strings.
+func validateCipherRequest(model *bw.CipherRequestModel) error {
+	typeData := 0
+	for _, set := range []bool{model.Login != nil, model.SecureNote != nil, model.Card != nil, model.Identity != nil} {
+		if set {
+			typeData++
+		}
+	}
+	if typeData > 1 {
+		return errors.New("cipher contains data of multiple types")
+	}
+
+	encrypted := []*string{&model.Name, model.Notes}
+	for _, field := range model.Fields {
+		encrypted = append(encrypted, field.Name, field.Value)
+	}
+	for i := range model.PasswordHistory {
+		encrypted = append(encrypted, &model.PasswordHistory[i].Password)
+	}
+
+	switch model.Type {
+	case bw.CipherTypeLogin:
+		if model.Login == nil {
+			return errors.New("login data is missing")
+		}
+		encrypted = append(encrypted, model.Login.Username, model.Login.Password, model.Login.Totp)
+		for _, uri := range model.Login.Uris {
+			encrypted = append(encrypted, uri.Uri)
+		}
+	case bw.CipherTypeSecureNote:
+		if model.SecureNote == nil {
+			return errors.New("secure note data is missing")
+		}
+	case bw.CipherTypeCard:
+		if model.Card == nil {
+			return errors.New("card data is missing")
+		}
+		c := model.Card
+		encrypted = append(encrypted, c.CardholderName, c.Brand, c.Number, c.ExpMonth, c.ExpYear, c.Code)
+	case bw.CipherTypeIdentity:
+		if model.Identity == nil {
+			return errors.New("identity data is missing")
+		}
+		i := model.Identity
+		encrypted = append(encrypted, i.Title, i.FirstName, i.MiddleName, i.LastName, i.Address1, i.Address2,
+			i.Address3, i.City, i.State, i.PostalCode, i.Country, i.Company, i.Email, i.Phone, i.SSN, i.Username,
+			i.PassportNumber, i.LicenseNumber)
+	default:
+		return errors.New("unsupported cipher type")
+	}
+
+	if model.Name == "" {
+		return errors.New("name is missing")
+	}
+	for _, value := range encrypted {
+		if value != nil && *value != "" && !encStringPattern.MatchString(*value) {
+			return errors.New("cipher contains unencrypted data")
+		}
+	}
+
+	return nil
+}
+
+// applyCipherRequest copies the (already validated) request data into the cipher record.
+func applyCipherRequest(cipher *database.Cipher, model *bw.CipherRequestModel, userId uint64) error {
+	data := bw.CipherData{
+		Name:            model.Name,
+		Notes:           model.Notes,
+		Fields:          model.Fields,
+		PasswordHistory: model.PasswordHistory,
+		Login:           model.Login,
+		Card:            model.Card,
+		Identity:        model.Identity,
+		SecureNote:      model.SecureNote,
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	cipher.Type = model.Type
+	cipher.Data = string(dataJSON)
+	if err := cipher.SetFolderId(userId, model.FolderId); err != nil {
+		return err
+	}
+	return cipher.SetFavorite(userId, model.Favorite)
+}
+
+// cipherResponseFromModel decodes the JSON data of the cipher record and converts it to the cipher structure
+// the clients expect. Folder, favorite and permission flags are specific to the given user.
+func (a *API) cipherResponseFromModel(req *http.Request, cipher *database.Cipher, access *userAccess) (bw.CipherResponseModel, error) {
+	var data bw.CipherData
+	if err := json.Unmarshal([]byte(cipher.Data), &data); err != nil {
+		return bw.CipherResponseModel{}, err
+	}
+
+	cipherResponse := bw.CipherResponseModel{
+		Id:                  cipher.Id,
+		OrganizationId:      cipher.OrganizationId,
+		FolderId:            cipher.GetFolderId(access.user.Id),
+		Type:                cipher.Type,
+		Name:                data.Name,
+		Notes:               data.Notes,
+		Fields:              data.Fields,
+		PasswordHistory:     data.PasswordHistory,
+		Login:               data.Login,
+		Card:                data.Card,
+		Identity:            data.Identity,
+		SecureNote:          data.SecureNote,
+		Attachments:         a.attachmentResponsesFromModel(req, cipher),
+		Favorite:            cipher.IsFavorite(access.user.Id),
+		Edit:                access.canEdit(cipher),
+		ViewPassword:        access.canViewPassword(cipher),
+		OrganizationUseTotp: access.organizationUseTotp(cipher),
+		CollectionIds:       make([]string, len(access.cipherCollections[cipher.Id])),
+		RevisionDate:        cipher.RevisionDate,
+		Object:              "cipherDetails",
+	}
+
+	for i, collectionId := range access.cipherCollections[cipher.Id] {
+		cipherResponse.CollectionIds[i] = strconv.FormatUint(collectionId, 10)
+	}
+
+	return cipherResponse, nil
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+const testEncString = "2.QmFzZTY0SVY=|Q2lwaGVydGV4dA==|TUFD"
+
+func TestCipherCreateUpdateDelete(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	// Create
+	req, _ := http.NewRequest("POST", "/api/ciphers", strings.NewReader(
+		`{"type":1,"name":"`+testEncString+`","favorite":true,`+
+			`"login":{"username":"`+testEncString+`","password":"`+testEncString+`","uris":[{"uri":"`+testEncString+`","match":null}]}}`))
+	rr := serveAuthenticated(t, api, user, api.CipherCreate, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+
+	var cipherResponse common.CipherResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &cipherResponse); err != nil {
+		t.Fatal(err)
+	}
+	if cipherResponse.Id == 0 || cipherResponse.Login == nil || !cipherResponse.Favorite || len(cipherResponse.Login.Uris) != 1 {
+		t.Errorf("handler returned unexpected cipher: got %v", cipherResponse)
+	}
+	cipherId := strconv.FormatUint(cipherResponse.Id, 10)
+
+	// Update
+	req, _ = http.NewRequest("PUT", "/api/ciphers/"+cipherId, strings.NewReader(
+		`{"type":1,"name":"`+testEncString+`","notes":"`+testEncString+`","login":{"username":null,"password":null}}`))
+	req = withURLParam(req, "id", cipherId)
+	rr = serveAuthenticated(t, api, user, api.CipherUpdate, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+
+	cipherResponse = common.CipherResponseModel{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &cipherResponse); err != nil {
+		t.Fatal(err)
+	}
+	if cipherResponse.Notes == nil || cipherResponse.Favorite || cipherResponse.Login.Username != nil {
+		t.Errorf("handler returned unexpected cipher: got %v", cipherResponse)
+	}
+
+	// Delete
+	req, _ = http.NewRequest("DELETE", "/api/ciphers/"+cipherId, nil)
+	req = withURLParam(req, "id", cipherId)
+	rr = serveAuthenticated(t, api, user, api.CipherDelete, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if !api.db.DB.First(&database.Cipher{}, cipherResponse.Id).RecordNotFound() {
+		t.Errorf("cipher was not deleted")
+	}
+}
+
+func TestCipherCreateAllTypes(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	for _, body := range []string{
+		`{"type":2,"name":"` + testEncString + `","secureNote":{"type":0}}`,
+		`{"type":3,"name":"` + testEncString + `","card":{"number":"` + testEncString + `","code":"` + testEncString + `"}}`,
+		`{"type":4,"name":"` + testEncString + `","identity":{"firstName":"` + testEncString + `","ssn":null}}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/ciphers/create", strings.NewReader(`{"cipher":`+body+`,"collectionIds":[]}`))
+		rr := serveAuthenticated(t, api, user, api.CipherCreateWrapped, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v, %s",
+				body, status, http.StatusOK, rr.Body.String())
+		}
+	}
+}
+
+func TestCipherCreateInvalid(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	tests := map[string]string{
+		`{"type":9,"name":"` + testEncString + `"}`:                                                  "unsupported cipher type",
+		`{"type":1,"name":"` + testEncString + `"}`:                                                  "login data is missing",
+		`{"type":1,"name":"` + testEncString + `","login":{},"card":{}}`:                             "cipher contains data of multiple types",
+		`{"type":1,"name":"","login":{}}`:                                                            "name is missing",
+		`{"type":1,"name":"` + testEncString + `","login":{"password":"plaintext"}}`:                 "cipher contains unencrypted data",
+		`{"type":1,"name":"` + testEncString + `","folderId":"12345","login":{}}`:                    "invalid folder",
+		`{"type":3,"name":"` + testEncString + `","card":{"number":"` + testEncString + `|broken"}}`: "cipher contains unencrypted data",
+	}
+	for body, expected := range tests {
+		req, _ := http.NewRequest("POST", "/api/ciphers", strings.NewReader(body))
+		rr := serveAuthenticated(t, api, user, api.CipherCreate, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v",
+				body, status, http.StatusBadRequest)
+		}
+		if strings.TrimSpace(rr.Body.String()) != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				strings.TrimSpace(rr.Body.String()), expected)
+		}
+	}
+}
+
+func TestCipherImport(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	// Build a large import, every second cipher is stored in the second folder
+	const cipherCount = 5000
+	importData := common.ImportCiphersRequestModel{
+		Folders: []common.FolderRequestModel{{Name: testEncString}, {Name: testEncString}},
+	}
+	for i := 0; i < cipherCount; i++ {
+		password := testEncString
+		importData.Ciphers = append(importData.Ciphers, common.CipherRequestModel{
+			Type:  common.CipherTypeLogin,
+			Name:  testEncString,
+			Login: &common.CipherLoginModel{Password: &password},
+		})
+		if i%2 == 0 {
+			importData.FolderRelationships = append(importData.FolderRelationships, common.KeyValuePairModel{Key: i, Value: 1})
+		}
+	}
+	body, _ := json.Marshal(importData)
+
+	// Serve the import with the timeout of its route
+	started := time.Now()
+	req, _ := http.NewRequest("POST", "/api/ciphers/import", strings.NewReader(string(body)))
+	rr := serveAuthenticated(t, api, user, middleware.Timeout(ImportTimeout)(http.HandlerFunc(api.CipherImport)).ServeHTTP, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	if elapsed := time.Since(started); elapsed > ImportTimeout/10 {
+		t.Errorf("import is too slow for its timeout: took %v", elapsed)
+	}
+
+	var folders []database.Folder
+	api.db.DB.Where("user_id = ?", user.Id).Order("id").Find(&folders)
+	var ciphers []database.Cipher
+	api.db.DB.Where("user_id = ?", user.Id).Order("id").Find(&ciphers)
+	if len(folders) != 2 || len(ciphers) != cipherCount {
+		t.Fatalf("unexpected number of imported items: got %d folders and %d ciphers", len(folders), len(ciphers))
+	}
+	if folderId := ciphers[0].GetFolderId(user.Id); folderId == nil || *folderId != folders[1].Id {
+		t.Errorf("cipher 0 was not assigned to folder %d: %s", folders[1].Id, ciphers[0].Folders)
+	}
+	if folderId := ciphers[1].GetFolderId(user.Id); folderId != nil {
+		t.Errorf("cipher 1 was unexpectedly assigned to folder %d", *folderId)
+	}
+}
+
+func TestCipherImportRollback(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	// The last cipher is invalid, nothing must be imported
+	req, _ := http.NewRequest("POST", "/api/ciphers/import", strings.NewReader(
+		`{"folders":[{"name":"`+testEncString+`"}],"ciphers":[`+
+			`{"type":2,"name":"`+testEncString+`","secureNote":{"type":0}},`+
+			`{"type":1,"name":"`+testEncString+`","login":{"password":"plaintext"}}],`+
+			`"folderRelationships":[{"key":0,"value":0}]}`))
+	rr := serveAuthenticated(t, api, user, api.CipherImport, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if strings.TrimSpace(rr.Body.String()) != "cipher 1: cipher contains unencrypted data" {
+		t.Errorf("handler returned unexpected body: got %v", strings.TrimSpace(rr.Body.String()))
+	}
+
+	var folderCount, cipherCount int
+	api.db.DB.Model(&database.Folder{}).Where("user_id = ?", user.Id).Count(&folderCount)
+	api.db.DB.Model(&database.Cipher{}).Where("user_id = ?", user.Id).Count(&cipherCount)
+	if folderCount != 0 || cipherCount != 0 {
+		t.Errorf("import was not rolled back: got %d folders and %d ciphers", folderCount, cipherCount)
+	}
+
+	// Invalid folder relationship
+	req, _ = http.NewRequest("POST", "/api/ciphers/import", strings.NewReader(
+		`{"folders":[],"ciphers":[{"type":2,"name":"`+testEncString+`","secureNote":{"type":0}}],`+
+			`"folderRelationships":[{"key":0,"value":3}]}`))
+	rr = serveAuthenticated(t, api, user, api.CipherImport, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
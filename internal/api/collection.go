@@ -0,0 +1,409 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// CollectionList returns all collections the authenticated user can see, in all organizations.
+func (a *API) CollectionList(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("collection list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: collectionResponses(access, nil), Object: "list"})
+}
+
+// OrganizationCollectionList returns the collections of an organization the authenticated user can see.
+func (a *API) OrganizationCollectionList(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	organizationId, err := getIdParam(req, "id")
+	if _, ok := access.organizations[organizationId]; err != nil || !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: collectionResponses(access, &organizationId), Object: "list"})
+}
+
+// OrganizationCollectionCreate creates a new collection. Only administrators may manage collections.
+func (a *API) OrganizationCollectionCreate(w http.ResponseWriter, req *http.Request) {
+	membership, ok := a.requireOrganizationAdmin(w, req, "organization collection create")
+	if !ok {
+		return
+	}
+
+	var requestData bw.CollectionRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("organization collection create decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Name) {
+		http.Error(w, "name is missing or unencrypted", http.StatusBadRequest)
+		return
+	}
+	if err := a.validateCollectionUsers(membership.OrganizationId, requestData.Users); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	currentTime := time.Now()
+	collection := database.Collection{
+		OrganizationId: membership.OrganizationId,
+		Name:           requestData.Name,
+		ExternalId:     requestData.ExternalId,
+		CreationDate:   currentTime,
+		RevisionDate:   currentTime,
+	}
+
+	err := a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&collection).Error; err != nil {
+			return err
+		}
+		if err := storeCollectionUsers(tx, collection.Id, requestData.Users); err != nil {
+			return err
+		}
+		return database.UpdateOrganizationRevisionDate(tx, collection.OrganizationId)
+	})
+	if err != nil {
+		log.Errorf("organization collection create, failed to store collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyOrganization(req, notifications.SyncVault, collection.OrganizationId)
+
+	collectionResponse := collectionResponseFromModel(&collection)
+	MustRespondJSON(w, &collectionResponse)
+}
+
+// OrganizationCollectionGet returns a single collection of an organization.
+func (a *API) OrganizationCollectionGet(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection get, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	collection, err := a.getCollectionFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection get, unable to load collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if _, ok := access.collections[collection.Id]; !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	collectionResponse := collectionResponseFromModel(collection)
+	MustRespondJSON(w, &collectionResponse)
+}
+
+// OrganizationCollectionUpdate renames a collection. If the request contains users, the member assignments
+// are replaced too.
+func (a *API) OrganizationCollectionUpdate(w http.ResponseWriter, req *http.Request) {
+	membership, ok := a.requireOrganizationAdmin(w, req, "organization collection update")
+	if !ok {
+		return
+	}
+
+	collection, err := a.getCollectionFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection update, unable to load collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var requestData bw.CollectionRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("organization collection update decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Name) {
+		http.Error(w, "name is missing or unencrypted", http.StatusBadRequest)
+		return
+	}
+	if err := a.validateCollectionUsers(membership.OrganizationId, requestData.Users); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection.Name = requestData.Name
+	collection.ExternalId = requestData.ExternalId
+	collection.RevisionDate = time.Now()
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(collection).Error; err != nil {
+			return err
+		}
+		if requestData.Users != nil {
+			if err := tx.Where("collection_id = ?", collection.Id).Delete(&database.CollectionUser{}).Error; err != nil {
+				return err
+			}
+			if err := storeCollectionUsers(tx, collection.Id, requestData.Users); err != nil {
+				return err
+			}
+		}
+		return database.UpdateOrganizationRevisionDate(tx, collection.OrganizationId)
+	})
+	if err != nil {
+		log.Errorf("organization collection update, failed to store collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyOrganization(req, notifications.SyncVault, collection.OrganizationId)
+
+	collectionResponse := collectionResponseFromModel(collection)
+	MustRespondJSON(w, &collectionResponse)
+}
+
+// OrganizationCollectionDelete removes a collection. The ciphers of the collection are kept.
+func (a *API) OrganizationCollectionDelete(w http.ResponseWriter, req *http.Request) {
+	if _, ok := a.requireOrganizationAdmin(w, req, "organization collection delete"); !ok {
+		return
+	}
+
+	collection, err := a.getCollectionFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection delete, unable to load collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.Id).Delete(&database.CollectionUser{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("collection_id = ?", collection.Id).Delete(&database.CollectionCipher{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(collection).Error; err != nil {
+			return err
+		}
+		return database.UpdateOrganizationRevisionDate(tx, collection.OrganizationId)
+	})
+	if err != nil {
+		log.Errorf("organization collection delete, failed to delete collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyOrganization(req, notifications.SyncVault, collection.OrganizationId)
+}
+
+// OrganizationCollectionUsers returns the members assigned to a collection together with their permissions.
+func (a *API) OrganizationCollectionUsers(w http.ResponseWriter, req *http.Request) {
+	if _, ok := a.requireOrganizationAdmin(w, req, "organization collection users"); !ok {
+		return
+	}
+
+	collection, err := a.getCollectionFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection users, unable to load collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var collectionUsers []database.CollectionUser
+	if err := a.db.DB.Where("collection_id = ?", collection.Id).Find(&collectionUsers).Error; err != nil {
+		log.Errorf("organization collection users, failed to load users: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	users := make([]bw.SelectionReadOnlyModel, len(collectionUsers))
+	for i := range collectionUsers {
+		users[i] = bw.SelectionReadOnlyModel{
+			Id:            collectionUsers[i].OrganizationUserId,
+			ReadOnly:      collectionUsers[i].ReadOnly,
+			HidePasswords: collectionUsers[i].HidePasswords,
+		}
+	}
+
+	MustRespondJSON(w, &users)
+}
+
+// OrganizationCollectionUsersUpdate replaces the members assigned to a collection.
+func (a *API) OrganizationCollectionUsersUpdate(w http.ResponseWriter, req *http.Request) {
+	membership, ok := a.requireOrganizationAdmin(w, req, "organization collection users update")
+	if !ok {
+		return
+	}
+
+	collection, err := a.getCollectionFromRequest(req)
+	if err != nil {
+		log.Errorf("organization collection users update, unable to load collection: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var users []bw.SelectionReadOnlyModel
+	if err := json.NewDecoder(req.Body).Decode(&users); err != nil {
+		log.Errorf("organization collection users update decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.validateCollectionUsers(membership.OrganizationId, users); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.Id).Delete(&database.CollectionUser{}).Error; err != nil {
+			return err
+		}
+		if err := storeCollectionUsers(tx, collection.Id, users); err != nil {
+			return err
+		}
+		return database.UpdateOrganizationRevisionDate(tx, collection.OrganizationId)
+	})
+	if err != nil {
+		log.Errorf("organization collection users update, failed to store users: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyOrganization(req, notifications.SyncVault, collection.OrganizationId)
+}
+
+// requireOrganizationAdmin loads the membership of the authenticated user in the organization referenced by
+// the id URL parameter. If the user is no administrator of the organization, an error is written and false
+// is returned.
+func (a *API) requireOrganizationAdmin(w http.ResponseWriter, req *http.Request, context string) (*database.OrganizationUser, bool) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("%s, unable to load user: %s", context, err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("%s, unable to load membership: %s", context, err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil, false
+	}
+	if !membership.IsAdmin() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, false
+	}
+
+	return membership, true
+}
+
+// getCollectionFromRequest loads the collection referenced by the collectionId URL parameter. It must belong
+// to the organization referenced by the id URL parameter.
+func (a *API) getCollectionFromRequest(req *http.Request) (*database.Collection, error) {
+	organizationId, err := getIdParam(req, "id")
+	if err != nil {
+		return nil, errors.New("invalid organization id")
+	}
+	collectionId, err := getIdParam(req, "collectionId")
+	if err != nil {
+		return nil, errors.New("invalid collection id")
+	}
+
+	var collection database.Collection
+	err = a.db.DB.Where("id = ? AND organization_id = ?", collectionId, organizationId).First(&collection).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+// validateCollectionUsers checks that all assigned members belong to the organization.
+func (a *API) validateCollectionUsers(organizationId uint64, users []bw.SelectionReadOnlyModel) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	ids := make([]uint64, len(users))
+	seen := make(map[uint64]bool, len(users))
+	for i := range users {
+		if seen[users[i].Id] {
+			return errors.New("duplicate user")
+		}
+		seen[users[i].Id] = true
+		ids[i] = users[i].Id
+	}
+
+	var count int
+	err := a.db.DB.Model(&database.OrganizationUser{}).
+		Where("id IN (?) AND organization_id = ?", ids, organizationId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count != len(ids) {
+		return errors.New("invalid user")
+	}
+	return nil
+}
+
+// storeCollectionUsers assigns the members to the collection.
+func storeCollectionUsers(tx *gorm.DB, collectionId uint64, users []bw.SelectionReadOnlyModel) error {
+	for _, user := range users {
+		collectionUser := database.CollectionUser{
+			CollectionId:       collectionId,
+			OrganizationUserId: user.Id,
+			ReadOnly:           user.ReadOnly,
+			HidePasswords:      user.HidePasswords,
+		}
+		if err := tx.Create(&collectionUser).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectionResponses returns the collections visible to the user, optionally limited to one organization.
+// The permission flags are specific to the user.
+func collectionResponses(access *userAccess, organizationId *uint64) []bw.CollectionResponseModel {
+	collections := make([]bw.CollectionResponseModel, 0, len(access.collections))
+	for _, collection := range access.collections {
+		if organizationId != nil && collection.collection.OrganizationId != *organizationId {
+			continue
+		}
+		collectionResponse := collectionResponseFromModel(&collection.collection)
+		collectionResponse.ReadOnly = collection.readOnly
+		collectionResponse.HidePasswords = collection.hidePasswords
+		collectionResponse.Object = "collectionDetails"
+		collections = append(collections, collectionResponse)
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Id < collections[j].Id
+	})
+
+	return collections
+}
+
+// collectionResponseFromModel converts the collection to the structure the clients expect.
+func collectionResponseFromModel(collection *database.Collection) bw.CollectionResponseModel {
+	return bw.CollectionResponseModel{
+		Id:             collection.Id,
+		OrganizationId: collection.OrganizationId,
+		Name:           collection.Name,
+		ExternalId:     collection.ExternalId,
+		Object:         "collection",
+	}
+}
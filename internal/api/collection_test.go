@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// createOrganization creates an organization with the owner and a confirmed member without access to all
+// collections.
+func createOrganization(t *testing.T, api *API, owner, member *database.User) (uint64, *database.OrganizationUser) {
+	organization := database.Organization{Name: "Team", Enabled: true, UseTotp: true, MaxStorageGb: 1,
+		CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&organization).Error; err != nil {
+		t.Fatal(err)
+	}
+	ownership := database.OrganizationUser{OrganizationId: organization.Id, UserId: &owner.Id, Key: testEncString,
+		Status: database.OrganizationUserStatusConfirmed, Type: database.OrganizationUserTypeOwner, AccessAll: true}
+	membership := database.OrganizationUser{OrganizationId: organization.Id, UserId: &member.Id, Key: testEncString,
+		Status: database.OrganizationUserStatusConfirmed, Type: database.OrganizationUserTypeUser}
+	if err := api.db.DB.Create(&ownership).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := api.db.DB.Create(&membership).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return organization.Id, &membership
+}
+
+func syncResponse(t *testing.T, api *API, user *database.User) common.SyncResponseModel {
+	req, _ := http.NewRequest("GET", "/api/sync", nil)
+	rr := serveAuthenticated(t, api, user, api.Sync, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("sync returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response common.SyncResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestCollectionPermissions(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	owner := createUser(t, api.db.DB)
+	member := createMember(t, api)
+	organizationId, membership := createOrganization(t, api, owner, member)
+	organization := strconv.FormatUint(organizationId, 10)
+
+	// Create a collection the member may only read
+	req := organizationRequest("POST", "/api/organizations/"+organization+"/collections", organizationId,
+		`{"name":"`+testEncString+`","users":[{"id":"`+strconv.FormatUint(membership.Id, 10)+`","readOnly":true}]}`)
+	rr := serveAuthenticated(t, api, owner, api.OrganizationCollectionCreate, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	var collectionResponse common.CollectionResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &collectionResponse); err != nil {
+		t.Fatal(err)
+	}
+	collection := strconv.FormatUint(collectionResponse.Id, 10)
+
+	// Members may not manage collections
+	req = organizationRequest("POST", "/api/organizations/"+organization+"/collections", organizationId,
+		`{"name":"`+testEncString+`"}`)
+	rr = serveAuthenticated(t, api, member, api.OrganizationCollectionCreate, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	// The owner stores a cipher in the collection, a second cipher is in no collection
+	cipherRequest := `{"type":2,"organizationId":"` + organization + `","name":"` + testEncString + `","secureNote":{"type":0}}`
+	req, _ = http.NewRequest("POST", "/api/ciphers/create", strings.NewReader(
+		`{"cipher":`+cipherRequest+`,"collectionIds":["`+collection+`"]}`))
+	rr = serveAuthenticated(t, api, owner, api.CipherCreateWrapped, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	var cipherResponse common.CipherResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &cipherResponse); err != nil {
+		t.Fatal(err)
+	}
+	cipherId := strconv.FormatUint(cipherResponse.Id, 10)
+	if len(cipherResponse.CollectionIds) != 1 || cipherResponse.CollectionIds[0] != collection {
+		t.Errorf("handler returned unexpected collections: got %v", cipherResponse.CollectionIds)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/ciphers", strings.NewReader(cipherRequest))
+	rr = serveAuthenticated(t, api, owner, api.CipherCreate, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// The member sees the collection and its cipher, but may not change it
+	sync := syncResponse(t, api, member)
+	if len(sync.Collections) != 1 || !sync.Collections[0].ReadOnly {
+		t.Errorf("sync returned unexpected collections: got %v", sync.Collections)
+	}
+	if len(sync.Ciphers) != 1 || sync.Ciphers[0].Edit || !sync.Ciphers[0].ViewPassword {
+		t.Fatalf("sync returned unexpected ciphers: got %v", sync.Ciphers)
+	}
+
+	updateCipher := func() int {
+		req, _ := http.NewRequest("PUT", "/api/ciphers/"+cipherId, strings.NewReader(cipherRequest))
+		req = withURLParam(req, "id", cipherId)
+		return serveAuthenticated(t, api, member, api.CipherUpdate, req).Code
+	}
+	if status := updateCipher(); status != http.StatusForbidden {
+		t.Errorf("read-only member updated the cipher: got %v want %v", status, http.StatusForbidden)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/ciphers/create", strings.NewReader(
+		`{"cipher":`+cipherRequest+`,"collectionIds":["`+collection+`"]}`))
+	rr = serveAuthenticated(t, api, member, api.CipherCreateWrapped, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("read-only member created a cipher in the collection: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Writable, but passwords are hidden
+	setUsers := func(users string) {
+		req := organizationRequest("PUT", "/api/organizations/"+organization+"/collections/"+collection+"/users",
+			organizationId, users)
+		req = withURLParam(req, "collectionId", collection)
+		rr := serveAuthenticated(t, api, owner, api.OrganizationCollectionUsersUpdate, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+				status, http.StatusOK, rr.Body.String())
+		}
+	}
+	setUsers(`[{"id":"` + strconv.FormatUint(membership.Id, 10) + `","readOnly":false,"hidePasswords":true}]`)
+
+	sync = syncResponse(t, api, member)
+	if len(sync.Ciphers) != 1 || sync.Ciphers[0].Edit || sync.Ciphers[0].ViewPassword {
+		t.Errorf("sync returned unexpected ciphers: got %v", sync.Ciphers)
+	}
+	if status := updateCipher(); status != http.StatusForbidden {
+		t.Errorf("member with hidden passwords updated the cipher: got %v want %v", status, http.StatusForbidden)
+	}
+
+	// Full write access
+	setUsers(`[{"id":"` + strconv.FormatUint(membership.Id, 10) + `","readOnly":false,"hidePasswords":false}]`)
+	if status := updateCipher(); status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Removing the cipher from the collection hides it from the member
+	req, _ = http.NewRequest("PUT", "/api/ciphers/"+cipherId+"/collections", strings.NewReader(`{"collectionIds":[]}`))
+	req = withURLParam(req, "id", cipherId)
+	rr = serveAuthenticated(t, api, owner, api.CipherCollectionsUpdate, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	if sync = syncResponse(t, api, member); len(sync.Ciphers) != 0 {
+		t.Errorf("member still sees the removed cipher: got %v", sync.Ciphers)
+	}
+	if sync = syncResponse(t, api, owner); len(sync.Ciphers) != 2 {
+		t.Errorf("owner does not see all organization ciphers: got %d", len(sync.Ciphers))
+	}
+}
+
+func TestCipherCollectionsReadOnlyAssignment(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB, the cipher is in a collection the member may only read and in one the member may write to
+	owner := createUser(t, api.db.DB)
+	member := createMember(t, api)
+	organizationId, membership := createOrganization(t, api, owner, member)
+	var collections [3]database.Collection
+	for i := range collections {
+		collections[i] = database.Collection{OrganizationId: organizationId, Name: testEncString}
+		if err := api.db.DB.Create(&collections[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+		collectionUser := database.CollectionUser{CollectionId: collections[i].Id, OrganizationUserId: membership.Id, ReadOnly: i == 0}
+		if err := api.db.DB.Create(&collectionUser).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	readOnly, writable, other := collections[0].Id, collections[1].Id, collections[2].Id
+	cipher := database.Cipher{OrganizationId: &organizationId, Type: 2, Data: `{"name":"note"}`, CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&cipher).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, collectionId := range []uint64{readOnly, writable} {
+		if err := api.db.DB.Create(&database.CollectionCipher{CollectionId: collectionId, CipherId: cipher.Id}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	cipherId := strconv.FormatUint(cipher.Id, 10)
+
+	updateCollections := func(collectionIds ...uint64) int {
+		ids := make([]string, len(collectionIds))
+		for i, collectionId := range collectionIds {
+			ids[i] = `"` + strconv.FormatUint(collectionId, 10) + `"`
+		}
+		req, _ := http.NewRequest("PUT", "/api/ciphers/"+cipherId+"/collections", strings.NewReader(
+			`{"collectionIds":[`+strings.Join(ids, ",")+`]}`))
+		req = withURLParam(req, "id", cipherId)
+		return serveAuthenticated(t, api, member, api.CipherCollectionsUpdate, req).Code
+	}
+	assigned := func() []uint64 {
+		var collectionIds []uint64
+		api.db.DB.Model(&database.CollectionCipher{}).Where("cipher_id = ?", cipher.Id).Order("collection_id").
+			Pluck("collection_id", &collectionIds)
+		return collectionIds
+	}
+
+	// The full list of the client contains the read-only collection
+	if status := updateCollections(readOnly, other); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if collectionIds := assigned(); len(collectionIds) != 2 || collectionIds[0] != readOnly || collectionIds[1] != other {
+		t.Errorf("unexpected collections: got %v", collectionIds)
+	}
+
+	// The read-only assignment cannot be removed, the cipher stays visible to the member
+	if status := updateCollections(); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if collectionIds := assigned(); len(collectionIds) != 1 || collectionIds[0] != readOnly {
+		t.Errorf("unexpected collections: got %v", collectionIds)
+	}
+}
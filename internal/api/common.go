@@ -1,22 +1,44 @@
 package api
 
 import (
+	"time"
+
 	"github.com/go-chi/jwtauth"
 	bw "github.com/h44z/bitwarden-go/internal/common"
 	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+	"github.com/h44z/bitwarden-go/internal/storage"
 )
 
 type API struct {
-	db  *database.Wrapper
-	cfg *bw.Configuration
-	jwt *jwtauth.JWTAuth
+	db      *database.Wrapper
+	cfg     *bw.Configuration
+	jwt     *jwtauth.JWTAuth
+	storage storage.Storage
+	hub     *notifications.Hub
+	push    notifications.PushNotifier
+
+	hintEmailLimiter          *rateLimiter
+	hintIPLimiter             *rateLimiter
+	deleteRecoverEmailLimiter *rateLimiter
+	deleteRecoverIPLimiter    *rateLimiter
+	verifyEmailLimiter        *rateLimiter
 }
 
-func New(db *database.Wrapper, cfg *bw.Configuration, jwt *jwtauth.JWTAuth) API {
+func New(db *database.Wrapper, cfg *bw.Configuration, jwt *jwtauth.JWTAuth, storage storage.Storage, push notifications.PushNotifier) API {
 	auth := API{
-		db:  db,
-		cfg: cfg,
-		jwt: jwt,
+		db:      db,
+		cfg:     cfg,
+		jwt:     jwt,
+		storage: storage,
+		hub:     notifications.NewHub(),
+		push:    push,
+
+		hintEmailLimiter:          newRateLimiter(passwordHintEmailLimit, time.Hour),
+		hintIPLimiter:             newRateLimiter(passwordHintIPLimit, time.Hour),
+		deleteRecoverEmailLimiter: newRateLimiter(deleteRecoverEmailLimit, time.Hour),
+		deleteRecoverIPLimiter:    newRateLimiter(deleteRecoverIPLimit, time.Hour),
+		verifyEmailLimiter:        newRateLimiter(verifyEmailLimit, time.Hour),
 	}
 
 	return auth
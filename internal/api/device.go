@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// DeviceList returns all devices the authenticated user logged in from.
+func (a *API) DeviceList(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("device list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var devices []database.Device
+	if err := a.db.DB.Where("user_id = ?", user.Id).Order("revision_date desc").Find(&devices).Error; err != nil {
+		log.Errorf("device list, failed to load devices: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	deviceResponses := make([]bw.DeviceResponseModel, len(devices))
+	for i := range devices {
+		deviceResponses[i] = deviceResponseFromModel(&devices[i])
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: deviceResponses, Object: "list"})
+}
+
+// DeviceGetByIdentifier returns the device of the authenticated user with the identifier the client generated.
+func (a *API) DeviceGetByIdentifier(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("device get, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	device, err := a.getDeviceFromRequest(req, user)
+	if err != nil {
+		log.Errorf("device get, unable to load device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	deviceResponse := deviceResponseFromModel(device)
+	MustRespondJSON(w, &deviceResponse)
+}
+
+// DeviceUpdateToken stores the push token of a device. Vault changes wake up the app on the device from now on.
+func (a *API) DeviceUpdateToken(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("device update token, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	device, err := a.getDeviceFromRequest(req, user)
+	if err != nil {
+		log.Errorf("device update token, unable to load device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var requestData bw.DeviceTokenRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("device update token decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.PushToken == "" || len(requestData.PushToken) > 255 {
+		http.Error(w, "push token is invalid", http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.DB.Model(device).UpdateColumns(map[string]interface{}{
+		"push_token":    requestData.PushToken,
+		"revision_date": time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("device update token, failed to store device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeviceClearToken removes the push token of a device, the device does not receive push notifications anymore.
+func (a *API) DeviceClearToken(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("device clear token, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	device, err := a.getDeviceFromRequest(req, user)
+	if err != nil {
+		log.Errorf("device clear token, unable to load device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err = a.db.DB.Model(device).UpdateColumns(map[string]interface{}{
+		"push_token":    "",
+		"revision_date": time.Now(),
+	}).Error
+	if err != nil {
+		log.Errorf("device clear token, failed to store device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeviceDelete revokes a device of the authenticated user. The refresh tokens and remember tokens of the device
+// are removed, its access tokens are rejected from now on.
+func (a *API) DeviceDelete(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("device delete, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	deviceId, err := getIdParam(req, "id")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	var device database.Device
+	if err := a.db.DB.Where("id = ? AND user_id = ?", deviceId, user.Id).First(&device).Error; err != nil {
+		log.Errorf("device delete, unable to load device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("device_id = ?", device.Id).Delete(&database.Grant{}).Error; err != nil {
+			return err
+		}
+		err := tx.Where("subject_id = ? AND type = ? AND data = ?", strconv.FormatUint(user.Id, 10),
+			database.GrantTypeTwoFactorRemember, device.Identifier).Delete(&database.Grant{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&device).Error
+	})
+	if err != nil {
+		log.Errorf("device delete, failed to revoke device: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("User %s revoked device %s", user.Email, device.Name)
+}
+
+// upsertDevice registers the device a user logged in from. A known device is updated, the client may have
+// changed its name.
+func (a *API) upsertDevice(user *database.User, identifier string, deviceType int, name string) (*database.Device, error) {
+	var device database.Device
+	err := a.db.DB.Where("user_id = ? AND identifier = ?", user.Id, identifier).First(&device).Error
+	if gorm.IsRecordNotFoundError(err) {
+		device = database.Device{
+			UserId:       user.Id,
+			Name:         truncateUTF8(name, 50),
+			Type:         deviceType,
+			Identifier:   identifier,
+			CreationDate: time.Now(),
+			RevisionDate: time.Now(),
+		}
+		if err := a.db.DB.Create(&device).Error; err != nil {
+			// The device may have been registered by a concurrent login
+			if a.db.DB.Where("user_id = ? AND identifier = ?", user.Id, identifier).First(&device).Error != nil {
+				return nil, err
+			}
+		}
+		return &device, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	device.Name = truncateUTF8(name, 50)
+	device.Type = deviceType
+	device.RevisionDate = time.Now()
+	err = a.db.DB.Model(&device).UpdateColumns(map[string]interface{}{
+		"name":          device.Name,
+		"type":          device.Type,
+		"revision_date": device.RevisionDate,
+	}).Error
+	return &device, err
+}
+
+// getDeviceFromRequest loads the device of the user referenced by the identifier URL parameter.
+func (a *API) getDeviceFromRequest(req *http.Request, user *database.User) (*database.Device, error) {
+	var device database.Device
+	err := a.db.DB.Where("user_id = ? AND identifier = ?", user.Id, chi.URLParam(req, "identifier")).First(&device).Error
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func deviceResponseFromModel(device *database.Device) bw.DeviceResponseModel {
+	return bw.DeviceResponseModel{
+		Id:           device.Id,
+		Name:         device.Name,
+		Type:         device.Type,
+		Identifier:   device.Identifier,
+		CreationDate: device.CreationDate,
+		Object:       "device",
+	}
+}
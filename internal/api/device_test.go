@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+func TestDeviceRegistry(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	defer deleteRefreshTokens(t, api.db.DB)
+
+	// Logging in twice from the same device registers it once
+	for i := 0; i < 2; i++ {
+		if rr := passwordLogin(t, api, ""); rr.Code != http.StatusOK {
+			t.Fatalf("login failed: got %v %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/api/devices", nil)
+	rr := serveAuthenticated(t, api, user, api.DeviceList, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var list struct {
+		Data []common.DeviceResponseModel
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Data) != 1 || list.Data[0].Identifier != "sample-device" || list.Data[0].Name != "firefox" || list.Data[0].Type != 3 {
+		t.Fatalf("handler returned unexpected devices: got %v", list.Data)
+	}
+
+	// The refresh tokens are linked to the device
+	var grants []database.Grant
+	api.db.DB.Where("subject_id = ? AND type = ?", strconv.FormatUint(user.Id, 10), database.GrantTypeRefreshToken).Find(&grants)
+	if len(grants) != 2 {
+		t.Fatalf("unexpected number of refresh tokens: got %v want 2", len(grants))
+	}
+	for _, grant := range grants {
+		if grant.DeviceId != list.Data[0].Id {
+			t.Errorf("refresh token is not linked to the device: got %v want %v", grant.DeviceId, list.Data[0].Id)
+		}
+	}
+
+	// Lookup by identifier
+	req, _ = http.NewRequest("GET", "/api/devices/identifier/unknown", nil)
+	req = withURLParam(req, "identifier", "unknown")
+	if rr := serveAuthenticated(t, api, user, api.DeviceGetByIdentifier, req); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	api.db.DB.Model(&database.Device{}).Where("id = ?", list.Data[0].Id).UpdateColumn("push_token", "token")
+	req, _ = http.NewRequest("PUT", "/api/devices/identifier/sample-device/clear-token", nil)
+	req = withURLParam(req, "identifier", "sample-device")
+	if rr := serveAuthenticated(t, api, user, api.DeviceClearToken, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var device database.Device
+	api.db.DB.First(&device, list.Data[0].Id)
+	if device.PushToken != "" {
+		t.Errorf("push token was not cleared: got %v", device.PushToken)
+	}
+}
+
+func TestDeviceRevoke(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+
+	var device database.Device
+	if err := api.db.DB.Where("user_id = ? AND identifier = ?", user.Id, "sample-device").First(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+	deviceId := strconv.FormatUint(device.Id, 10)
+
+	// Devices of other users cannot be revoked
+	other := createMember(t, api)
+	req, _ := http.NewRequest("DELETE", "/api/devices/"+deviceId, nil)
+	req = withURLParam(req, "id", deviceId)
+	if rr := serveAuthenticated(t, api, other, api.DeviceDelete, req); rr.Code != http.StatusNotFound {
+		t.Errorf("device of another user was revoked: got %v", rr.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/devices/"+deviceId, nil)
+	req = withURLParam(req, "id", deviceId)
+	if rr := serveAuthenticated(t, api, user, api.DeviceDelete, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// The session of the device ended
+	req, _ = http.NewRequest("GET", "/api/sync", nil)
+	if rr := serveWithToken(api, token.AccessToken, api.Sync, req); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token of a revoked device was accepted: got %v", rr.Code)
+	}
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token of a revoked device was accepted: got %v", status)
+	}
+}
+
+func TestDeviceRefreshToken(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+
+	// The device claim is taken from the refresh token, not from the request
+	req, _ := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(
+		"grant_type=refresh_token&client_id=browser&scope=api offline_access&deviceType=3&deviceIdentifier=other-device"+
+			"&deviceName=firefox&refresh_token="+url.QueryEscape(token.RefreshToken)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(api.AuthToken).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh failed: got %v %s", rr.Code, rr.Body.String())
+	}
+	var refreshed common.TokenModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &refreshed); err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := api.jwt.Decode(refreshed.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device := accessToken.Claims.(jwt.MapClaims)["device"]; device != "sample-device" {
+		t.Errorf("unexpected device claim: got %v want sample-device", device)
+	}
+
+	// Refresh tokens without a device are rejected
+	api.db.DB.Model(&database.Grant{}).Where("key = ?", token.RefreshToken).UpdateColumn("device_id", 0)
+	if status := refreshLogin(api, token.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh token without a device was accepted: got %v", status)
+	}
+	if !api.db.DB.Where("subject_id = ? AND key = ?", strconv.FormatUint(user.Id, 10), token.RefreshToken).
+		First(&database.Grant{}).RecordNotFound() {
+		t.Errorf("refresh token without a device was not removed")
+	}
+}
+
+func TestDevicePushToken(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Local stand-in for the push relay
+	pushed := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		pushed <- req
+		bodies <- body
+	}))
+	defer relay.Close()
+	pushNotifier, err := notifications.NewRelayPushNotifier(relay.URL, "relaykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.push = pushNotifier
+
+	// Prepare DB, the test user is logged in on the browser and on a phone
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+	phone := database.Device{UserId: user.Id, Name: "android", Type: 0, Identifier: "phone-device"}
+	if err := api.db.DB.Create(&phone).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for identifier, pushToken := range map[string]string{"phone-device": "phone-token", "sample-device": "browser-token"} {
+		req, _ := http.NewRequest("PUT", "/api/devices/identifier/"+identifier+"/token", strings.NewReader(`{"pushToken":"`+pushToken+`"}`))
+		req = withURLParam(req, "identifier", identifier)
+		if rr := serveAuthenticated(t, api, user, api.DeviceUpdateToken, req); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	api.db.DB.First(&phone, phone.Id)
+	if phone.PushToken != "phone-token" {
+		t.Fatalf("push token was not stored: got %v", phone.PushToken)
+	}
+
+	// A change in the browser wakes up the phone
+	req, _ := http.NewRequest("POST", "/api/folders", strings.NewReader(`{"name":"encryptedname"}`))
+	if rr := serveWithToken(api, token.AccessToken, api.FolderCreate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	select {
+	case req := <-pushed:
+		if req.URL.Path != "/push/send" || req.Header.Get("Authorization") != "Bearer relaykey" {
+			t.Errorf("unexpected relay request: %s %v", req.URL.Path, req.Header)
+		}
+		var message struct {
+			PushTokens []string
+			Type       int
+			ContextId  string
+		}
+		if err := json.Unmarshal(<-bodies, &message); err != nil {
+			t.Fatal(err)
+		}
+		if len(message.PushTokens) != 1 || message.PushTokens[0] != "phone-token" || message.Type != 7 ||
+			message.ContextId != "sample-device" {
+			t.Errorf("unexpected push message: got %+v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no push notification was sent")
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// FolderList returns all folders of the authenticated user.
+func (a *API) FolderList(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("folder list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var folders []database.Folder
+	if err := a.db.DB.Where("user_id = ?", user.Id).Find(&folders).Error; err != nil {
+		log.Errorf("folder list, failed to load folders: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	folderResponses := make([]bw.FolderResponseModel, len(folders))
+	for i := range folders {
+		folderResponses[i] = folderResponseFromModel(&folders[i])
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: folderResponses, Object: "list"})
+}
+
+// FolderCreate creates a new folder for the authenticated user.
+func (a *API) FolderCreate(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("folder create, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.FolderRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("folder create decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Name == "" {
+		http.Error(w, "name is missing", http.StatusBadRequest)
+		return
+	}
+
+	currentTime := time.Now()
+	folder := database.Folder{
+		UserId:       user.Id,
+		Name:         requestData.Name,
+		CreationDate: currentTime,
+		RevisionDate: currentTime,
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&folder).Error; err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("folder create, failed to store folder: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyFolder(req, notifications.SyncFolderCreate, &folder)
+
+	folderResponse := folderResponseFromModel(&folder)
+	MustRespondJSON(w, &folderResponse)
+}
+
+// FolderGet returns a single folder of the authenticated user.
+func (a *API) FolderGet(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("folder get, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	folder, err := a.getFolderFromRequest(req, user)
+	if err != nil {
+		log.Errorf("folder get, unable to load folder: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	folderResponse := folderResponseFromModel(folder)
+	MustRespondJSON(w, &folderResponse)
+}
+
+// FolderUpdate renames a folder of the authenticated user.
+func (a *API) FolderUpdate(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("folder update, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	folder, err := a.getFolderFromRequest(req, user)
+	if err != nil {
+		log.Errorf("folder update, unable to load folder: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var requestData bw.FolderRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("folder update decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Name == "" {
+		http.Error(w, "name is missing", http.StatusBadRequest)
+		return
+	}
+
+	folder.Name = requestData.Name
+	folder.RevisionDate = time.Now()
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(folder).Error; err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("folder update, failed to store folder: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyFolder(req, notifications.SyncFolderUpdate, folder)
+
+	folderResponse := folderResponseFromModel(folder)
+	MustRespondJSON(w, &folderResponse)
+}
+
+// FolderDelete removes a folder of the authenticated user. Ciphers stored in the folder are moved out of it.
+func (a *API) FolderDelete(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("folder delete, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := access.user
+
+	folder, err := a.getFolderFromRequest(req, user)
+	if err != nil {
+		log.Errorf("folder delete, unable to load folder: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		// Ciphers the user cannot access anymore may still be filed in the folder
+		ciphers, err := database.GetCiphersWithUserSettings(tx, user.Id)
+		if err != nil {
+			return err
+		}
+		for i := range ciphers {
+			if folderId := ciphers[i].GetFolderId(user.Id); folderId == nil || *folderId != folder.Id {
+				continue
+			}
+			if err := ciphers[i].SetFolderId(user.Id, nil); err != nil {
+				return err
+			}
+			if err := tx.Model(&ciphers[i]).UpdateColumn("folders", ciphers[i].Folders).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Delete(folder).Error; err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("folder delete, failed to delete folder: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyFolder(req, notifications.SyncFolderDelete, folder)
+}
+
+// getFolderFromRequest loads the folder referenced by the id URL parameter. The folder must belong to the given user.
+func (a *API) getFolderFromRequest(req *http.Request, user *database.User) (*database.Folder, error) {
+	folderId, err := getIdParam(req, "id")
+	if err != nil {
+		return nil, errors.New("invalid folder id")
+	}
+
+	var folder database.Folder
+	if err := a.db.DB.Where("id = ? AND user_id = ?", folderId, user.Id).First(&folder).Error; err != nil {
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// folderResponseFromModel converts the folder record to the folder structure the clients expect.
+func folderResponseFromModel(folder *database.Folder) bw.FolderResponseModel {
+	return bw.FolderResponseModel{
+		Id:           folder.Id,
+		Name:         folder.Name,
+		RevisionDate: folder.RevisionDate,
+		Object:       "folder",
+	}
+}
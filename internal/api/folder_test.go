@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// withURLParam adds a chi URL parameter to the request.
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	rctx := chi.RouteContext(req.Context())
+	if rctx == nil {
+		rctx = chi.NewRouteContext()
+	}
+	rctx.URLParams.Add(key, value)
+
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestFolderCreateUpdateList(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	// Create
+	req, _ := http.NewRequest("POST", "/api/folders", strings.NewReader(`{"name":"encryptedname"}`))
+	rr := serveAuthenticated(t, api, user, api.FolderCreate, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var folderResponse common.FolderResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &folderResponse); err != nil {
+		t.Fatal(err)
+	}
+	if folderResponse.Id == 0 || folderResponse.Name != "encryptedname" {
+		t.Errorf("handler returned unexpected folder: got %v", folderResponse)
+	}
+
+	// Update
+	req, _ = http.NewRequest("PUT", "/api/folders/"+strconv.FormatUint(folderResponse.Id, 10), strings.NewReader(`{"name":"newname"}`))
+	req = withURLParam(req, "id", strconv.FormatUint(folderResponse.Id, 10))
+	rr = serveAuthenticated(t, api, user, api.FolderUpdate, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// List
+	req, _ = http.NewRequest("GET", "/api/folders", nil)
+	rr = serveAuthenticated(t, api, user, api.FolderList, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var listResponse struct {
+		Data   []common.FolderResponseModel `json:"data"`
+		Object string                       `json:"object"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listResponse); err != nil {
+		t.Fatal(err)
+	}
+	if listResponse.Object != "list" || len(listResponse.Data) != 1 || listResponse.Data[0].Name != "newname" {
+		t.Errorf("handler returned unexpected list: got %v", listResponse)
+	}
+
+	// The account revision date must have been updated
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if storedUser.AccountRevisionDate == nil {
+		t.Errorf("account revision date was not updated")
+	}
+}
+
+func TestFolderAccessOtherUser(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	folder := database.Folder{UserId: user.Id + 1, Name: "foreign", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&folder).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/folders/"+strconv.FormatUint(folder.Id, 10), nil)
+	req = withURLParam(req, "id", strconv.FormatUint(folder.Id, 10))
+	rr := serveAuthenticated(t, api, user, api.FolderGet, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestFolderDelete(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	folder := database.Folder{UserId: user.Id, Name: "encryptedname", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&folder).Error; err != nil {
+		t.Fatal(err)
+	}
+	cipher := database.Cipher{UserId: &user.Id, Type: 2, Data: `{"name":"note"}`, CreationDate: time.Now(), RevisionDate: time.Now()}
+	cipher.SetFolderId(user.Id, &folder.Id)
+	if err := api.db.DB.Create(&cipher).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("DELETE", "/api/folders/"+strconv.FormatUint(folder.Id, 10), nil)
+	req = withURLParam(req, "id", strconv.FormatUint(folder.Id, 10))
+	rr := serveAuthenticated(t, api, user, api.FolderDelete, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	if !api.db.DB.First(&database.Folder{}, folder.Id).RecordNotFound() {
+		t.Errorf("folder was not deleted")
+	}
+
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, cipher.Id)
+	if storedCipher.GetFolderId(user.Id) != nil {
+		t.Errorf("cipher still references deleted folder: %s", storedCipher.Folders)
+	}
+}
+
+func TestFolderDeleteWithoutCollectionAccess(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB, the member filed an organization cipher in a folder
+	owner := createUser(t, api.db.DB)
+	member := createMember(t, api)
+	organizationId, membership := createOrganization(t, api, owner, member)
+	collection := database.Collection{OrganizationId: organizationId, Name: testEncString}
+	if err := api.db.DB.Create(&collection).Error; err != nil {
+		t.Fatal(err)
+	}
+	folder := database.Folder{UserId: member.Id, Name: "encryptedname", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&folder).Error; err != nil {
+		t.Fatal(err)
+	}
+	cipher := database.Cipher{OrganizationId: &organizationId, Type: 2, Data: `{"name":"note"}`, CreationDate: time.Now(), RevisionDate: time.Now()}
+	cipher.SetFolderId(member.Id, &folder.Id)
+	cipher.SetFolderId(owner.Id, &folder.Id) // same id, another user
+	for _, value := range []interface{}{&cipher, &database.CollectionUser{CollectionId: collection.Id, OrganizationUserId: membership.Id}} {
+		if err := api.db.DB.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := api.db.DB.Create(&database.CollectionCipher{CollectionId: collection.Id, CipherId: cipher.Id}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The member loses access to the collection before deleting the folder
+	api.db.DB.Where("organization_user_id = ?", membership.Id).Delete(&database.CollectionUser{})
+
+	req, _ := http.NewRequest("DELETE", "/api/folders/"+strconv.FormatUint(folder.Id, 10), nil)
+	req = withURLParam(req, "id", strconv.FormatUint(folder.Id, 10))
+	if rr := serveAuthenticated(t, api, member, api.FolderDelete, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var storedCipher database.Cipher
+	api.db.DB.First(&storedCipher, cipher.Id)
+	if storedCipher.GetFolderId(member.Id) != nil {
+		t.Errorf("cipher still references deleted folder: %s", storedCipher.Folders)
+	}
+	if folderId := storedCipher.GetFolderId(owner.Id); folderId == nil || *folderId != folder.Id {
+		t.Errorf("folder of another user was removed: %s", storedCipher.Folders)
+	}
+}
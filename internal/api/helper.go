@@ -1,10 +1,20 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/h44z/bitwarden-go/internal/database"
 )
 
 func MustRespondJSON(w http.ResponseWriter, data interface{}) {
@@ -18,3 +28,116 @@ func MustRespondJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonData)
 }
+
+type contextKey string
+
+// userContextKey stores the user that was loaded while the access token was validated.
+const userContextKey = contextKey("user")
+
+// getUserFromRequest loads the user referenced by the subject claim of the JWT token.
+func (a *API) getUserFromRequest(req *http.Request) (*database.User, error) {
+	if user, ok := req.Context().Value(userContextKey).(*database.User); ok {
+		return user, nil
+	}
+
+	_, claims, err := jwtauth.FromContext(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	sub, ok := claims["sub"].(float64)
+	if !ok {
+		return nil, errors.New("invalid subject claim")
+	}
+
+	var user database.User
+	if err := a.db.DB.Where("id = ?", uint64(sub)).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// getIdParam parses the numeric URL parameter with the given name.
+func getIdParam(req *http.Request, name string) (uint64, error) {
+	return strconv.ParseUint(chi.URLParam(req, name), 10, 64)
+}
+
+// baseURL returns the scheme and host the client used to reach the server.
+func baseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return scheme + "://" + req.Host
+}
+
+// clientIP returns the address of the client. The RealIP middleware replaces the remote address with the
+// address forwarded by a trusted proxy.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// getUserAccessFromRequest loads the user referenced by the JWT token together with the organization memberships.
+func (a *API) getUserAccessFromRequest(req *http.Request) (*userAccess, error) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.loadUserAccess(user)
+}
+
+// sameId compares two optional ids.
+func sameId(a, b *uint64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// parseIds converts the string ids sent by the clients. Duplicates are removed.
+func parseIds(values []string) ([]uint64, error) {
+	ids := make([]uint64, 0, len(values))
+	seen := make(map[uint64]bool, len(values))
+	for _, value := range values {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid id: " + value)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// truncateUTF8 shortens the string to at most max bytes without splitting a character.
+func truncateUTF8(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
+
+// newSecurityStamp generates a new random security stamp. Changing the stamp of a user invalidates all
+// issued tokens of the user.
+func newSecurityStamp() (string, error) {
+	stamp := make([]byte, 16)
+	if _, err := rand.Read(stamp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(stamp), nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// NotificationsHub upgrades the request to a WebSocket connection. The clients receive a message whenever the
+// vault of the authenticated user changes on another device.
+func (a *API) NotificationsHub(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("notifications hub, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if err := a.hub.Serve(w, req, user.Id); err != nil {
+		log.Errorf("notifications hub, failed to open connection: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// TokenFromAccessTokenQuery reads the JWT token from the access_token query parameter. Browsers cannot set
+// headers on WebSocket requests, the clients pass the token in the URL instead.
+func TokenFromAccessTokenQuery(req *http.Request) string {
+	return req.URL.Query().Get("access_token")
+}
+
+// notifyCipher tells the clients of all users that can access the cipher about the change.
+func (a *API) notifyCipher(req *http.Request, messageType notifications.MessageType, cipher *database.Cipher) {
+	collectionIds, err := cipherCollectionIds(a.db.DB, cipher)
+	if err != nil {
+		log.Errorf("failed to load collections of cipher %d: %s", cipher.Id, err.Error())
+		return
+	}
+	a.notifyCipherInCollections(req, messageType, cipher, collectionIds)
+}
+
+// notifyCipherInCollections tells the clients of all users that can access the cipher through the given
+// collections about the change. Deleted ciphers are not assigned to any collection anymore, the caller passes
+// the former assignments.
+func (a *API) notifyCipherInCollections(req *http.Request, messageType notifications.MessageType, cipher *database.Cipher, collectionIds []uint64) {
+	userIds, err := a.db.GetCipherUserIds(cipher, collectionIds)
+	if err != nil {
+		log.Errorf("failed to load recipients of the notification for cipher %d: %s", cipher.Id, err.Error())
+		return
+	}
+
+	a.notify(req, notifications.Message{Type: messageType, Payload: cipherPayload(cipher, collectionIds)}, userIds...)
+}
+
+// notifyCipherRevoked tells the members that could access the cipher through the former collections, but not
+// through the current ones, to remove the cipher from their vault.
+func (a *API) notifyCipherRevoked(req *http.Request, cipher *database.Cipher, formerCollectionIds []uint64) {
+	collectionIds, err := cipherCollectionIds(a.db.DB, cipher)
+	if err != nil {
+		log.Errorf("failed to load collections of cipher %d: %s", cipher.Id, err.Error())
+		return
+	}
+	formerUserIds, err := a.db.GetCipherUserIds(cipher, formerCollectionIds)
+	if err != nil {
+		log.Errorf("failed to load former recipients of the notification for cipher %d: %s", cipher.Id, err.Error())
+		return
+	}
+	userIds, err := a.db.GetCipherUserIds(cipher, collectionIds)
+	if err != nil {
+		log.Errorf("failed to load recipients of the notification for cipher %d: %s", cipher.Id, err.Error())
+		return
+	}
+
+	remaining := make(map[uint64]bool, len(userIds))
+	for _, userId := range userIds {
+		remaining[userId] = true
+	}
+	var revokedUserIds []uint64
+	for _, userId := range formerUserIds {
+		if !remaining[userId] {
+			revokedUserIds = append(revokedUserIds, userId)
+		}
+	}
+	if len(revokedUserIds) == 0 {
+		return
+	}
+
+	a.notify(req, notifications.Message{Type: notifications.SyncLoginDelete, Payload: cipherPayload(cipher, nil)}, revokedUserIds...)
+}
+
+// cipherCollectionIds returns the collections the cipher is assigned to.
+func cipherCollectionIds(db *gorm.DB, cipher *database.Cipher) ([]uint64, error) {
+	if cipher.OrganizationId == nil {
+		return nil, nil
+	}
+	var collectionIds []uint64
+	err := db.Model(&database.CollectionCipher{}).Where("cipher_id = ?", cipher.Id).
+		Pluck("collection_id", &collectionIds).Error
+	return collectionIds, err
+}
+
+// cipherPayload describes the changed cipher in a notification.
+func cipherPayload(cipher *database.Cipher, collectionIds []uint64) map[string]interface{} {
+	payload := map[string]interface{}{
+		"Id":             strconv.FormatUint(cipher.Id, 10),
+		"UserId":         nil,
+		"OrganizationId": nil,
+		"CollectionIds":  nil,
+		"RevisionDate":   cipher.RevisionDate,
+	}
+	if cipher.OrganizationId != nil {
+		collectionIdStrings := make([]string, len(collectionIds))
+		for i, collectionId := range collectionIds {
+			collectionIdStrings[i] = strconv.FormatUint(collectionId, 10)
+		}
+		payload["OrganizationId"] = strconv.FormatUint(*cipher.OrganizationId, 10)
+		payload["CollectionIds"] = collectionIdStrings
+	} else if cipher.UserId != nil {
+		payload["UserId"] = strconv.FormatUint(*cipher.UserId, 10)
+	}
+	return payload
+}
+
+// notifyFolder tells the clients of the owner about the change of the folder.
+func (a *API) notifyFolder(req *http.Request, messageType notifications.MessageType, folder *database.Folder) {
+	a.notify(req, notifications.Message{
+		Type: messageType,
+		Payload: map[string]interface{}{
+			"Id":           strconv.FormatUint(folder.Id, 10),
+			"UserId":       strconv.FormatUint(folder.UserId, 10),
+			"RevisionDate": folder.RevisionDate,
+		},
+	}, folder.UserId)
+}
+
+// notifyUser tells the clients of the user that the whole vault or account changed.
+func (a *API) notifyUser(req *http.Request, messageType notifications.MessageType, userId uint64) {
+	a.notify(req, notifications.Message{
+		Type: messageType,
+		Payload: map[string]interface{}{
+			"UserId": strconv.FormatUint(userId, 10),
+			"Date":   time.Now(),
+		},
+	}, userId)
+}
+
+// notifyOrganization tells the clients of all confirmed members of the organization that their vault changed.
+func (a *API) notifyOrganization(req *http.Request, messageType notifications.MessageType, organizationId uint64) {
+	userIds, err := a.db.GetOrganizationUserIds(organizationId)
+	if err != nil {
+		log.Errorf("failed to load recipients of the notification for organization %d: %s", organizationId, err.Error())
+		return
+	}
+	for _, userId := range userIds {
+		a.notifyUser(req, messageType, userId)
+	}
+}
+
+// notify publishes the message to the connected clients and the mobile apps of the users. The device that
+// sent the request is set as context, it already knows about the change.
+func (a *API) notify(req *http.Request, message notifications.Message, userIds ...uint64) {
+	if _, claims, err := jwtauth.FromContext(req.Context()); err == nil {
+		message.ContextId, _ = claims["device"].(string)
+	}
+
+	a.hub.Publish(message, userIds...)
+	a.pushToDevices(message, userIds)
+}
+
+// pushToDevices sends the message to all devices of the users that registered a push token, except the device
+// that made the change. The push notifier is called in the background, the request does not wait for the relay.
+func (a *API) pushToDevices(message notifications.Message, userIds []uint64) {
+	if len(userIds) == 0 {
+		return
+	}
+
+	var pushTokens []string
+	err := a.db.DB.Model(&database.Device{}).
+		Where("user_id IN (?) AND push_token <> '' AND identifier <> ?", userIds, message.ContextId).
+		Pluck("push_token", &pushTokens).Error
+	if err != nil {
+		log.Errorf("failed to load push tokens: %s", err.Error())
+		return
+	}
+	if len(pushTokens) == 0 {
+		return
+	}
+
+	go func() {
+		if err := a.push.Push(message, pushTokens); err != nil {
+			log.Errorf("failed to send push notification: %s", err.Error())
+		}
+	}()
+}
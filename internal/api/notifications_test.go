@@ -0,0 +1,302 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// hubClient is a minimal WebSocket client speaking the SignalR protocol.
+type hubClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connectHub opens a WebSocket connection to the notifications hub and completes the SignalR handshake.
+func connectHub(t *testing.T, server *httptest.Server, accessToken, protocol string) *hubClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest("GET", server.URL+"/notifications/hub?access_token="+accessToken, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &hubClient{conn: conn, reader: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(client.reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("connection was not upgraded: got %v", resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept header: got %v", accept)
+	}
+
+	client.writeFrame(t, 0x1, []byte(`{"protocol":"`+protocol+`","version":1}`+"\x1e"))
+	if _, payload := client.readFrame(t); string(payload) != "{}\x1e" {
+		t.Fatalf("unexpected handshake response: got %q", payload)
+	}
+	return client
+}
+
+// writeFrame sends a masked frame like browsers do.
+func (c *hubClient) writeFrame(t *testing.T, opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *hubClient) readFrame(t *testing.T) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+// receiveMessage reads the next ReceiveMessage invocation of the JSON protocol.
+func (c *hubClient) receiveMessage(t *testing.T) (int, map[string]interface{}) {
+	for {
+		_, payload := c.readFrame(t)
+		var invocation struct {
+			Type      int
+			Target    string
+			Arguments []struct {
+				Type    int
+				Payload map[string]interface{}
+			}
+		}
+		if err := json.Unmarshal(bytes.TrimSuffix(payload, []byte{0x1e}), &invocation); err != nil {
+			t.Fatal(err)
+		}
+		if invocation.Type == 6 {
+			continue // ping
+		}
+		if invocation.Target != "ReceiveMessage" || len(invocation.Arguments) != 1 {
+			t.Fatalf("unexpected message: got %s", payload)
+		}
+		return invocation.Arguments[0].Type, invocation.Arguments[0].Payload
+	}
+}
+
+func waitForConnections(t *testing.T, api *API, user *database.User, count int) {
+	for i := 0; i < 100 && api.hub.Connections(user.Id) != count; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if connections := api.hub.Connections(user.Id); connections != count {
+		t.Fatalf("unexpected number of connections: got %v want %v", connections, count)
+	}
+}
+
+func TestNotificationsHub(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	token := loginTokens(t, api)
+
+	server := httptest.NewServer(jwtauth.Verify(api.jwt, TokenFromAccessTokenQuery)(
+		jwtauth.Authenticator(api.ValidateSecurityStamp(http.HandlerFunc(api.NotificationsHub)))))
+	defer server.Close()
+
+	// Invalid tokens are rejected
+	resp, err := http.Get(server.URL + "/notifications/hub?access_token=invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("connection with an invalid token was accepted: got %v", resp.StatusCode)
+	}
+
+	jsonClient := connectHub(t, server, token.AccessToken, "json")
+	msgPackClient := connectHub(t, server, token.AccessToken, "messagepack")
+	waitForConnections(t, api, user, 2)
+
+	// Both clients are notified about a new folder
+	req, _ := http.NewRequest("POST", "/api/folders", strings.NewReader(`{"name":"encryptedname"}`))
+	if rr := serveAuthenticated(t, api, user, api.FolderCreate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	messageType, payload := jsonClient.receiveMessage(t)
+	if messageType != 7 || payload["Id"] == nil || payload["UserId"] != strconv.FormatUint(user.Id, 10) {
+		t.Errorf("unexpected folder notification: got %v %v", messageType, payload)
+	}
+
+	opcode, data := msgPackClient.readFrame(t)
+	// Length prefix followed by the invocation array [1, {}, nil, "ReceiveMessage", [...]]
+	if opcode != 0x2 || int(data[0]) != len(data)-1 || !bytes.HasPrefix(data[1:], []byte{0x95, 0x01, 0x80, 0xc0}) ||
+		!bytes.Contains(data, []byte("ReceiveMessage")) {
+		t.Errorf("unexpected messagepack notification: got %x", data)
+	}
+
+	// Messages that cannot be encoded are skipped without affecting the connections
+	api.hub.Publish(notifications.Message{Type: notifications.SyncVault, Payload: map[string]interface{}{"Invalid": make(chan int)}}, user.Id)
+
+	// A message that only one protocol cannot encode still reaches the clients of the other protocol
+	api.hub.Publish(notifications.Message{Type: notifications.SyncVault, Payload: map[string]interface{}{"Float": 1.5}}, user.Id)
+	if messageType, _ := jsonClient.receiveMessage(t); messageType != 5 {
+		t.Errorf("unexpected notification: got %v want 5", messageType)
+	}
+
+	// Ending all sessions logs out the clients
+	req, _ = http.NewRequest("POST", "/api/accounts/security-stamp", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+	if rr := serveAuthenticated(t, api, user, api.AccountSecurityStamp, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if messageType, _ := jsonClient.receiveMessage(t); messageType != 11 {
+		t.Errorf("unexpected notification: got %v want 11", messageType)
+	}
+
+	// Closed connections are removed from the hub
+	jsonClient.writeFrame(t, 0x1, []byte(`{"type":7}`+"\x1e"))
+	msgPackClient.conn.Close()
+	waitForConnections(t, api, user, 0)
+}
+
+func TestCipherNotificationRecipients(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Local stand-in for the push relay
+	type relayMessage struct {
+		PushTokens []string
+		Type       int
+		Payload    map[string]interface{}
+	}
+	pushed := make(chan relayMessage, 10)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var message relayMessage
+		json.NewDecoder(req.Body).Decode(&message)
+		pushed <- message
+	}))
+	defer relay.Close()
+	pushNotifier, err := notifications.NewRelayPushNotifier(relay.URL, "relaykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.push = pushNotifier
+
+	// Prepare DB, the member can access the collection of the cipher, the outsider cannot
+	owner := createUser(t, api.db.DB)
+	member := createMember(t, api)
+	organizationId, membership := createOrganization(t, api, owner, member)
+	outsider := database.User{Email: "outsider@test.com", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&outsider).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer api.db.DB.Delete(&outsider)
+	collection := database.Collection{OrganizationId: organizationId, Name: testEncString}
+	if err := api.db.DB.Create(&collection).Error; err != nil {
+		t.Fatal(err)
+	}
+	cipher := database.Cipher{OrganizationId: &organizationId, Type: 2, Data: `{"name":"note"}`, CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&cipher).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []interface{}{
+		&database.OrganizationUser{OrganizationId: organizationId, UserId: &outsider.Id, Key: testEncString,
+			Status: database.OrganizationUserStatusConfirmed, Type: database.OrganizationUserTypeUser},
+		&database.CollectionUser{CollectionId: collection.Id, OrganizationUserId: membership.Id},
+		&database.CollectionCipher{CollectionId: collection.Id, CipherId: cipher.Id},
+		&database.Device{UserId: member.Id, Identifier: "member-device", PushToken: "member-token"},
+		&database.Device{UserId: outsider.Id, Identifier: "outsider-device", PushToken: "outsider-token"},
+	} {
+		if err := api.db.DB.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	cipherId := strconv.FormatUint(cipher.Id, 10)
+	collectionId := strconv.FormatUint(collection.Id, 10)
+
+	expectPush := func(messageType int, collectionIds ...string) {
+		t.Helper()
+		select {
+		case message := <-pushed:
+			if message.Type != messageType || len(message.PushTokens) != 1 || message.PushTokens[0] != "member-token" {
+				t.Errorf("unexpected push notification: got %+v", message)
+			}
+			ids, _ := message.Payload["CollectionIds"].([]interface{})
+			if len(ids) != len(collectionIds) || len(ids) == 1 && ids[0] != collectionIds[0] {
+				t.Errorf("unexpected collections in the push notification: got %v want %v", ids, collectionIds)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no push notification of type %v was sent", messageType)
+		}
+	}
+
+	// Only members that can see the cipher are notified about changes
+	req, _ := http.NewRequest("PUT", "/api/ciphers/"+cipherId, strings.NewReader(
+		`{"type":2,"organizationId":"`+strconv.FormatUint(organizationId, 10)+`","name":"`+testEncString+`","secureNote":{"type":0}}`))
+	req = withURLParam(req, "id", cipherId)
+	if rr := serveAuthenticated(t, api, owner, api.CipherUpdate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	expectPush(int(notifications.SyncCipherUpdate), collectionId)
+
+	// Members that lose access remove the cipher
+	req, _ = http.NewRequest("PUT", "/api/ciphers/"+cipherId+"/collections", strings.NewReader(`{"collectionIds":[]}`))
+	req = withURLParam(req, "id", cipherId)
+	if rr := serveAuthenticated(t, api, owner, api.CipherCollectionsUpdate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	expectPush(int(notifications.SyncLoginDelete))
+
+	// The deletion is sent with the former collections
+	api.db.DB.Create(&database.CollectionCipher{CollectionId: collection.Id, CipherId: cipher.Id})
+	req, _ = http.NewRequest("DELETE", "/api/ciphers/"+cipherId, nil)
+	req = withURLParam(req, "id", cipherId)
+	if rr := serveAuthenticated(t, api, owner, api.CipherDelete, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	expectPush(int(notifications.SyncLoginDelete), collectionId)
+
+	time.Sleep(100 * time.Millisecond)
+	if len(pushed) != 0 {
+		t.Errorf("unexpected push notification: got %+v", <-pushed)
+	}
+}
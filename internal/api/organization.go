@@ -0,0 +1,681 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// organizationInviteExpiry is the time an invited user has to accept the invitation.
+const organizationInviteExpiry = 5 * 24 * time.Hour
+
+// OrganizationCreate creates a new organization, the authenticated user becomes its owner.
+func (a *API) OrganizationCreate(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization create, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.OrganizationCreateRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("organization create decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Name == "" {
+		http.Error(w, "name is missing", http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Key) || requestData.Keys.PublicKey == "" ||
+		!encStringPattern.MatchString(requestData.Keys.EncryptedPrivateKey) {
+		http.Error(w, "organization keys are missing or invalid", http.StatusBadRequest)
+		return
+	}
+
+	currentTime := time.Now()
+	organization := database.Organization{
+		Name:         requestData.Name,
+		BillingEmail: requestData.BillingEmail,
+		PlanType:     requestData.PlanType,
+		UseTotp:      true,
+		Enabled:      true,
+		MaxStorageGb: 1024,
+		PublicKey:    requestData.Keys.PublicKey,
+		PrivateKey:   requestData.Keys.EncryptedPrivateKey,
+		CreationDate: currentTime,
+		RevisionDate: currentTime,
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&organization).Error; err != nil {
+			return err
+		}
+		owner := database.OrganizationUser{
+			OrganizationId: organization.Id,
+			UserId:         &user.Id,
+			Key:            requestData.Key,
+			Status:         database.OrganizationUserStatusConfirmed,
+			Type:           database.OrganizationUserTypeOwner,
+			AccessAll:      true,
+			CreationDate:   currentTime,
+			RevisionDate:   currentTime,
+		}
+		if err := tx.Create(&owner).Error; err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("organization create, failed to store organization: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	organizationResponse := organizationResponseFromModel(&organization)
+	MustRespondJSON(w, &organizationResponse)
+}
+
+// OrganizationList returns all organizations the authenticated user has joined.
+func (a *API) OrganizationList(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	organizations, err := a.profileOrganizationsFromUser(user)
+	if err != nil {
+		log.Errorf("organization list, failed to load organizations: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: organizations, Object: "list"})
+}
+
+// OrganizationGet returns the details of an organization the authenticated user is a confirmed member of.
+func (a *API) OrganizationGet(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization get, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("organization get, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	organizationResponse := organizationResponseFromModel(&membership.Organization)
+	MustRespondJSON(w, &organizationResponse)
+}
+
+// OrganizationKeys returns the public key and the encrypted private key of an organization.
+func (a *API) OrganizationKeys(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization keys, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("organization keys, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	MustRespondJSON(w, &bw.OrganizationKeysResponseModel{
+		PublicKey:  membership.Organization.PublicKey,
+		PrivateKey: membership.Organization.PrivateKey,
+		Object:     "organizationKeys",
+	})
+}
+
+// OrganizationLeave removes the authenticated user from an organization. The last owner cannot leave, the
+// organization would be unmanageable otherwise.
+func (a *API) OrganizationLeave(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization leave, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	organizationId, err := getIdParam(req, "id")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var membership database.OrganizationUser
+	if err := a.db.DB.Where("organization_id = ? AND user_id = ?", organizationId, user.Id).First(&membership).Error; err != nil {
+		log.Errorf("organization leave, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if err := a.removeMember(req, &membership); err != nil {
+		if err == errLastOwner {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Errorf("organization leave, failed to remove membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// OrganizationUserList returns all members and pending invitations of an organization.
+func (a *API) OrganizationUserList(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization user list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("organization user list, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !membership.IsAdmin() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var members []database.OrganizationUser
+	if err := a.db.DB.Preload("User").Where("organization_id = ?", membership.OrganizationId).Find(&members).Error; err != nil {
+		log.Errorf("organization user list, failed to load members: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	memberResponses := make([]bw.OrganizationUserResponseModel, len(members))
+	for i := range members {
+		memberResponses[i] = organizationUserResponseFromModel(&members[i])
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: memberResponses, Object: "list"})
+}
+
+// OrganizationUserInvite invites users to an organization by email. Each invited address receives a link
+// containing a signed token which is needed to accept the invitation.
+func (a *API) OrganizationUserInvite(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization invite, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("organization invite, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !membership.IsAdmin() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var requestData bw.OrganizationUserInviteRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("organization invite decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requestData.Emails) == 0 {
+		http.Error(w, "emails are missing", http.StatusBadRequest)
+		return
+	}
+	if requestData.Type < database.OrganizationUserTypeOwner || requestData.Type > database.OrganizationUserTypeManager {
+		http.Error(w, "invalid user type", http.StatusBadRequest)
+		return
+	}
+	if requestData.Type == database.OrganizationUserTypeOwner && membership.Type != database.OrganizationUserTypeOwner {
+		http.Error(w, "only owners can invite new owners", http.StatusForbidden)
+		return
+	}
+
+	var members []database.OrganizationUser
+	if err := a.db.DB.Preload("User").Where("organization_id = ?", membership.OrganizationId).Find(&members).Error; err != nil {
+		log.Errorf("organization invite, failed to load members: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	currentTime := time.Now()
+	invites := make([]database.OrganizationUser, 0, len(requestData.Emails))
+	for _, email := range requestData.Emails {
+		email = strings.TrimSpace(email)
+		if !strings.Contains(email, "@") {
+			http.Error(w, "invalid email: "+email, http.StatusBadRequest)
+			return
+		}
+		for i := range members {
+			if strings.EqualFold(memberEmail(&members[i]), email) {
+				http.Error(w, email+" is already a member of this organization", http.StatusBadRequest)
+				return
+			}
+		}
+		invites = append(invites, database.OrganizationUser{
+			OrganizationId: membership.OrganizationId,
+			Email:          email,
+			Status:         database.OrganizationUserStatusInvited,
+			Type:           requestData.Type,
+			AccessAll:      requestData.AccessAll,
+			CreationDate:   currentTime,
+			RevisionDate:   currentTime,
+		})
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range invites {
+			if err := tx.Create(&invites[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("organization invite, failed to store invitations: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range invites {
+		if err := a.sendOrganizationInvite(req, &membership.Organization, &invites[i]); err != nil {
+			log.Errorf("organization invite email failed: %s", err.Error())
+		}
+	}
+}
+
+// OrganizationUserAccept links an invitation to the authenticated user. The invitation token must have been
+// issued for the email address of the user. An administrator has to confirm the membership afterwards.
+func (a *API) OrganizationUserAccept(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization accept, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.OrganizationUserAcceptRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("organization accept decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if a.cfg.Core.RequireEmailVerificationOrgInvite && !user.EmailVerified {
+		http.Error(w, "verify your email address before joining an organization", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := a.getOrganizationUserFromRequest(req)
+	if err != nil || invite.Status != database.OrganizationUserStatusInvited {
+		http.Error(w, "invalid invitation", http.StatusBadRequest)
+		return
+	}
+	if !a.validOrganizationInviteToken(requestData.Token, invite) || !strings.EqualFold(invite.Email, user.Email) {
+		http.Error(w, "invalid invitation token", http.StatusBadRequest)
+		return
+	}
+
+	var existing int
+	a.db.DB.Model(&database.OrganizationUser{}).
+		Where("organization_id = ? AND user_id = ?", invite.OrganizationId, user.Id).Count(&existing)
+	if existing > 0 {
+		http.Error(w, "you are already a member of this organization", http.StatusBadRequest)
+		return
+	}
+
+	invite.UserId = &user.Id
+	invite.Status = database.OrganizationUserStatusAccepted
+	invite.RevisionDate = time.Now()
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(invite).Error; err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, user.Id)
+	})
+	if err != nil {
+		log.Errorf("organization accept, failed to store membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyUser(req, notifications.SyncVault, user.Id)
+}
+
+// OrganizationUserConfirm completes a membership. The administrator sends the organization key encrypted with
+// the public key of the new member.
+func (a *API) OrganizationUserConfirm(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization confirm, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("organization confirm, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !membership.IsAdmin() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var requestData bw.OrganizationUserConfirmRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("organization confirm decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !encStringPattern.MatchString(requestData.Key) {
+		http.Error(w, "key is missing or invalid", http.StatusBadRequest)
+		return
+	}
+
+	member, err := a.getOrganizationUserFromRequest(req)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if member.Status != database.OrganizationUserStatusAccepted || member.UserId == nil {
+		http.Error(w, "user has not accepted the invitation", http.StatusBadRequest)
+		return
+	}
+
+	member.Key = requestData.Key
+	member.Status = database.OrganizationUserStatusConfirmed
+	member.RevisionDate = time.Now()
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(member).Error; err != nil {
+			return err
+		}
+		return database.UpdateAccountRevisionDate(tx, *member.UserId)
+	})
+	if err != nil {
+		log.Errorf("organization confirm, failed to store membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.notifyUser(req, notifications.SyncOrgKeys, *member.UserId)
+}
+
+// OrganizationUserDelete removes a member or a pending invitation from an organization.
+func (a *API) OrganizationUserDelete(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("organization user delete, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	membership, err := a.getMembershipFromRequest(req, user)
+	if err != nil {
+		log.Errorf("organization user delete, unable to load membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !membership.IsAdmin() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	member, err := a.getOrganizationUserFromRequest(req)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if member.Type == database.OrganizationUserTypeOwner && membership.Type != database.OrganizationUserTypeOwner {
+		http.Error(w, "only owners can remove other owners", http.StatusForbidden)
+		return
+	}
+
+	if err := a.removeMember(req, member); err != nil {
+		if err == errLastOwner {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Errorf("organization user delete, failed to remove membership: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// errLastOwner is returned if the last confirmed owner of an organization would be removed.
+var errLastOwner = errors.New("an organization needs at least one confirmed owner")
+
+// removeMember deletes the membership and notifies the removed user. The last confirmed owner cannot be removed.
+func (a *API) removeMember(req *http.Request, member *database.OrganizationUser) error {
+	err := a.db.DB.Transaction(func(tx *gorm.DB) error {
+		if member.Type == database.OrganizationUserTypeOwner && member.Status == database.OrganizationUserStatusConfirmed {
+			var owners int
+			err := tx.Model(&database.OrganizationUser{}).
+				Where("organization_id = ? AND type = ? AND status = ?", member.OrganizationId,
+					database.OrganizationUserTypeOwner, database.OrganizationUserStatusConfirmed).
+				Count(&owners).Error
+			if err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return errLastOwner
+			}
+		}
+
+		if err := tx.Where("organization_user_id = ?", member.Id).Delete(&database.CollectionUser{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		if member.UserId != nil {
+			return database.UpdateAccountRevisionDate(tx, *member.UserId)
+		}
+		return nil
+	})
+	if err == nil && member.UserId != nil {
+		a.notifyUser(req, notifications.SyncVault, *member.UserId)
+	}
+	return err
+}
+
+// getMembershipFromRequest loads the confirmed membership of the user in the organization referenced by the
+// id URL parameter. The organization is loaded too.
+func (a *API) getMembershipFromRequest(req *http.Request, user *database.User) (*database.OrganizationUser, error) {
+	organizationId, err := getIdParam(req, "id")
+	if err != nil {
+		return nil, errors.New("invalid organization id")
+	}
+
+	var membership database.OrganizationUser
+	err = a.db.DB.Preload("Organization").
+		Where("organization_id = ? AND user_id = ? AND status = ?", organizationId, user.Id,
+			database.OrganizationUserStatusConfirmed).
+		First(&membership).Error
+	if err != nil {
+		return nil, err
+	}
+	if !membership.Organization.Enabled {
+		return nil, errors.New("organization is disabled")
+	}
+
+	return &membership, nil
+}
+
+// getOrganizationUserFromRequest loads the member referenced by the organizationUserId URL parameter. It must
+// belong to the organization referenced by the id URL parameter.
+func (a *API) getOrganizationUserFromRequest(req *http.Request) (*database.OrganizationUser, error) {
+	organizationId, err := getIdParam(req, "id")
+	if err != nil {
+		return nil, errors.New("invalid organization id")
+	}
+	organizationUserId, err := getIdParam(req, "organizationUserId")
+	if err != nil {
+		return nil, errors.New("invalid organization user id")
+	}
+
+	var member database.OrganizationUser
+	err = a.db.DB.Where("id = ? AND organization_id = ?", organizationUserId, organizationId).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// sendOrganizationInvite emails the invitation link to the invited address.
+func (a *API) sendOrganizationInvite(req *http.Request, organization *database.Organization, invite *database.OrganizationUser) error {
+	token, err := a.organizationInviteToken(invite)
+	if err != nil {
+		return err
+	}
+
+	vaultURL := a.cfg.Core.VaultURL
+	if vaultURL == "" {
+		vaultURL = baseURL(req)
+	}
+	query := url.Values{}
+	query.Set("organizationId", strconv.FormatUint(organization.Id, 10))
+	query.Set("organizationUserId", strconv.FormatUint(invite.Id, 10))
+	query.Set("email", invite.Email)
+	query.Set("organizationName", organization.Name)
+	query.Set("token", token)
+
+	body := strings.NewReplacer(
+		"{OrganizationName}", organization.Name,
+		"{AcceptUrl}", strings.TrimRight(vaultURL, "/")+"/#/accept-organization?"+query.Encode(),
+	).Replace(bw.EmailOrganizationInvite)
+
+	return bw.SendEmail(a.cfg, "Join "+organization.Name, body, invite.Email)
+}
+
+// organizationInviteToken signs a token that allows the invited address to accept the invitation.
+func (a *API) organizationInviteToken(invite *database.OrganizationUser) (string, error) {
+	claims := jwt.MapClaims{
+		"organizationUser": invite.Id,
+		"email":            invite.Email,
+		"exp":              time.Now().Add(organizationInviteExpiry).Unix(),
+	}
+	_, token, err := a.jwt.Encode(claims)
+	return token, err
+}
+
+// validOrganizationInviteToken checks that the token was issued by this server for the invitation.
+func (a *API) validOrganizationInviteToken(tokenString string, invite *database.OrganizationUser) bool {
+	token, err := a.jwt.Decode(tokenString)
+	if err != nil || !token.Valid {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	organizationUserId, ok := claims["organizationUser"].(float64)
+	return ok && uint64(organizationUserId) == invite.Id && claims["email"] == invite.Email
+}
+
+// profileOrganizationsFromUser returns the organizations the user has accepted an invitation for.
+func (a *API) profileOrganizationsFromUser(user *database.User) ([]bw.ProfileOrganizationResponseModel, error) {
+	var memberships []database.OrganizationUser
+	err := a.db.DB.Preload("Organization").
+		Where("user_id = ? AND status IN (?)", user.Id,
+			[]int{database.OrganizationUserStatusAccepted, database.OrganizationUserStatusConfirmed}).
+		Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+
+	organizations := make([]bw.ProfileOrganizationResponseModel, len(memberships))
+	for i := range memberships {
+		organizations[i] = profileOrganizationResponseFromModel(&memberships[i])
+	}
+
+	return organizations, nil
+}
+
+// profileOrganizationResponseFromModel converts the membership to the structure the clients expect. The
+// organization key is only available after the membership was confirmed.
+func profileOrganizationResponseFromModel(membership *database.OrganizationUser) bw.ProfileOrganizationResponseModel {
+	var key *string
+	if membership.Status == database.OrganizationUserStatusConfirmed {
+		key = &membership.Key
+	}
+
+	return bw.ProfileOrganizationResponseModel{
+		Id:           membership.OrganizationId,
+		Name:         membership.Organization.Name,
+		UseTotp:      membership.Organization.UseTotp,
+		SelfHost:     true,
+		MaxStorageGb: membership.Organization.MaxStorageGb,
+		Key:          key,
+		Status:       membership.Status,
+		Type:         membership.Type,
+		Enabled:      membership.Organization.Enabled,
+		Object:       "profileOrganization",
+	}
+}
+
+// organizationResponseFromModel converts the organization to the structure the clients expect.
+func organizationResponseFromModel(organization *database.Organization) bw.OrganizationResponseModel {
+	return bw.OrganizationResponseModel{
+		Id:           organization.Id,
+		Name:         organization.Name,
+		BillingEmail: organization.BillingEmail,
+		PlanType:     organization.PlanType,
+		MaxStorageGb: organization.MaxStorageGb,
+		UseTotp:      organization.UseTotp,
+		SelfHost:     true,
+		Object:       "organization",
+	}
+}
+
+// organizationUserResponseFromModel converts the member to the structure the clients expect.
+func organizationUserResponseFromModel(member *database.OrganizationUser) bw.OrganizationUserResponseModel {
+	return bw.OrganizationUserResponseModel{
+		Id:        member.Id,
+		UserId:    member.UserId,
+		Name:      member.User.Name,
+		Email:     memberEmail(member),
+		Type:      member.Type,
+		Status:    member.Status,
+		AccessAll: member.AccessAll,
+		Object:    "organizationUserUserDetails",
+	}
+}
+
+// memberEmail returns the address of the member, pending invitations only know the invited address.
+func memberEmail(member *database.OrganizationUser) string {
+	if member.UserId != nil && member.User.Email != "" {
+		return member.User.Email
+	}
+	return member.Email
+}
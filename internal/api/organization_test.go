@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/notifications"
+)
+
+// createMember creates a second user that can be invited to an organization.
+func createMember(t *testing.T, api *API) *database.User {
+	member := database.User{
+		Name:          "Member",
+		Email:         "member@test.com",
+		Culture:       "en-US",
+		SecurityStamp: "stamp",
+		PublicKey:     "memberpublickey",
+		CreationDate:  time.Now(),
+		RevisionDate:  time.Now(),
+		KdfIterations: 6000,
+	}
+	if err := api.db.DB.Create(&member).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		api.db.DB.Delete(&member)
+	})
+
+	return &member
+}
+
+func syncCipherCount(t *testing.T, api *API, user *database.User) int {
+	req, _ := http.NewRequest("GET", "/api/sync", nil)
+	rr := serveAuthenticated(t, api, user, api.Sync, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("sync returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var syncResponse common.SyncResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &syncResponse); err != nil {
+		t.Fatal(err)
+	}
+	return len(syncResponse.Ciphers)
+}
+
+func organizationRequest(method, path string, organizationId uint64, body string) *http.Request {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return withURLParam(req, "id", strconv.FormatUint(organizationId, 10))
+}
+
+func TestOrganizationMembership(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	owner := createUser(t, api.db.DB)
+	member := createMember(t, api)
+
+	// Create the organization
+	req, _ := http.NewRequest("POST", "/api/organizations", strings.NewReader(
+		`{"name":"Team","billingEmail":"test@test.com","planType":0,"key":"`+testEncString+
+			`","keys":{"publicKey":"orgpublickey","encryptedPrivateKey":"`+testEncString+`"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := serveAuthenticated(t, api, owner, api.OrganizationCreate, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+
+	var organizationResponse common.OrganizationResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &organizationResponse); err != nil {
+		t.Fatal(err)
+	}
+	organizationId := organizationResponse.Id
+	t.Cleanup(func() {
+		api.db.DB.Where("organization_id = ?", organizationId).Delete(&database.OrganizationUser{})
+		api.db.DB.Where("organization_id = ?", organizationId).Delete(&database.Cipher{})
+		api.db.DB.Delete(&database.Organization{Id: organizationId})
+	})
+
+	cipher := database.Cipher{OrganizationId: &organizationId, Type: 2, Data: `{"name":"shared"}`,
+		CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&cipher).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count := syncCipherCount(t, api, owner); count != 1 {
+		t.Errorf("owner does not see the organization cipher: got %d ciphers", count)
+	}
+
+	// Invite and accept
+	invite := database.OrganizationUser{OrganizationId: organizationId, Email: member.Email,
+		Status: database.OrganizationUserStatusInvited, Type: database.OrganizationUserTypeUser, AccessAll: true}
+	if err := api.db.DB.Create(&invite).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, err := api.organizationInviteToken(&invite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/api/organizations/" + strconv.FormatUint(organizationId, 10) + "/users/" + strconv.FormatUint(invite.Id, 10)
+	req = organizationRequest("POST", path+"/accept", organizationId, `{"token":"invalid"}`)
+	req = withURLParam(req, "organizationUserId", strconv.FormatUint(invite.Id, 10))
+	rr = serveAuthenticated(t, api, member, api.OrganizationUserAccept, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("invalid token was accepted: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	req = organizationRequest("POST", path+"/accept", organizationId, `{"token":"`+token+`"}`)
+	req = withURLParam(req, "organizationUserId", strconv.FormatUint(invite.Id, 10))
+	rr = serveAuthenticated(t, api, member, api.OrganizationUserAccept, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	if count := syncCipherCount(t, api, member); count != 0 {
+		t.Errorf("unconfirmed member sees organization ciphers: got %d ciphers", count)
+	}
+
+	// Confirm
+	req = organizationRequest("POST", path+"/confirm", organizationId, `{"key":"`+testEncString+`"}`)
+	req = withURLParam(req, "organizationUserId", strconv.FormatUint(invite.Id, 10))
+	rr = serveAuthenticated(t, api, owner, api.OrganizationUserConfirm, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s",
+			status, http.StatusOK, rr.Body.String())
+	}
+	if count := syncCipherCount(t, api, member); count != 1 {
+		t.Errorf("confirmed member does not see the organization cipher: got %d ciphers", count)
+	}
+
+	// Keys are available to members
+	req = organizationRequest("GET", "/api/organizations/"+strconv.FormatUint(organizationId, 10)+"/keys", organizationId, "")
+	rr = serveAuthenticated(t, api, member, api.OrganizationKeys, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// The only owner cannot leave
+	req = organizationRequest("POST", "/api/organizations/"+strconv.FormatUint(organizationId, 10)+"/leave", organizationId, "")
+	rr = serveAuthenticated(t, api, owner, api.OrganizationLeave, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// Members can
+	req = organizationRequest("POST", "/api/organizations/"+strconv.FormatUint(organizationId, 10)+"/leave", organizationId, "")
+	rr = serveAuthenticated(t, api, member, api.OrganizationLeave, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if count := syncCipherCount(t, api, member); count != 0 {
+		t.Errorf("former member still sees organization ciphers: got %d ciphers", count)
+	}
+}
+
+func TestOrganizationNotifications(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Local stand-in for the push relay
+	pushed := make(chan int, 10)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var message struct {
+			Type int
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, &message)
+		pushed <- message.Type
+	}))
+	defer relay.Close()
+	pushNotifier, err := notifications.NewRelayPushNotifier(relay.URL, "relaykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.push = pushNotifier
+
+	// Prepare DB, the member is logged in on a phone
+	owner := createUser(t, api.db.DB)
+	member := createMember(t, api)
+	organizationId, membership := createOrganization(t, api, owner, member)
+	organization := strconv.FormatUint(organizationId, 10)
+	phone := database.Device{UserId: member.Id, Name: "android", Identifier: "phone-device", PushToken: "phone-token"}
+	if err := api.db.DB.Create(&phone).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { api.db.DB.Delete(&phone) })
+
+	expectPush := func(messageType int) {
+		t.Helper()
+		select {
+		case pushedType := <-pushed:
+			if pushedType != messageType {
+				t.Errorf("unexpected push notification: got %v want %v", pushedType, messageType)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no push notification of type %v was sent", messageType)
+		}
+	}
+
+	// New collections are synced by the members
+	req := organizationRequest("POST", "/api/organizations/"+organization+"/collections", organizationId,
+		`{"name":"`+testEncString+`"}`)
+	if rr := serveAuthenticated(t, api, owner, api.OrganizationCollectionCreate, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	expectPush(int(notifications.SyncVault))
+
+	// Confirmed members receive the organization keys
+	api.db.DB.Model(membership).UpdateColumn("status", database.OrganizationUserStatusAccepted)
+	path := "/api/organizations/" + organization + "/users/" + strconv.FormatUint(membership.Id, 10)
+	req = organizationRequest("POST", path+"/confirm", organizationId, `{"key":"`+testEncString+`"}`)
+	req = withURLParam(req, "organizationUserId", strconv.FormatUint(membership.Id, 10))
+	if rr := serveAuthenticated(t, api, owner, api.OrganizationUserConfirm, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	expectPush(int(notifications.SyncOrgKeys))
+
+	// Removed members sync their vault without the organization
+	req = organizationRequest("DELETE", path, organizationId, "")
+	req = withURLParam(req, "organizationUserId", strconv.FormatUint(membership.Id, 10))
+	if rr := serveAuthenticated(t, api, owner, api.OrganizationUserDelete, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	expectPush(int(notifications.SyncVault))
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows a fixed number of requests per key within a time window. The counts are kept in memory,
+// they start over when the server restarts.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mutex     sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		window:    window,
+		windows:   make(map[string]*rateWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow counts a request for the key and returns false if the limit of the current window is exceeded.
+func (r *rateLimiter) Allow(key string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastSweep) > r.window {
+		// Forget the keys without requests in the last window, the map would grow forever otherwise
+		for windowKey, window := range r.windows {
+			if now.Sub(window.start) > r.window {
+				delete(r.windows, windowKey)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	window, ok := r.windows[key]
+	if !ok || now.Sub(window.start) > r.window {
+		window = &rateWindow{start: now}
+		r.windows[key] = window
+	}
+	window.count++
+	return window.count <= r.limit
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP returns a middleware that replaces the remote address of requests sent by one of the trusted proxies
+// with the client address the proxy forwarded. The forwarding headers of other clients are ignored, they could
+// send any address and evade the rate limits. The proxies are given as addresses or networks in CIDR notation.
+func RealIP(trustedProxies []string) (func(http.Handler) http.Handler, error) {
+	networks := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy: " + proxy)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy: " + proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	trusted := func(address string) bool {
+		ip := net.ParseIP(address)
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if trusted(clientIP(req)) {
+				if address := forwardedIP(req, trusted); address != "" {
+					req.RemoteAddr = address
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}, nil
+}
+
+// forwardedIP returns the client address forwarded by the proxy. Proxies append the address of their peer to
+// X-Forwarded-For, the last address that was not added by one of the trusted proxies is the client.
+func forwardedIP(req *http.Request, trusted func(string) bool) string {
+	if address := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(address) != nil {
+		return address
+	}
+
+	addresses := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if net.ParseIP(address) == nil {
+			return ""
+		}
+		if i == 0 || !trusted(address) {
+			return address
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	if _, err := RealIP([]string{"not-an-address"}); err == nil {
+		t.Error("invalid trusted proxy was accepted")
+	}
+	realIP, err := RealIP([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remoteIP := func(peer string, header http.Header) string {
+		var address string
+		handler := realIP(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			address = clientIP(req)
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = peer + ":1234"
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return address
+	}
+
+	tests := []struct {
+		peer   string
+		header http.Header
+		want   string
+	}{
+		{"10.0.0.1", http.Header{"X-Real-Ip": {"1.2.3.4"}}, "1.2.3.4"},
+		{"192.168.1.1", http.Header{"X-Forwarded-For": {"5.6.7.8, 1.2.3.4, 10.0.0.1"}}, "1.2.3.4"},
+		{"10.0.0.1", http.Header{"X-Forwarded-For": {"192.168.1.1, 10.0.0.1"}}, "192.168.1.1"},
+		{"10.0.0.1", http.Header{"X-Forwarded-For": {"invalid"}}, "10.0.0.1"},
+		{"10.0.0.2", http.Header{"X-Real-Ip": {"1.2.3.4"}}, "10.0.0.2"},
+		{"10.0.0.2", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, "10.0.0.2"},
+	}
+	for _, test := range tests {
+		if got := remoteIP(test.peer, test.header); got != test.want {
+			t.Errorf("unexpected client address for %s with %v: got %s, want %s", test.peer, test.header, got, test.want)
+		}
+	}
+
+	// Rotating the forwarded address does not evade the rate limits
+	api := setup(t)
+	handler := realIP(http.HandlerFunc(api.AccountPasswordHint))
+	var rr *httptest.ResponseRecorder
+	for i := 0; i <= passwordHintIPLimit; i++ {
+		req, _ := http.NewRequest("POST", "/api/accounts/password-hint",
+			strings.NewReader(`{"email":"unknown`+strconv.Itoa(i)+`@test.com"}`))
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("X-Forwarded-For", "1.2.3."+strconv.Itoa(i))
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+	}
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("requests with spoofed addresses were not limited: got %v", rr.Code)
+	}
+}
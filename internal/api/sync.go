@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// Sync returns the whole vault of the authenticated user. Clients call it right after logging in.
+func (a *API) Sync(w http.ResponseWriter, req *http.Request) {
+	access, err := a.getUserAccessFromRequest(req)
+	if err != nil {
+		log.Errorf("sync, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := access.user
+
+	var folders []database.Folder
+	if err := a.db.DB.Where("user_id = ?", user.Id).Find(&folders).Error; err != nil {
+		log.Errorf("sync, failed to load folders: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var ciphers []database.Cipher
+	if err := access.cipherQuery(a.db.DB).Find(&ciphers).Error; err != nil {
+		log.Errorf("sync, failed to load ciphers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := a.profileResponseFromUser(user)
+	if err != nil {
+		log.Errorf("sync, failed to load profile: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	syncResponse := bw.SyncResponseModel{
+		Profile:     profile,
+		Folders:     make([]bw.FolderResponseModel, len(folders)),
+		Collections: collectionResponses(access, nil),
+		Ciphers:     make([]bw.CipherResponseModel, 0, len(ciphers)),
+		Policies:    []bw.PolicyResponseModel{},
+		Object:      "sync",
+	}
+
+	for i := range folders {
+		syncResponse.Folders[i] = folderResponseFromModel(&folders[i])
+	}
+
+	for i := range ciphers {
+		cipherResponse, err := a.cipherResponseFromModel(req, &ciphers[i], access)
+		if err != nil {
+			// Do not break the whole sync because of a single broken cipher
+			log.Errorf("sync, failed to decode cipher %d: %s", ciphers[i].Id, err.Error())
+			continue
+		}
+		syncResponse.Ciphers = append(syncResponse.Ciphers, cipherResponse)
+	}
+
+	if excludeDomains, _ := strconv.ParseBool(req.URL.Query().Get("excludeDomains")); !excludeDomains {
+		domains := domainsResponseFromUser(user)
+		syncResponse.Domains = &domains
+	}
+
+	MustRespondJSON(w, &syncResponse)
+}
+
+// profileResponseFromUser converts the user record to the profile structure the clients expect. The profile
+// also lists the organizations the user has joined.
+func (a *API) profileResponseFromUser(user *database.User) (bw.ProfileResponseModel, error) {
+	organizations, err := a.profileOrganizationsFromUser(user)
+	if err != nil {
+		return bw.ProfileResponseModel{}, err
+	}
+
+	return bw.ProfileResponseModel{
+		Id:                 user.Id,
+		Name:               user.Name,
+		Email:              user.Email,
+		EmailVerified:      user.EmailVerified,
+		Premium:            user.Premium,
+		MasterPasswordHint: user.MasterPasswordHint,
+		Culture:            user.Culture,
+		TwoFactorEnabled:   len(user.EnabledTwoFactorProviders()) > 0,
+		Key:                user.Key,
+		PrivateKey:         user.PrivateKey,
+		SecurityStamp:      user.SecurityStamp,
+		Organizations:      organizations,
+		Object:             "profile",
+	}, nil
+}
+
+// domainsResponseFromUser builds the equivalent domain settings of the user.
+func domainsResponseFromUser(user *database.User) bw.DomainsResponseModel {
+	domains := bw.DomainsResponseModel{
+		EquivalentDomains:       [][]string{},
+		GlobalEquivalentDomains: make([]bw.GlobalDomainsResponseModel, 0, len(bw.GlobalEquivalentDomains)),
+		Object:                  "domains",
+	}
+
+	if user.EquivalentDomains != "" {
+		if err := json.Unmarshal([]byte(user.EquivalentDomains), &domains.EquivalentDomains); err != nil {
+			log.Errorf("sync, invalid equivalent domains for %s: %s", user.Email, err.Error())
+		}
+	}
+
+	excluded := make(map[int]bool)
+	if user.ExcludedGlobalEquivalentDomains != "" {
+		var excludedTypes []int
+		if err := json.Unmarshal([]byte(user.ExcludedGlobalEquivalentDomains), &excludedTypes); err != nil {
+			log.Errorf("sync, invalid excluded domains for %s: %s", user.Email, err.Error())
+		}
+		for _, domainType := range excludedTypes {
+			excluded[domainType] = true
+		}
+	}
+
+	for domainType, domainList := range bw.GlobalEquivalentDomains {
+		domains.GlobalEquivalentDomains = append(domains.GlobalEquivalentDomains, bw.GlobalDomainsResponseModel{
+			Type:     domainType,
+			Domains:  domainList,
+			Excluded: excluded[domainType],
+		})
+	}
+	sort.Slice(domains.GlobalEquivalentDomains, func(i, j int) bool {
+		return domains.GlobalEquivalentDomains[i].Type < domains.GlobalEquivalentDomains[j].Type
+	})
+
+	return domains
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+func TestSync(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+	folder := database.Folder{UserId: user.Id, Name: "encryptedfolder", CreationDate: time.Now(), RevisionDate: time.Now()}
+	if err := api.db.DB.Create(&folder).Error; err != nil {
+		t.Fatal(err)
+	}
+	cipher := database.Cipher{
+		UserId:       &user.Id,
+		Type:         1,
+		Data:         `{"name":"encryptedname","login":{"username":"encrypteduser","uris":[]}}`,
+		CreationDate: time.Now(),
+		RevisionDate: time.Now(),
+	}
+	cipher.SetFolderId(user.Id, &folder.Id)
+	cipher.SetFavorite(user.Id, true)
+	if err := api.db.DB.Create(&cipher).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/sync", nil)
+	rr := serveAuthenticated(t, api, user, api.Sync, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var syncResponse common.SyncResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &syncResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	if syncResponse.Profile.Email != user.Email || syncResponse.Profile.Key != user.Key {
+		t.Errorf("handler returned unexpected profile: got %v", syncResponse.Profile)
+	}
+	if len(syncResponse.Folders) != 1 || syncResponse.Folders[0].Name != "encryptedfolder" {
+		t.Errorf("handler returned unexpected folders: got %v", syncResponse.Folders)
+	}
+	if len(syncResponse.Ciphers) != 1 {
+		t.Fatalf("handler returned unexpected ciphers: got %v", syncResponse.Ciphers)
+	}
+	if c := syncResponse.Ciphers[0]; c.Name != "encryptedname" || c.Login == nil || *c.Login.Username != "encrypteduser" ||
+		c.FolderId == nil || *c.FolderId != folder.Id || !c.Favorite {
+		t.Errorf("handler returned unexpected cipher: got %v", c)
+	}
+	if syncResponse.Domains == nil || len(syncResponse.Domains.GlobalEquivalentDomains) == 0 {
+		t.Errorf("handler returned no domains")
+	}
+
+	// Sync without domains
+	req, _ = http.NewRequest("GET", "/api/sync?excludeDomains=true", nil)
+	rr = serveAuthenticated(t, api, user, api.Sync, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	syncResponse = common.SyncResponseModel{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &syncResponse); err != nil {
+		t.Fatal(err)
+	}
+	if syncResponse.Domains != nil {
+		t.Errorf("handler returned domains although they were excluded")
+	}
+}
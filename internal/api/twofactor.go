@@ -0,0 +1,406 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// TwoFactorList returns the enabled two-factor providers of the authenticated user.
+func (a *API) TwoFactorList(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("two-factor list, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	enabled := user.EnabledTwoFactorProviders()
+	providerResponses := make([]bw.TwoFactorProviderResponseModel, len(enabled))
+	for i, providerType := range enabled {
+		providerResponses[i] = bw.TwoFactorProviderResponseModel{Enabled: true, Type: providerType, Object: "twoFactorProvider"}
+	}
+
+	MustRespondJSON(w, &bw.ListResponseModel{Data: providerResponses, Object: "list"})
+}
+
+// TwoFactorDisable turns off a two-factor provider of the authenticated user.
+func (a *API) TwoFactorDisable(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("two-factor disable, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.TwoFactorDisableRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("two-factor disable decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	providers := user.GetTwoFactorProviders()
+	delete(providers, requestData.Type)
+	if err := a.storeTwoFactorProviders(user, providers); err != nil {
+		log.Errorf("two-factor disable, failed to store providers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	MustRespondJSON(w, &bw.TwoFactorProviderResponseModel{Enabled: false, Type: requestData.Type, Object: "twoFactorProvider"})
+}
+
+// TwoFactorGetRecover returns the recovery code of the authenticated user. The code is generated on first use.
+func (a *API) TwoFactorGetRecover(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("get recover, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("get recover decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	if user.TwoFactorRecoveryCode == "" {
+		code, err := newRecoveryCode()
+		if err != nil {
+			log.Errorf("get recover, failed to read rand: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		user.TwoFactorRecoveryCode = code
+		if err := a.db.DB.Model(user).UpdateColumn("two_factor_recovery_code", code).Error; err != nil {
+			log.Errorf("get recover, failed to store recovery code: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	MustRespondJSON(w, &bw.TwoFactorRecoverResponseModel{Code: user.TwoFactorRecoveryCode, Object: "twoFactorRecover"})
+}
+
+// TwoFactorRecover disables all two-factor providers of a user that lost access to them. Instead of a second
+// factor the user proves the identity with the recovery code. The code can only be used once, all sessions
+// of the user are logged out.
+func (a *API) TwoFactorRecover(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.TwoFactorRecoveryRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("recover decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user database.User
+	recoveryCode := strings.ToUpper(strings.Replace(requestData.RecoveryCode, " ", "", -1))
+	if a.db.DB.Where("email = ?", requestData.Email).First(&user).RecordNotFound() ||
+		validateCredentials(&user, requestData.MasterPasswordHash) != nil ||
+		user.TwoFactorRecoveryCode == "" ||
+		subtle.ConstantTimeCompare([]byte(recoveryCode), []byte(user.TwoFactorRecoveryCode)) != 1 {
+		log.Errorf("recover failed, invalid credentials or recovery code: %s", requestData.Email)
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid email, master password or recovery code", http.StatusBadRequest)
+		return
+	}
+
+	newCode, err := newRecoveryCode()
+	if err != nil {
+		log.Errorf("recover, failed to read rand: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	securityStamp, err := newSecurityStamp()
+	if err != nil {
+		log.Errorf("recover, failed to read rand: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.db.DB.Transaction(func(tx *gorm.DB) error {
+		// Only succeed if the recovery code was not used concurrently
+		result := tx.Model(&database.User{}).
+			Where("id = ? AND two_factor_recovery_code = ?", user.Id, user.TwoFactorRecoveryCode).
+			UpdateColumns(map[string]interface{}{
+				"two_factor_providers":     "",
+				"two_factor_recovery_code": newCode,
+				"security_stamp":           securityStamp,
+				"revision_date":            time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errors.New("recovery code was used concurrently")
+		}
+		return tx.Where("subject_id = ?", strconv.FormatUint(user.Id, 10)).Delete(&database.Grant{}).Error
+	})
+	if err != nil {
+		log.Errorf("recover, failed to disable two-factor providers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("User %s disabled two-step login with the recovery code", user.Email)
+	if err := bw.SendEmail(a.cfg, "Two-step login turned off", bw.EmailTwoFactorRecovered, user.Email); err != nil {
+		log.Errorf("recover email failed: %s", err.Error())
+	}
+}
+
+// checkTwoFactor verifies the second factor of a password login. Without a token the client receives the
+// list of enabled providers and asks the user for a code. A remembered device can send its remember token
+// instead of a code. It returns false if the login must not continue, the response was written already. If the
+// user asked to remember the device, the new remember token is returned.
+func (a *API) checkTwoFactor(w http.ResponseWriter, req *http.Request, user *database.User) (string, bool) {
+	enabled := user.EnabledTwoFactorProviders()
+	if len(enabled) == 0 {
+		return "", true
+	}
+
+	token := req.PostForm.Get("twoFactorToken")
+	if token == "" {
+		a.respondTwoFactorChallenge(w, user, enabled)
+		return "", false
+	}
+
+	providerType, err := strconv.Atoi(req.PostForm.Get("twoFactorProvider"))
+	if err == nil && providerType == database.TwoFactorProviderRemember {
+		remembered, err := a.validateRememberToken(user, req.PostForm.Get("deviceIdentifier"), token)
+		if err != nil {
+			log.Errorf("Login failed, unable to check remember token of %s: %s", user.Email, err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return "", false
+		}
+		if !remembered {
+			// The client asks for a second factor again
+			log.Infof("Login of %s, device is not remembered anymore", user.Email)
+			a.respondTwoFactorChallenge(w, user, enabled)
+			return "", false
+		}
+		return "", true
+	}
+	if err != nil || !containsInt(enabled, providerType) {
+		respondTwoFactorError(w, "Invalid two-step login provider.")
+		return "", false
+	}
+
+	valid, err := a.validateTwoFactorToken(user, providerType, token)
+	if err != nil {
+		log.Errorf("Login failed, unable to check two-factor token of %s: %s", user.Email, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return "", false
+	}
+	if !valid {
+		log.Errorf("Login failed, invalid two-factor token: %s", user.Email)
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		respondTwoFactorError(w, "Two-step token is invalid. Try again.")
+		return "", false
+	}
+
+	if req.PostForm.Get("twoFactorRemember") != "1" {
+		return "", true
+	}
+	rememberToken, err := a.rememberDevice(user, req.PostForm.Get("client_id"), req.PostForm.Get("deviceIdentifier"))
+	if err != nil {
+		log.Errorf("Login failed, unable to remember device of %s: %s", user.Email, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return "", false
+	}
+	return rememberToken, true
+}
+
+// rememberDevice creates a token which allows the device to skip the second factor until it expires. No token
+// is created if remembering devices is turned off.
+func (a *API) rememberDevice(user *database.User, clientID, deviceIdentifier string) (string, error) {
+	if a.cfg.Security.TwoFactorRememberDays <= 0 {
+		return "", nil
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	grant := database.Grant{
+		Key:            base64.StdEncoding.EncodeToString(token),
+		Type:           database.GrantTypeTwoFactorRemember,
+		SubjectId:      strconv.FormatUint(user.Id, 10),
+		ClientId:       clientID,
+		Data:           deviceIdentifier,
+		CreationDate:   time.Now(),
+		ExpirationDate: time.Now().AddDate(0, 0, a.cfg.Security.TwoFactorRememberDays),
+	}
+	if err := a.db.DB.Create(&grant).Error; err != nil {
+		return "", err
+	}
+	return grant.Key, nil
+}
+
+// validateRememberToken checks that the token was issued to the user for the device and did not expire.
+func (a *API) validateRememberToken(user *database.User, deviceIdentifier, token string) (bool, error) {
+	var grant database.Grant
+	err := a.db.DB.Where("Key = ? AND type = ? AND subject_id = ?", token, database.GrantTypeTwoFactorRemember,
+		strconv.FormatUint(user.Id, 10)).First(&grant).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if grant.IsExpired() {
+		return false, a.db.DB.Delete(&grant).Error
+	}
+	return grant.Data == deviceIdentifier, nil
+}
+
+// forgetRememberedDevices removes all remember tokens of the user, every device has to pass the second factor
+// again.
+func forgetRememberedDevices(db *gorm.DB, user *database.User) error {
+	return db.Where("subject_id = ? AND type = ?", strconv.FormatUint(user.Id, 10), database.GrantTypeTwoFactorRemember).
+		Delete(&database.Grant{}).Error
+}
+
+// validateTwoFactorToken checks the token the user entered for the given provider.
+func (a *API) validateTwoFactorToken(user *database.User, providerType int, token string) (bool, error) {
+	switch providerType {
+	case database.TwoFactorProviderAuthenticator:
+		return a.validateAuthenticatorToken(user, token)
+	case database.TwoFactorProviderEmail:
+		return a.validateEmailToken(user, token)
+	case database.TwoFactorProviderWebAuthn:
+		return a.validateWebAuthnToken(user, token)
+	default:
+		return false, nil
+	}
+}
+
+// respondTwoFactorChallenge tells the client that a second factor is required. Some providers need additional
+// data to show the login prompt.
+func (a *API) respondTwoFactorChallenge(w http.ResponseWriter, user *database.User, enabled []int) {
+	challenge := bw.TwoFactorChallengeModel{
+		Error:               "invalid_grant",
+		ErrorDescription:    "Two factor required.",
+		TwoFactorProviders:  enabled,
+		TwoFactorProviders2: make(map[string]interface{}, len(enabled)),
+	}
+	for _, providerType := range enabled {
+		data, err := a.twoFactorChallengeData(user, providerType)
+		if err != nil {
+			log.Errorf("Login failed, unable to create two-factor challenge for %s: %s", user.Email, err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		challenge.TwoFactorProviders2[strconv.Itoa(providerType)] = data
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(&challenge)
+}
+
+// twoFactorChallengeData returns the data the client needs to show the login prompt of the provider.
+func (a *API) twoFactorChallengeData(user *database.User, providerType int) (interface{}, error) {
+	switch providerType {
+	case database.TwoFactorProviderEmail:
+		provider := user.GetTwoFactorProviders()[providerType]
+		return map[string]string{"Email": obfuscateEmail(getEmailMetaData(&provider).Email)}, nil
+	case database.TwoFactorProviderWebAuthn:
+		return a.webAuthnLoginChallenge(user)
+	default:
+		return nil, nil
+	}
+}
+
+// respondTwoFactorError rejects a login because of an invalid second factor.
+func respondTwoFactorError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(&bw.TwoFactorChallengeModel{
+		Error:            "invalid_grant",
+		ErrorDescription: "invalid_username_or_password",
+		ErrorModel:       &bw.ErrorModel{Message: message, Object: "error"},
+	})
+}
+
+// storeTwoFactorProviders persists changed two-factor settings of the user. Remembered devices have to pass
+// the second factor again.
+func (a *API) storeTwoFactorProviders(user *database.User, providers map[int]database.TwoFactorProvider) error {
+	if err := user.SetTwoFactorProviders(providers); err != nil {
+		return err
+	}
+	user.RevisionDate = time.Now()
+
+	return a.db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"two_factor_providers": user.TwoFactorProviders,
+			"revision_date":        user.RevisionDate,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return forgetRememberedDevices(tx, user)
+	})
+}
+
+// updateTwoFactorProviders stores changed two-factor providers only if no other request modified them in the
+// meantime. It returns false in that case, which protects one-time codes against concurrent use.
+func (a *API) updateTwoFactorProviders(user *database.User, providers map[int]database.TwoFactorProvider) (bool, error) {
+	oldProviders := user.TwoFactorProviders
+	if err := user.SetTwoFactorProviders(providers); err != nil {
+		return false, err
+	}
+
+	result := a.db.DB.Model(&database.User{}).
+		Where("id = ? AND two_factor_providers = ?", user.Id, oldProviders).
+		UpdateColumn("two_factor_providers", user.TwoFactorProviders)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// newRecoveryCode generates a random recovery code of 32 characters.
+func newRecoveryCode() (string, error) {
+	code := make([]byte, 20)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(code), nil
+}
+
+// containsInt returns true if the value is part of the list.
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
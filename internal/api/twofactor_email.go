@@ -0,0 +1,296 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+const (
+	emailCodeExpiry      = 10 * time.Minute
+	emailCodeMaxAttempts = 5
+)
+
+// emailMetaData is stored with the email provider of a user. Only a hash of the pending code is stored.
+type emailMetaData struct {
+	Email          string `json:"Email"`
+	Code           string `json:"Code,omitempty"`
+	CodeEmail      string `json:"CodeEmail,omitempty"` // address the pending code was sent to
+	CodeExpiration int64  `json:"CodeExpiration,omitempty"`
+	CodeAttempts   int    `json:"CodeAttempts,omitempty"`
+}
+
+// TwoFactorGetEmail returns the email provider settings of the authenticated user.
+func (a *API) TwoFactorGetEmail(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("get email, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("get email decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	provider := user.GetTwoFactorProviders()[database.TwoFactorProviderEmail]
+	metaData := getEmailMetaData(&provider)
+	response := bw.TwoFactorEmailResponseModel{Enabled: provider.Enabled, Email: metaData.Email, Object: "twoFactorEmail"}
+	if !provider.Enabled {
+		response.Email = user.Email
+	}
+
+	MustRespondJSON(w, &response)
+}
+
+// TwoFactorSendEmail sends a code to the address that should be used for the email provider. The code has to be
+// sent back to TwoFactorEmail to enable the provider.
+func (a *API) TwoFactorSendEmail(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("send email, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.TwoFactorEmailRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("send email decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(requestData.Email)
+	if !strings.Contains(email, "@") {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.sendEmailCode(user, email); err != nil {
+		log.Errorf("send email, failed to send code: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// TwoFactorSendEmailLogin sends a login code to the address of the enabled email provider. The client calls it
+// before the user is logged in, so the request contains the credentials.
+func (a *API) TwoFactorSendEmailLogin(w http.ResponseWriter, req *http.Request) {
+	var requestData bw.TwoFactorEmailRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("send email login decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user database.User
+	if a.db.DB.Where("email = ?", requestData.Email).First(&user).RecordNotFound() ||
+		validateCredentials(&user, requestData.MasterPasswordHash) != nil {
+		log.Errorf("send email login, invalid credentials: %s", requestData.Email)
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid credentials", http.StatusBadRequest)
+		return
+	}
+
+	provider, ok := user.GetTwoFactorProviders()[database.TwoFactorProviderEmail]
+	if !ok || !provider.Enabled {
+		http.Error(w, "email two-step login is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.sendEmailCode(&user, getEmailMetaData(&provider).Email); err != nil {
+		log.Errorf("send email login, failed to send code: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// TwoFactorEmail enables the email provider after the user entered the code sent by TwoFactorSendEmail.
+func (a *API) TwoFactorEmail(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("email, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.TwoFactorEmailRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("email decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(requestData.Email)
+	providers := user.GetTwoFactorProviders()
+	provider := providers[database.TwoFactorProviderEmail]
+	metaData := getEmailMetaData(&provider)
+	valid := consumeEmailCode(&metaData, email, requestData.Token, time.Now())
+	if valid {
+		provider.Enabled = true
+		metaData.Email = email
+	}
+	if provider.MetaData, err = json.Marshal(&metaData); err != nil {
+		log.Errorf("email, failed to encode meta data: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	providers[database.TwoFactorProviderEmail] = provider
+
+	stored, err := a.updateTwoFactorProviders(user, providers)
+	if err != nil {
+		log.Errorf("email, failed to store providers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !valid || !stored {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+	if err := forgetRememberedDevices(a.db.DB, user); err != nil {
+		log.Errorf("email, failed to forget remembered devices: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	MustRespondJSON(w, &bw.TwoFactorEmailResponseModel{Enabled: true, Email: email, Object: "twoFactorEmail"})
+}
+
+// validateEmailToken checks a login code sent by email. Failed attempts are counted, a code can only be
+// used once.
+func (a *API) validateEmailToken(user *database.User, token string) (bool, error) {
+	providers := user.GetTwoFactorProviders()
+	provider, ok := providers[database.TwoFactorProviderEmail]
+	if !ok || !provider.Enabled {
+		return false, nil
+	}
+
+	metaData := getEmailMetaData(&provider)
+	valid := consumeEmailCode(&metaData, metaData.Email, token, time.Now())
+
+	var err error
+	if provider.MetaData, err = json.Marshal(&metaData); err != nil {
+		return false, err
+	}
+	providers[database.TwoFactorProviderEmail] = provider
+
+	stored, err := a.updateTwoFactorProviders(user, providers)
+	return valid && stored, err
+}
+
+// sendEmailCode generates a new code for the address and sends it. A previously sent code becomes invalid.
+func (a *API) sendEmailCode(user *database.User, email string) error {
+	code, err := newEmailCode()
+	if err != nil {
+		return err
+	}
+
+	providers := user.GetTwoFactorProviders()
+	provider := providers[database.TwoFactorProviderEmail]
+	metaData := getEmailMetaData(&provider)
+	metaData.Code = hashEmailCode(code)
+	metaData.CodeEmail = email
+	metaData.CodeExpiration = time.Now().Add(emailCodeExpiry).Unix()
+	metaData.CodeAttempts = 0
+	if provider.MetaData, err = json.Marshal(&metaData); err != nil {
+		return err
+	}
+	providers[database.TwoFactorProviderEmail] = provider
+	stored, err := a.updateTwoFactorProviders(user, providers)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return errors.New("two-factor providers were modified concurrently")
+	}
+
+	body := strings.NewReplacer(
+		"{Code}", code,
+		"{Minutes}", strconv.Itoa(int(emailCodeExpiry/time.Minute)),
+	).Replace(bw.EmailTwoFactorCode)
+	return bw.SendEmail(a.cfg, "Your Two-step Login Verification Code", body, email)
+}
+
+// newEmailCode generates a random code of six digits.
+func newEmailCode() (string, error) {
+	number, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", number.Int64()), nil
+}
+
+// consumeEmailCode checks the code against the pending code for the address. The pending code is removed on
+// success, after it expired or after too many failed attempts.
+func consumeEmailCode(metaData *emailMetaData, email, code string, now time.Time) bool {
+	if metaData.Code == "" || !strings.EqualFold(metaData.CodeEmail, email) {
+		return false
+	}
+
+	valid := now.Unix() <= metaData.CodeExpiration && metaData.CodeAttempts < emailCodeMaxAttempts &&
+		subtle.ConstantTimeCompare([]byte(hashEmailCode(strings.TrimSpace(code))), []byte(metaData.Code)) == 1
+
+	metaData.CodeAttempts++
+	if valid || now.Unix() > metaData.CodeExpiration || metaData.CodeAttempts >= emailCodeMaxAttempts {
+		metaData.Code = ""
+		metaData.CodeEmail = ""
+		metaData.CodeExpiration = 0
+		metaData.CodeAttempts = 0
+	}
+	return valid
+}
+
+// getEmailMetaData decodes the meta data of the email provider.
+func getEmailMetaData(provider *database.TwoFactorProvider) emailMetaData {
+	var metaData emailMetaData
+	if len(provider.MetaData) > 0 {
+		json.Unmarshal(provider.MetaData, &metaData)
+	}
+	return metaData
+}
+
+// hashEmailCode hashes a code before it is stored.
+func hashEmailCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// obfuscateEmail hides most of the address, the login prompt only shows a hint where the code was sent to.
+func obfuscateEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return email
+	}
+	return email[:1] + strings.Repeat("*", at-1) + email[at:]
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+// startSMTPSink starts a minimal SMTP server that accepts every message, and configures the API to use it.
+// The bodies of the received messages are delivered on the returned channel.
+func startSMTPSink(t *testing.T, api *API) <-chan string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	api.cfg.Email.Host = "127.0.0.1"
+	api.cfg.Email.Port = listener.Addr().(*net.TCPAddr).Port
+	api.cfg.Email.TLS = false
+	api.cfg.Email.Username = ""
+	api.cfg.Email.FromAddress = "vault@test.com"
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+
+	return messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ESMTP\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(command, "DATA"):
+			conn.Write([]byte("354 go ahead\r\n"))
+			var message strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				message.WriteString(dataLine)
+			}
+			messages <- message.String()
+			conn.Write([]byte("250 ok\r\n"))
+		case strings.HasPrefix(command, "QUIT"):
+			conn.Write([]byte("221 bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("250 ok\r\n"))
+		}
+	}
+}
+
+var emailCodePattern = regexp.MustCompile(`code is: (\d{6})`)
+
+func receiveEmailCode(t *testing.T, messages <-chan string) string {
+	select {
+	case message := <-messages:
+		match := emailCodePattern.FindStringSubmatch(message)
+		if match == nil {
+			t.Fatalf("message does not contain a code: %s", message)
+		}
+		return match[1]
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+	return ""
+}
+
+func TestEmailTwoFactorLogin(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	// Setup the provider
+	req, _ := http.NewRequest("POST", "/api/two-factor/send-email", strings.NewReader(
+		`{"masterPasswordHash":"notarealhash","email":"second@test.com"}`))
+	rr := serveAuthenticated(t, api, user, api.TwoFactorSendEmail, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	code := receiveEmailCode(t, messages)
+
+	req, _ = http.NewRequest("PUT", "/api/two-factor/email", strings.NewReader(
+		`{"masterPasswordHash":"notarealhash","email":"second@test.com","token":"`+code+`"}`))
+	rr = serveAuthenticated(t, api, user, api.TwoFactorEmail, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", status, http.StatusOK, rr.Body.String())
+	}
+
+	// The login asks for the code and shows where it is sent to
+	rr = passwordLogin(t, api, "")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"1":{"Email":"s*****@test.com"}`) {
+		t.Fatalf("login did not ask for the second factor: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	sendLoginCode := func() string {
+		req, _ := http.NewRequest("POST", "/api/two-factor/send-email-login", strings.NewReader(
+			`{"masterPasswordHash":"notarealhash","email":"test@test.com"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.TwoFactorSendEmailLogin).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		return receiveEmailCode(t, messages)
+	}
+
+	// Too many wrong guesses invalidate the code
+	code = sendLoginCode()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < emailCodeMaxAttempts; i++ {
+		consumeAttempt(t, api, user.Id, wrong)
+	}
+	if rr = passwordLogin(t, api, "&twoFactorProvider=1&twoFactorToken="+code); rr.Code != http.StatusBadRequest {
+		t.Errorf("code was accepted after too many attempts: got %v", rr.Code)
+	}
+
+	// A fresh code works exactly once
+	code = sendLoginCode()
+	if rr = passwordLogin(t, api, "&twoFactorProvider=1&twoFactorToken="+code); rr.Code != http.StatusOK {
+		t.Fatalf("login with a valid code failed: got %v %s", rr.Code, rr.Body.String())
+	}
+	if rr = passwordLogin(t, api, "&twoFactorProvider=1&twoFactorToken="+code); rr.Code != http.StatusBadRequest {
+		t.Errorf("code was accepted twice: got %v", rr.Code)
+	}
+}
+
+// consumeAttempt checks a wrong code directly, without the delay of the login handler.
+func consumeAttempt(t *testing.T, api *API, userId uint64, code string) {
+	var user database.User
+	if err := api.db.DB.First(&user, userId).Error; err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := api.validateEmailToken(&user, code); err != nil || valid {
+		t.Fatalf("wrong code was accepted: %v %v", valid, err)
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+)
+
+func TestTwoFactorRecover(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	messages := startSMTPSink(t, api)
+
+	// Prepare DB, the authenticator is enabled
+	user := createUser(t, api.db.DB)
+	user.SetTwoFactorProviders(map[int]database.TwoFactorProvider{
+		database.TwoFactorProviderAuthenticator: {Enabled: true, MetaData: json.RawMessage(`{"Key":"JBSWY3DPEHPK3PXP"}`)},
+	})
+	api.db.DB.Model(user).UpdateColumn("two_factor_providers", user.TwoFactorProviders)
+
+	// Get the recovery code
+	req, _ := http.NewRequest("POST", "/api/two-factor/get-recover", strings.NewReader(`{"masterPasswordHash":"wrong"}`))
+	if rr := serveAuthenticated(t, api, user, api.TwoFactorGetRecover, req); rr.Code != http.StatusBadRequest {
+		t.Errorf("recovery code was shown without the master password: got %v", rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/two-factor/get-recover", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+	rr := serveAuthenticated(t, api, user, api.TwoFactorGetRecover, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var recoverResponse common.TwoFactorRecoverResponseModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &recoverResponse); err != nil {
+		t.Fatal(err)
+	}
+	if len(recoverResponse.Code) != 32 {
+		t.Fatalf("handler returned unexpected recovery code: got %v", recoverResponse.Code)
+	}
+
+	recover := func(code string) int {
+		req, _ := http.NewRequest("POST", "/api/two-factor/recover", strings.NewReader(
+			`{"email":"test@test.com","masterPasswordHash":"notarealhash","recoveryCode":"`+code+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(api.TwoFactorRecover).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Wrong code
+	if status := recover(strings.Repeat("A", 32)); status != http.StatusBadRequest {
+		t.Errorf("wrong recovery code was accepted: got %v", status)
+	}
+
+	// Correct code, lower case and with spaces
+	if status := recover(strings.ToLower(recoverResponse.Code[:16] + " " + recoverResponse.Code[16:])); status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var storedUser database.User
+	api.db.DB.First(&storedUser, user.Id)
+	if len(storedUser.EnabledTwoFactorProviders()) != 0 {
+		t.Errorf("two-factor providers were not disabled: got %v", storedUser.TwoFactorProviders)
+	}
+	if storedUser.SecurityStamp == user.SecurityStamp {
+		t.Errorf("security stamp was not rotated")
+	}
+	if storedUser.TwoFactorRecoveryCode == recoverResponse.Code {
+		t.Errorf("recovery code was not replaced")
+	}
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "Two-step login has been turned off") {
+			t.Errorf("unexpected notification: %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no notification was sent")
+	}
+
+	// The code can only be used once
+	if status := recover(recoverResponse.Code); status != http.StatusBadRequest {
+		t.Errorf("recovery code was accepted twice: got %v", status)
+	}
+}
+
+func TestTwoFactorRemember(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+
+	// Prepare DB, the authenticator is enabled
+	user := createUser(t, api.db.DB)
+	user.SetTwoFactorProviders(map[int]database.TwoFactorProvider{
+		database.TwoFactorProviderAuthenticator: {Enabled: true, MetaData: json.RawMessage(`{"Key":"JBSWY3DPEHPK3PXP"}`)},
+	})
+	api.db.DB.Model(user).UpdateColumn("two_factor_providers", user.TwoFactorProviders)
+	secret, _ := decodeTotpKey("JBSWY3DPEHPK3PXP")
+	code := totpCode(secret, time.Now().Unix()/totpPeriod)
+
+	// Remember the device after a successful second factor
+	rr := passwordLogin(t, api, "&twoFactorProvider=0&twoFactorRemember=1&twoFactorToken="+code)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("login with a valid code failed: got %v %s", status, rr.Body.String())
+	}
+	var token common.TokenModel
+	if err := json.Unmarshal(rr.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.TwoFactorToken == "" {
+		t.Fatal("no remember token was issued")
+	}
+
+	rememberLogin := func() int {
+		return passwordLogin(t, api, "&twoFactorProvider=5&twoFactorToken="+url.QueryEscape(token.TwoFactorToken)).Code
+	}
+	if status := rememberLogin(); status != http.StatusOK {
+		t.Fatalf("remembered device had to pass the second factor: got %v", status)
+	}
+
+	// The remember token is no refresh token
+	req, _ := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(
+		"grant_type=refresh_token&client_id=browser&scope=api offline_access&deviceType=3&deviceIdentifier=sample-device"+
+			"&deviceName=firefox&refresh_token="+url.QueryEscape(token.TwoFactorToken)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(api.AuthToken).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("remember token was accepted as refresh token: got %v", status)
+	}
+
+	// The token is tied to the device
+	api.db.DB.Model(&database.Grant{}).Where("type = ?", database.GrantTypeTwoFactorRemember).UpdateColumn("data", "other-device")
+	if status := rememberLogin(); status != http.StatusBadRequest {
+		t.Errorf("remember token of another device was accepted: got %v", status)
+	}
+	api.db.DB.Model(&database.Grant{}).Where("type = ?", database.GrantTypeTwoFactorRemember).UpdateColumn("data", "sample-device")
+
+	// Changing the two-factor settings forgets all devices
+	req, _ = http.NewRequest("PUT", "/api/two-factor/disable", strings.NewReader(`{"masterPasswordHash":"notarealhash","type":1}`))
+	if rr := serveAuthenticated(t, api, user, api.TwoFactorDisable, req); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if status := rememberLogin(); status != http.StatusBadRequest {
+		t.Errorf("device was still remembered: got %v", status)
+	}
+}
@@ -0,0 +1,435 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+	"github.com/h44z/bitwarden-go/internal/database"
+	"github.com/h44z/bitwarden-go/internal/webauthn"
+)
+
+const (
+	webAuthnMaxKeys         = 5
+	webAuthnChallengeExpiry = 5 * time.Minute
+
+	// Pending challenges are stored in the U2f table, the version distinguishes registrations and logins
+	webAuthnRegistration = "webauthn.create"
+	webAuthnLogin        = "webauthn.get"
+)
+
+// webAuthnKey is a security key registered for the WebAuthn provider. The meta data of the provider maps the
+// key slots 1 to 5 to the keys.
+type webAuthnKey struct {
+	Name         string `json:"Name"`
+	CredentialId string `json:"CredentialId"` // base64url encoded
+	PublicKey    string `json:"PublicKey"`    // base64url encoded COSE key
+	Counter      uint32 `json:"Counter"`
+}
+
+// TwoFactorGetWebAuthn returns the security keys of the authenticated user.
+func (a *API) TwoFactorGetWebAuthn(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("get webauthn, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("get webauthn decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	provider := user.GetTwoFactorProviders()[database.TwoFactorProviderWebAuthn]
+	MustRespondJSON(w, webAuthnResponseFromProvider(&provider))
+}
+
+// TwoFactorGetWebAuthnChallenge starts the registration of a new security key.
+func (a *API) TwoFactorGetWebAuthnChallenge(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("get webauthn challenge, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.SecretVerificationRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("get webauthn challenge decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	rp, err := a.webAuthnRelyingParty()
+	if err != nil {
+		log.Errorf("get webauthn challenge failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	challenge, err := a.createWebAuthnChallenge(user, webAuthnRegistration, rp)
+	if err != nil {
+		log.Errorf("get webauthn challenge, failed to store challenge: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	provider := user.GetTwoFactorProviders()[database.TwoFactorProviderWebAuthn]
+	MustRespondJSON(w, &bw.WebAuthnCreateOptionsModel{
+		Rp: bw.WebAuthnRelyingPartyModel{Id: rp.ID, Name: "Bitwarden"},
+		User: bw.WebAuthnUserModel{
+			Id:          base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(user.Id, 10))),
+			Name:        user.Email,
+			DisplayName: user.Name,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []bw.WebAuthnCredentialParameterModel{
+			{Type: "public-key", Alg: webauthn.AlgES256},
+			{Type: "public-key", Alg: webauthn.AlgRS256},
+			{Type: "public-key", Alg: webauthn.AlgEdDSA},
+		},
+		Timeout:                int(webAuthnChallengeExpiry / time.Millisecond),
+		Attestation:            "none",
+		AuthenticatorSelection: bw.WebAuthnAuthenticatorSelectionModel{UserVerification: "discouraged"},
+		ExcludeCredentials:     webAuthnDescriptors(getWebAuthnKeys(&provider)),
+		Status:                 "ok",
+	})
+}
+
+// TwoFactorWebAuthn finishes the registration of a security key and stores it in the requested key slot.
+func (a *API) TwoFactorWebAuthn(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("webauthn, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.TwoFactorWebAuthnRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("webauthn decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(requestData.Name)
+	if requestData.Id < 1 || requestData.Id > webAuthnMaxKeys || name == "" || len(name) > 50 {
+		http.Error(w, "invalid key id or name", http.StatusBadRequest)
+		return
+	}
+
+	rp, err := a.webAuthnRelyingParty()
+	if err != nil {
+		log.Errorf("webauthn failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientDataJSON, errClientData := base64.RawURLEncoding.DecodeString(strings.TrimRight(requestData.DeviceResponse.Response.ClientDataJson, "="))
+	attestationObject, errAttestation := base64.RawURLEncoding.DecodeString(strings.TrimRight(requestData.DeviceResponse.Response.AttestationObject, "="))
+	if errClientData != nil || errAttestation != nil {
+		http.Error(w, "invalid device response", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := a.consumeWebAuthnChallenge(user, webAuthnRegistration, clientDataJSON)
+	if err != nil {
+		log.Errorf("webauthn, failed to load challenge: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if challenge == nil {
+		http.Error(w, "invalid or expired challenge", http.StatusBadRequest)
+		return
+	}
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		log.Errorf("webauthn, invalid registration of %s: %s", user.Email, err.Error())
+		http.Error(w, "invalid device response", http.StatusBadRequest)
+		return
+	}
+
+	providers := user.GetTwoFactorProviders()
+	provider := providers[database.TwoFactorProviderWebAuthn]
+	keys := getWebAuthnKeys(&provider)
+	credentialId := base64.RawURLEncoding.EncodeToString(credential.ID)
+	for id, key := range keys {
+		if id != requestData.Id && key.CredentialId == credentialId {
+			http.Error(w, "security key is already registered", http.StatusBadRequest)
+			return
+		}
+	}
+	keys[requestData.Id] = webAuthnKey{
+		Name:         name,
+		CredentialId: credentialId,
+		PublicKey:    base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		Counter:      credential.Counter,
+	}
+
+	if err := a.storeWebAuthnKeys(user, providers, keys); err != nil {
+		log.Errorf("webauthn, failed to store providers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	provider = providers[database.TwoFactorProviderWebAuthn]
+	MustRespondJSON(w, webAuthnResponseFromProvider(&provider))
+}
+
+// TwoFactorDeleteWebAuthn removes a security key. The provider is disabled with the last key.
+func (a *API) TwoFactorDeleteWebAuthn(w http.ResponseWriter, req *http.Request) {
+	user, err := a.getUserFromRequest(req)
+	if err != nil {
+		log.Errorf("delete webauthn, unable to load user: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var requestData bw.TwoFactorWebAuthnDeleteRequestModel
+	if err := json.NewDecoder(req.Body).Decode(&requestData); err != nil {
+		log.Errorf("delete webauthn decoding failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(user, requestData.MasterPasswordHash); err != nil {
+		time.Sleep(2 * time.Second) // delay response to avoid brute force attacks
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	providers := user.GetTwoFactorProviders()
+	provider := providers[database.TwoFactorProviderWebAuthn]
+	keys := getWebAuthnKeys(&provider)
+	if _, ok := keys[requestData.Id]; !ok {
+		http.Error(w, "invalid key id", http.StatusBadRequest)
+		return
+	}
+	delete(keys, requestData.Id)
+
+	if err := a.storeWebAuthnKeys(user, providers, keys); err != nil {
+		log.Errorf("delete webauthn, failed to store providers: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	provider = providers[database.TwoFactorProviderWebAuthn]
+	MustRespondJSON(w, webAuthnResponseFromProvider(&provider))
+}
+
+// webAuthnLoginChallenge creates the challenge a security key has to sign during the login.
+func (a *API) webAuthnLoginChallenge(user *database.User) (*bw.WebAuthnAssertionOptionsModel, error) {
+	rp, err := a.webAuthnRelyingParty()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := a.createWebAuthnChallenge(user, webAuthnLogin, rp)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := user.GetTwoFactorProviders()[database.TwoFactorProviderWebAuthn]
+	return &bw.WebAuthnAssertionOptionsModel{
+		Challenge:        challenge,
+		Timeout:          int(webAuthnChallengeExpiry / time.Millisecond),
+		RpId:             rp.ID,
+		AllowCredentials: webAuthnDescriptors(getWebAuthnKeys(&provider)),
+		UserVerification: "discouraged",
+		Status:           "ok",
+	}, nil
+}
+
+// validateWebAuthnToken checks the assertion of a security key. The challenge can only be used once and the
+// sign counter of the key has to increase, the update only succeeds if no other login used the key meanwhile.
+func (a *API) validateWebAuthnToken(user *database.User, token string) (bool, error) {
+	providers := user.GetTwoFactorProviders()
+	provider, ok := providers[database.TwoFactorProviderWebAuthn]
+	if !ok || !provider.Enabled {
+		return false, nil
+	}
+
+	var assertion bw.WebAuthnAssertionResponseModel
+	if err := json.Unmarshal([]byte(token), &assertion); err != nil {
+		return false, nil
+	}
+	clientDataJSON, errClientData := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion.Response.ClientDataJson, "="))
+	authData, errAuthData := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion.Response.AuthenticatorData, "="))
+	signature, errSignature := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion.Response.Signature, "="))
+	if errClientData != nil || errAuthData != nil || errSignature != nil {
+		return false, nil
+	}
+
+	credentialId := assertion.RawId
+	if credentialId == "" {
+		credentialId = assertion.Id
+	}
+	keys := getWebAuthnKeys(&provider)
+	keyId := 0
+	for id, key := range keys {
+		if key.CredentialId == strings.TrimRight(credentialId, "=") {
+			keyId = id
+		}
+	}
+	if keyId == 0 {
+		return false, nil
+	}
+	key := keys[keyId]
+	publicKey, err := base64.RawURLEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		return false, err
+	}
+
+	rp, err := a.webAuthnRelyingParty()
+	if err != nil {
+		return false, err
+	}
+	challenge, err := a.consumeWebAuthnChallenge(user, webAuthnLogin, clientDataJSON)
+	if err != nil || challenge == nil {
+		return false, err
+	}
+	counter, err := rp.VerifyAssertion(challenge, &webauthn.Credential{PublicKey: publicKey, Counter: key.Counter},
+		clientDataJSON, authData, signature)
+	if err != nil {
+		log.Errorf("webauthn, invalid assertion of %s: %s", user.Email, err.Error())
+		return false, nil
+	}
+
+	key.Counter = counter
+	keys[keyId] = key
+	if provider.MetaData, err = json.Marshal(keys); err != nil {
+		return false, err
+	}
+	providers[database.TwoFactorProviderWebAuthn] = provider
+
+	return a.updateTwoFactorProviders(user, providers)
+}
+
+// webAuthnRelyingParty returns the relying party of the security keys. Security keys are bound to the domain
+// of the web vault, so the vault url has to be configured.
+func (a *API) webAuthnRelyingParty() (*webauthn.RelyingParty, error) {
+	vaultURL, err := url.Parse(a.cfg.Core.VaultURL)
+	if err != nil || vaultURL.Scheme == "" || vaultURL.Hostname() == "" {
+		return nil, errors.New("security keys require the vault url to be configured")
+	}
+	return &webauthn.RelyingParty{ID: vaultURL.Hostname(), Origin: vaultURL.Scheme + "://" + vaultURL.Host}, nil
+}
+
+// createWebAuthnChallenge stores a new pending challenge of the user. Expired challenges are removed.
+func (a *API) createWebAuthnChallenge(user *database.User, version string, rp *webauthn.RelyingParty) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.db.DB.Where("user_id = ? AND creation_date < ?", user.Id, time.Now().Add(-webAuthnChallengeExpiry)).
+		Delete(&database.U2f{}).Error; err != nil {
+		return "", err
+	}
+	pending := database.U2f{
+		UserId:       user.Id,
+		Challenge:    base64.RawURLEncoding.EncodeToString(challenge),
+		AppId:        rp.ID,
+		Version:      version,
+		CreationDate: time.Now(),
+	}
+	if err := a.db.DB.Create(&pending).Error; err != nil {
+		return "", err
+	}
+	return pending.Challenge, nil
+}
+
+// consumeWebAuthnChallenge removes the pending challenge the client signed. It returns nil if the challenge
+// does not exist, expired or was used concurrently.
+func (a *API) consumeWebAuthnChallenge(user *database.User, version string, clientDataJSON []byte) ([]byte, error) {
+	challenge, err := webauthn.ClientDataChallenge(clientDataJSON)
+	if err != nil {
+		return nil, nil
+	}
+
+	result := a.db.DB.
+		Where("user_id = ? AND version = ? AND challenge = ? AND creation_date >= ?", user.Id, version,
+			base64.RawURLEncoding.EncodeToString(challenge), time.Now().Add(-webAuthnChallengeExpiry)).
+		Delete(&database.U2f{})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, result.Error
+	}
+	return challenge, nil
+}
+
+// storeWebAuthnKeys persists the security keys of the user, the provider is enabled as long as a key exists.
+func (a *API) storeWebAuthnKeys(user *database.User, providers map[int]database.TwoFactorProvider, keys map[int]webAuthnKey) error {
+	if len(keys) == 0 {
+		delete(providers, database.TwoFactorProviderWebAuthn)
+		return a.storeTwoFactorProviders(user, providers)
+	}
+
+	metaData, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	providers[database.TwoFactorProviderWebAuthn] = database.TwoFactorProvider{Enabled: true, MetaData: metaData}
+	return a.storeTwoFactorProviders(user, providers)
+}
+
+// getWebAuthnKeys decodes the security keys stored in the meta data of the provider.
+func getWebAuthnKeys(provider *database.TwoFactorProvider) map[int]webAuthnKey {
+	keys := make(map[int]webAuthnKey)
+	if len(provider.MetaData) > 0 {
+		json.Unmarshal(provider.MetaData, &keys)
+	}
+	return keys
+}
+
+// webAuthnDescriptors lists the credential ids of the keys, ordered by key slot.
+func webAuthnDescriptors(keys map[int]webAuthnKey) []bw.WebAuthnCredentialDescriptorModel {
+	ids := make([]int, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	descriptors := make([]bw.WebAuthnCredentialDescriptorModel, len(ids))
+	for i, id := range ids {
+		descriptors[i] = bw.WebAuthnCredentialDescriptorModel{Type: "public-key", Id: keys[id].CredentialId}
+	}
+	return descriptors
+}
+
+func webAuthnResponseFromProvider(provider *database.TwoFactorProvider) *bw.TwoFactorWebAuthnResponseModel {
+	keys := getWebAuthnKeys(provider)
+	response := bw.TwoFactorWebAuthnResponseModel{
+		Enabled: provider.Enabled,
+		Keys:    make([]bw.TwoFactorWebAuthnKeyModel, 0, len(keys)),
+		Object:  "twoFactorWebAuthn",
+	}
+	for id, key := range keys {
+		response.Keys = append(response.Keys, bw.TwoFactorWebAuthnKeyModel{Name: key.Name, Id: id})
+	}
+	sort.Slice(response.Keys, func(i, j int) bool { return response.Keys[i].Id < response.Keys[j].Id })
+
+	return &response
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/h44z/bitwarden-go/internal/common"
+)
+
+// softKey is a software authenticator which signs WebAuthn challenges with a P-256 key.
+type softKey struct {
+	id      []byte
+	key     *ecdsa.PrivateKey
+	counter uint32
+	origin  string
+}
+
+func newSoftKey(t *testing.T, origin string) *softKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softKey{id: id, key: key, origin: origin}
+}
+
+// encodeCBOR encodes the subset of CBOR the authenticator needs: integers, byte and text strings and maps.
+func encodeCBOR(value interface{}) []byte {
+	header := func(major byte, length int) []byte {
+		if length < 24 {
+			return []byte{major<<5 | byte(length)}
+		}
+		buf := make([]byte, 3)
+		buf[0] = major<<5 | 25
+		binary.BigEndian.PutUint16(buf[1:], uint16(length))
+		return buf
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return header(1, -1-v)
+		}
+		return header(0, v)
+	case []byte:
+		return append(header(2, len(v)), v...)
+	case string:
+		return append(header(3, len(v)), v...)
+	case [][2]interface{}: // map with ordered entries
+		out := header(5, len(v))
+		for _, entry := range v {
+			out = append(out, encodeCBOR(entry[0])...)
+			out = append(out, encodeCBOR(entry[1])...)
+		}
+		return out
+	}
+	panic("unsupported cbor value")
+}
+
+func (k *softKey) authData(withCredential bool) []byte {
+	u, _ := url.Parse(k.origin)
+	rpIdHash := sha256.Sum256([]byte(u.Hostname()))
+	data := append([]byte(nil), rpIdHash[:]...)
+	flags := byte(0x01)
+	if withCredential {
+		flags |= 0x40
+	}
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], k.counter)
+
+	if withCredential {
+		data = append(data, make([]byte, 16)...) // aaguid
+		data = append(data, byte(len(k.id)>>8), byte(len(k.id)))
+		data = append(data, k.id...)
+		x, y := k.key.X.Bytes(), k.key.Y.Bytes()
+		x = append(make([]byte, 32-len(x)), x...)
+		y = append(make([]byte, 32-len(y)), y...)
+		data = append(data, encodeCBOR([][2]interface{}{{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {-3, y}})...)
+	}
+	return data
+}
+
+func (k *softKey) clientData(ceremony, challenge string) []byte {
+	clientData, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": k.origin})
+	return clientData
+}
+
+// register creates the device response of a registration.
+func (k *softKey) register(challenge string) common.WebAuthnAttestationResponseModel {
+	var response common.WebAuthnAttestationResponseModel
+	response.Id = base64.RawURLEncoding.EncodeToString(k.id)
+	response.RawId = response.Id
+	response.Type = "public-key"
+	response.Response.ClientDataJson = base64.RawURLEncoding.EncodeToString(k.clientData("webauthn.create", challenge))
+	response.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(encodeCBOR([][2]interface{}{
+		{"fmt", "none"}, {"attStmt", [][2]interface{}{}}, {"authData", k.authData(true)},
+	}))
+	return response
+}
+
+// assert creates the login token that signs the challenge.
+func (k *softKey) assert(t *testing.T, challenge string) string {
+	authData := k.authData(false)
+	clientData := k.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	hash := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+
+	var response common.WebAuthnAssertionResponseModel
+	response.Id = base64.RawURLEncoding.EncodeToString(k.id)
+	response.RawId = response.Id
+	response.Type = "public-key"
+	response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	response.Response.ClientDataJson = base64.RawURLEncoding.EncodeToString(clientData)
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	token, _ := json.Marshal(&response)
+	return string(token)
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	// Setup the API
+	api := setup(t)
+	api.cfg.Core.VaultURL = "https://vault.test.com"
+
+	// Prepare DB
+	user := createUser(t, api.db.DB)
+
+	getChallenge := func() string {
+		req, _ := http.NewRequest("POST", "/api/two-factor/get-webauthn-challenge", strings.NewReader(`{"masterPasswordHash":"notarealhash"}`))
+		rr := serveAuthenticated(t, api, user, api.TwoFactorGetWebAuthnChallenge, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var options common.WebAuthnCreateOptionsModel
+		if err := json.Unmarshal(rr.Body.Bytes(), &options); err != nil {
+			t.Fatal(err)
+		}
+		if options.Rp.Id != "vault.test.com" || options.Challenge == "" {
+			t.Fatalf("handler returned unexpected options: got %v", options)
+		}
+		return options.Challenge
+	}
+	register := func(id int, name string, deviceResponse common.WebAuthnAttestationResponseModel) int {
+		body, _ := json.Marshal(&common.TwoFactorWebAuthnRequestModel{
+			MasterPasswordHash: "notarealhash", Id: id, Name: name, DeviceResponse: deviceResponse,
+		})
+		req, _ := http.NewRequest("PUT", "/api/two-factor/webauthn", strings.NewReader(string(body)))
+		return serveAuthenticated(t, api, user, api.TwoFactorWebAuthn, req).Code
+	}
+
+	// Register two keys, a challenge can only be used once
+	first := newSoftKey(t, "https://vault.test.com")
+	second := newSoftKey(t, "https://vault.test.com")
+	challenge := getChallenge()
+	if status := register(1, "First key", first.register(challenge)); status != http.StatusOK {
+		t.Fatalf("registration failed: got %v", status)
+	}
+	if status := register(2, "Second key", second.register(challenge)); status != http.StatusBadRequest {
+		t.Errorf("challenge was accepted twice: got %v", status)
+	}
+	phishing := newSoftKey(t, "https://vault.evil.com")
+	if status := register(2, "Phishing key", phishing.register(getChallenge())); status != http.StatusBadRequest {
+		t.Errorf("key of another origin was accepted: got %v", status)
+	}
+	if status := register(2, "Second key", second.register(getChallenge())); status != http.StatusOK {
+		t.Fatalf("registration failed: got %v", status)
+	}
+
+	// The login asks for one of the keys
+	loginChallenge := func() common.WebAuthnAssertionOptionsModel {
+		rr := passwordLogin(t, api, "")
+		var challenge common.TwoFactorChallengeModel
+		if err := json.Unmarshal(rr.Body.Bytes(), &challenge); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(challenge.TwoFactorProviders2["7"])
+		var options common.WebAuthnAssertionOptionsModel
+		json.Unmarshal(data, &options)
+		if rr.Code != http.StatusBadRequest || len(options.AllowCredentials) != 2 || options.Challenge == "" {
+			t.Fatalf("login did not ask for a security key: got %v %s", rr.Code, rr.Body.String())
+		}
+		return options
+	}
+
+	first.counter = 5
+	if rr := passwordLogin(t, api, "&twoFactorProvider=7&twoFactorToken="+url.QueryEscape(first.assert(t, loginChallenge().Challenge))); rr.Code != http.StatusOK {
+		t.Fatalf("login with a valid key failed: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// A cloned key does not increase the counter
+	if rr := passwordLogin(t, api, "&twoFactorProvider=7&twoFactorToken="+url.QueryEscape(first.assert(t, loginChallenge().Challenge))); rr.Code != http.StatusBadRequest {
+		t.Errorf("sign counter was not checked: got %v", rr.Code)
+	}
+
+	// The signature has to match the key
+	token := first.assert(t, loginChallenge().Challenge)
+	token = strings.Replace(token, base64.RawURLEncoding.EncodeToString(first.id), base64.RawURLEncoding.EncodeToString(second.id), -1)
+	if rr := passwordLogin(t, api, "&twoFactorProvider=7&twoFactorToken="+url.QueryEscape(token)); rr.Code != http.StatusBadRequest {
+		t.Errorf("signature of another key was accepted: got %v", rr.Code)
+	}
+
+	second.counter = 1
+	if rr := passwordLogin(t, api, "&twoFactorProvider=7&twoFactorToken="+url.QueryEscape(second.assert(t, loginChallenge().Challenge))); rr.Code != http.StatusOK {
+		t.Fatalf("login with the second key failed: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// Removing both keys disables the provider
+	for _, id := range []string{"1", "2"} {
+		req, _ := http.NewRequest("DELETE", "/api/two-factor/webauthn", strings.NewReader(`{"masterPasswordHash":"notarealhash","id":`+id+`}`))
+		if rr := serveAuthenticated(t, api, user, api.TwoFactorDeleteWebAuthn, req); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	if rr := passwordLogin(t, api, ""); rr.Code != http.StatusOK {
+		t.Errorf("login still requires a security key: got %v", rr.Code)
+	}
+}
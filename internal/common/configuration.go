@@ -13,6 +13,9 @@ const (
 	DatabaseTypeMocked = "mocked"
 	DatabaseTypeMySQL  = "mysql"
 	DatabaseTypeSQLite = "sqlite"
+
+	StorageTypeLocal = "local"
+	StorageTypeS3    = "s3"
 )
 
 type Configuration struct {
@@ -21,6 +24,13 @@ type Configuration struct {
 		Port                int    `yaml:"port" envconfig:"CORE_PORT"`
 		DisableRegistration bool   `yaml:"disable_registration" envconfig:"CORE_DISABLE_REGISTRATION"`
 		VaultURL            string `yaml:"vault_url" envconfig:"CORE_VAULT_URL"`
+
+		RequireEmailVerificationLogin     bool `yaml:"require_email_verification_login" envconfig:"CORE_REQUIRE_EMAIL_VERIFICATION_LOGIN"`
+		RequireEmailVerificationOrgInvite bool `yaml:"require_email_verification_org_invite" envconfig:"CORE_REQUIRE_EMAIL_VERIFICATION_ORG_INVITE"`
+		DeleteUnverifiedAfterDays         int  `yaml:"delete_unverified_after_days" envconfig:"CORE_DELETE_UNVERIFIED_AFTER_DAYS"` // 0 keeps unverified accounts
+		ShowPasswordHint                  bool `yaml:"show_password_hint" envconfig:"CORE_SHOW_PASSWORD_HINT"`                     // only without email server
+
+		TrustedProxies []string `yaml:"trusted_proxies" envconfig:"CORE_TRUSTED_PROXIES"` // addresses or networks of reverse proxies
 	} `yaml:"core"`
 	Database struct {
 		Type     string `yaml:"type" envconfig:"DATABASE_TYPE"`         // either 'sqlite' or 'mysql'
@@ -31,9 +41,19 @@ type Configuration struct {
 		Username string `yaml:"user" envconfig:"DATABASE_USERNAME"`
 		Password string `yaml:"pass" envconfig:"DATABASE_PASSWORD"`
 	} `yaml:"database"`
+	Storage struct {
+		Type      string `yaml:"type" envconfig:"STORAGE_TYPE"`         // either 'local' or 's3'
+		Location  string `yaml:"location" envconfig:"STORAGE_LOCATION"` // local
+		Endpoint  string `yaml:"endpoint" envconfig:"STORAGE_ENDPOINT"` // s3, for example https://s3.amazonaws.com
+		Region    string `yaml:"region" envconfig:"STORAGE_REGION"`
+		Bucket    string `yaml:"bucket" envconfig:"STORAGE_BUCKET"`
+		AccessKey string `yaml:"access_key" envconfig:"STORAGE_ACCESS_KEY"`
+		SecretKey string `yaml:"secret_key" envconfig:"STORAGE_SECRET_KEY"`
+	} `yaml:"storage"`
 	Security struct {
-		SigningKey string `yaml:"signing_key" envconfig:"SECURITY_SIGNING_KEY"`
-		JWTExpire  int    `yaml:"jwt_expire" envconfig:"SECURITY_JWT_EXPIRE"`
+		SigningKey            string `yaml:"signing_key" envconfig:"SECURITY_SIGNING_KEY"`
+		JWTExpire             int    `yaml:"jwt_expire" envconfig:"SECURITY_JWT_EXPIRE"`
+		TwoFactorRememberDays int    `yaml:"two_factor_remember_days" envconfig:"SECURITY_TWO_FACTOR_REMEMBER_DAYS"`
 	} `yaml:"security"`
 	Email struct {
 		Host        string `yaml:"host" envconfig:"EMAIL_HOST"`
@@ -44,6 +64,10 @@ type Configuration struct {
 		FromAddress string `yaml:"from" envconfig:"EMAIL_FROM"`
 		FromName    string `yaml:"name" envconfig:"EMAIL_NAME"`
 	} `yaml:"email"`
+	Push struct {
+		RelayURL string `yaml:"relay_url" envconfig:"PUSH_RELAY_URL"` // empty disables push notifications
+		RelayKey string `yaml:"relay_key" envconfig:"PUSH_RELAY_KEY"`
+	} `yaml:"push"`
 }
 
 func setDefaultValues(cfg *Configuration) {
@@ -52,11 +76,23 @@ func setDefaultValues(cfg *Configuration) {
 	cfg.Core.DisableRegistration = false // Allow registration
 	cfg.Core.VaultURL = ""               // Empty vault URL
 
+	cfg.Core.RequireEmailVerificationLogin = false     // Allow login without a verified email address
+	cfg.Core.RequireEmailVerificationOrgInvite = false // Allow joining organizations without a verified email address
+	cfg.Core.DeleteUnverifiedAfterDays = 0             // Keep accounts with an unverified email address
+	cfg.Core.ShowPasswordHint = false                  // Only send password hints by email
+
+	cfg.Core.TrustedProxies = nil // Ignore the client addresses forwarded in request headers
+
 	cfg.Database.Type = DatabaseTypeSQLite // Use SQLite database
 	cfg.Database.Location = ""             // Store database in same directory as the executable
 
-	cfg.Security.SigningKey = "secret" // Signing key
-	cfg.Security.JWTExpire = 3600      // Amount of time (in seconds) the generated JSON Web Tokens will last before expiry.
+	cfg.Storage.Type = StorageTypeLocal  // Store attachments on the local disk
+	cfg.Storage.Location = "attachments" // Store attachments in a subdirectory of the working directory
+	cfg.Storage.Region = "us-east-1"     // Default S3 region
+
+	cfg.Security.SigningKey = "secret"      // Signing key
+	cfg.Security.JWTExpire = 3600           // Amount of time (in seconds) the generated JSON Web Tokens will last before expiry.
+	cfg.Security.TwoFactorRememberDays = 30 // Amount of days a remembered device can skip the second factor.
 }
 
 func readConfigFile(cfg *Configuration, filename string) error {
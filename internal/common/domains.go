@@ -0,0 +1,46 @@
+package common
+
+// GlobalEquivalentDomains contains the built-in equivalent domain groups known by the Bitwarden clients.
+// The key is the Bitwarden GlobalEquivalentDomainsType enum value.
+var GlobalEquivalentDomains = map[int][]string{
+	0:  {"youtube.com", "google.com", "gmail.com"},
+	1:  {"apple.com", "icloud.com"},
+	2:  {"ameritrade.com", "tdameritrade.com"},
+	3:  {"bankofamerica.com", "bofa.com", "mbna.com", "usecfo.com"},
+	4:  {"sprint.com", "sprintpcs.com", "nextel.com"},
+	5:  {"wellsfargo.com", "wf.com"},
+	6:  {"mymerrill.com", "ml.com", "merrilledge.com"},
+	7:  {"accountonline.com", "citi.com", "citibank.com", "citicards.com", "citibankonline.com"},
+	8:  {"cnet.com", "cnettv.com", "com.com", "download.com", "news.com", "search.com", "upload.com"},
+	9:  {"bananarepublic.com", "gap.com", "oldnavy.com", "piperlime.com"},
+	10: {"bing.com", "hotmail.com", "live.com", "microsoft.com", "msn.com", "passport.net", "windows.com", "microsoftonline.com", "office.com", "office365.com", "microsoftstore.com", "xbox.com", "azure.com", "windowsazure.com"},
+	11: {"ua2go.com", "ual.com", "united.com", "unitedwifi.com"},
+	12: {"overture.com", "yahoo.com"},
+	13: {"zonealarm.com", "zonelabs.com"},
+	14: {"paypal.com", "paypal-search.com"},
+	15: {"avon.com", "youravon.com"},
+	16: {"diapers.com", "soap.com", "wag.com", "yoyo.com", "beautybar.com", "casa.com", "afterschool.com", "vine.com", "bookworm.com", "look.com", "vinemarket.com"},
+	17: {"1800contacts.com", "800contacts.com"},
+	18: {"amazon.com", "amazon.ae", "amazon.ca", "amazon.co.uk", "amazon.com.au", "amazon.com.br", "amazon.com.mx", "amazon.com.tr", "amazon.de", "amazon.es", "amazon.fr", "amazon.in", "amazon.it", "amazon.nl", "amazon.sa", "amazon.se", "amazon.sg"},
+	19: {"cox.com", "cox.net", "coxbusiness.com"},
+	20: {"mynortonaccount.com", "norton.com"},
+	21: {"verizon.com", "verizon.net"},
+	22: {"rakuten.com", "buy.com"},
+	23: {"siriusxm.com", "sirius.com"},
+	24: {"ea.com", "origin.com", "play4free.com", "tiberiumalliance.com"},
+	25: {"37signals.com", "basecamp.com", "basecamphq.com", "highrisehq.com"},
+	26: {"steampowered.com", "steamcommunity.com", "steamgames.com"},
+	27: {"chart.io", "chartio.com"},
+	28: {"gotomeeting.com", "citrixonline.com"},
+	29: {"gogoair.com", "gogoinflight.com"},
+	30: {"mysql.com", "oracle.com"},
+	31: {"discover.com", "discovercard.com"},
+	32: {"dcu.org", "dcu-online.org"},
+	33: {"healthcare.gov", "cuidadodesalud.gov", "cms.gov"},
+	34: {"pepco.com", "pepcoholdings.com"},
+	35: {"century21.com", "21online.com"},
+	36: {"comcast.com", "comcast.net", "xfinity.com"},
+	37: {"cricketwireless.com", "aiowireless.com"},
+	38: {"mandtbank.com", "mtb.com"},
+	39: {"dropbox.com", "getdropbox.com"},
+}
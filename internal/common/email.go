@@ -139,4 +139,66 @@ const (
 		"Stuck without any of your devices? Using a friend's computer? You can access your Bitwarden vault from any web enabled device by using the web vault.\n" +
 		"{WebVaultUrl}/?utm_source=welcome_email&utm_medium=email\n\n\n" +
 		"If you have any questions or problems you can get support at: https://github.com/h44z/bitwarden-go\n\nThank you!\nThe Bitwarden-GO Team"
+
+	EmailOrganizationInvite = "You have been invited to join the {OrganizationName} organization. To accept this invite, click the following link:\n\n" +
+		"{AcceptUrl}\n\n" +
+		"This link expires in 5 days. If you do not have a Bitwarden account yet, you can create one with this email address.\n\n" +
+		"If you have any questions or problems you can get support at: https://github.com/h44z/bitwarden-go\n\nThank you!\nThe Bitwarden-GO Team"
+
+	EmailTwoFactorCode = "Your two-step verification code is: {Code}\n\n" +
+		"Use this code to complete logging in with Bitwarden-GO. The code expires in {Minutes} minutes.\n\n" +
+		"If you did not try to log in, someone knows your master password. Please change it immediately.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailTwoFactorRecovered = "Two-step login has been turned off for your account with your recovery code.\n\n" +
+		"All two-step login providers were disabled and all sessions were logged out. A new recovery code was generated, " +
+		"you can find it in the settings of the web vault.\n\n" +
+		"If you did not do this, someone knows your master password and your recovery code. Please change your master password immediately.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailMasterPasswordChanged = "The master password of your account was changed.\n\n" +
+		"All sessions were logged out, log in again with your new master password on all of your devices.\n\n" +
+		"If you did not do this, please contact the administrator of this server immediately.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailPasswordHint = "You (or someone) recently requested your master password hint.\n\n" +
+		"Your hint is: \"{Hint}\"\n\n" +
+		"If you did not request your master password hint you can safely ignore this email.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailPasswordHintMissing = "You (or someone) recently requested your master password hint. " +
+		"Unfortunately, your account does not have a master password hint.\n\n" +
+		"If you cannot log in to your account, contact the administrator of this server.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailDeleteRecover = "You (or someone) requested to delete your account. To delete your account and all of its data, " +
+		"click the following link:\n\n" +
+		"{DeleteUrl}\n\n" +
+		"This link expires in {Hours} hours. Deleted accounts cannot be restored. " +
+		"If you did not request to delete your account, you can safely ignore this email.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailAccountDeleted = "Your account and all of its data were deleted.\n\n" +
+		"If you did not do this, please contact the administrator of this server immediately.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailVerifyAddress = "Please confirm the email address of your account by clicking the following link:\n\n" +
+		"{VerifyUrl}\n\n" +
+		"This link expires in {Hours} hours. If you did not create an account, you can ignore this email.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailChangeCode = "Your email change verification code is: {Code}\n\n" +
+		"Use this code to confirm this address as the new email address of your account. The code expires in {Minutes} minutes.\n\n" +
+		"If you did not try to change your email address, you can ignore this email.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailChangeAddressTaken = "Someone tried to change the email address of an account to this address. " +
+		"This address already belongs to an account, nothing was changed.\n\n" +
+		"If you did not try to change your email address, you can ignore this email.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
+
+	EmailAddressChanged = "The email address of your account was changed, this address is no longer used to log in.\n\n" +
+		"All sessions were logged out, log in again with your new email address on all of your devices.\n\n" +
+		"If you did not do this, please contact the administrator of this server immediately.\n\n" +
+		"Thank you!\nThe Bitwarden-GO Team"
 )
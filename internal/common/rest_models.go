@@ -1,5 +1,14 @@
 package common
 
+import "time"
+
+const (
+	CipherTypeLogin      = 1
+	CipherTypeSecureNote = 2
+	CipherTypeCard       = 3
+	CipherTypeIdentity   = 4
+)
+
 type KeyPair struct {
 	EncryptedPrivateKey string `json:"encryptedPrivateKey"`
 	PublicKey           string `json:"publicKey"`
@@ -16,14 +25,587 @@ type RegisterModel struct {
 	OrganizationUserId uint64  `json:"organizationUserId"`
 	Kdf                int     `json:"kdf"`
 	KdfIterations      int     `json:"kdfIterations"`
+	KdfMemory          *int    `json:"kdfMemory"`
+	KdfParallelism     *int    `json:"kdfParallelism"`
 }
 
 type TokenModel struct {
-	ClientId     string `json:"client_id"`
-	AccessToken  string `json:"access_token"`
-	ExpiresIn    int    `json:"expires_in"`
-	TokenType    string `json:"token_type"`
-	RefreshToken string `json:"refresh_token"`
-	Key          string `json:"Key"`
-	PrivateKey   string `json:"PrivateKey,omitempty"`
+	ClientId       string `json:"client_id"`
+	AccessToken    string `json:"access_token"`
+	ExpiresIn      int    `json:"expires_in"`
+	TokenType      string `json:"token_type"`
+	RefreshToken   string `json:"refresh_token"`
+	Key            string `json:"Key"`
+	PrivateKey     string `json:"PrivateKey,omitempty"`
+	TwoFactorToken string `json:"TwoFactorToken,omitempty"`
+	Kdf            int    `json:"Kdf"`
+	KdfIterations  int    `json:"KdfIterations"`
+	KdfMemory      *int   `json:"KdfMemory"`
+	KdfParallelism *int   `json:"KdfParallelism"`
+}
+
+type ProfileResponseModel struct {
+	Id                 uint64                             `json:"id,string"`
+	Name               string                             `json:"name"`
+	Email              string                             `json:"email"`
+	EmailVerified      bool                               `json:"emailVerified"`
+	Premium            bool                               `json:"premium"`
+	MasterPasswordHint string                             `json:"masterPasswordHint"`
+	Culture            string                             `json:"culture"`
+	TwoFactorEnabled   bool                               `json:"twoFactorEnabled"`
+	Key                string                             `json:"key"`
+	PrivateKey         string                             `json:"privateKey"`
+	SecurityStamp      string                             `json:"securityStamp"`
+	Organizations      []ProfileOrganizationResponseModel `json:"organizations"`
+	Object             string                             `json:"object"`
+}
+
+type FolderResponseModel struct {
+	Id           uint64    `json:"id,string"`
+	Name         string    `json:"name"`
+	RevisionDate time.Time `json:"revisionDate"`
+	Object       string    `json:"object"`
+}
+
+type CipherFieldModel struct {
+	Type  int     `json:"type"`
+	Name  *string `json:"name"`
+	Value *string `json:"value"`
+}
+
+type CipherPasswordHistoryModel struct {
+	Password     string    `json:"password"`
+	LastUsedDate time.Time `json:"lastUsedDate"`
+}
+
+type CipherLoginUriModel struct {
+	Uri   *string `json:"uri"`
+	Match *int    `json:"match"`
+}
+
+type CipherLoginModel struct {
+	Uris                 []CipherLoginUriModel `json:"uris"`
+	Username             *string               `json:"username"`
+	Password             *string               `json:"password"`
+	PasswordRevisionDate *time.Time            `json:"passwordRevisionDate"`
+	Totp                 *string               `json:"totp"`
+}
+
+type CipherCardModel struct {
+	CardholderName *string `json:"cardholderName"`
+	Brand          *string `json:"brand"`
+	Number         *string `json:"number"`
+	ExpMonth       *string `json:"expMonth"`
+	ExpYear        *string `json:"expYear"`
+	Code           *string `json:"code"`
+}
+
+type CipherIdentityModel struct {
+	Title          *string `json:"title"`
+	FirstName      *string `json:"firstName"`
+	MiddleName     *string `json:"middleName"`
+	LastName       *string `json:"lastName"`
+	Address1       *string `json:"address1"`
+	Address2       *string `json:"address2"`
+	Address3       *string `json:"address3"`
+	City           *string `json:"city"`
+	State          *string `json:"state"`
+	PostalCode     *string `json:"postalCode"`
+	Country        *string `json:"country"`
+	Company        *string `json:"company"`
+	Email          *string `json:"email"`
+	Phone          *string `json:"phone"`
+	SSN            *string `json:"ssn"`
+	Username       *string `json:"username"`
+	PassportNumber *string `json:"passportNumber"`
+	LicenseNumber  *string `json:"licenseNumber"`
+}
+
+type CipherSecureNoteModel struct {
+	Type int `json:"type"`
+}
+
+// CipherData is the structure that gets stored in the JSON Data column of a cipher.
+type CipherData struct {
+	Name            string                       `json:"name"`
+	Notes           *string                      `json:"notes"`
+	Fields          []CipherFieldModel           `json:"fields"`
+	PasswordHistory []CipherPasswordHistoryModel `json:"passwordHistory"`
+	Login           *CipherLoginModel            `json:"login,omitempty"`
+	Card            *CipherCardModel             `json:"card,omitempty"`
+	Identity        *CipherIdentityModel         `json:"identity,omitempty"`
+	SecureNote      *CipherSecureNoteModel       `json:"secureNote,omitempty"`
+}
+
+type CipherResponseModel struct {
+	Id                  uint64                       `json:"id,string"`
+	OrganizationId      *uint64                      `json:"organizationId,string"`
+	FolderId            *uint64                      `json:"folderId,string"`
+	Type                int                          `json:"type"`
+	Name                string                       `json:"name"`
+	Notes               *string                      `json:"notes"`
+	Fields              []CipherFieldModel           `json:"fields"`
+	PasswordHistory     []CipherPasswordHistoryModel `json:"passwordHistory"`
+	Login               *CipherLoginModel            `json:"login"`
+	Card                *CipherCardModel             `json:"card"`
+	Identity            *CipherIdentityModel         `json:"identity"`
+	SecureNote          *CipherSecureNoteModel       `json:"secureNote"`
+	Attachments         []AttachmentResponseModel    `json:"attachments"`
+	Favorite            bool                         `json:"favorite"`
+	Edit                bool                         `json:"edit"`
+	ViewPassword        bool                         `json:"viewPassword"`
+	OrganizationUseTotp bool                         `json:"organizationUseTotp"`
+	CollectionIds       []string                     `json:"collectionIds"`
+	RevisionDate        time.Time                    `json:"revisionDate"`
+	Object              string                       `json:"object"`
+}
+
+type CollectionResponseModel struct {
+	Id             uint64 `json:"id,string"`
+	OrganizationId uint64 `json:"organizationId,string"`
+	Name           string `json:"name"`
+	ExternalId     string `json:"externalId"`
+	ReadOnly       bool   `json:"readOnly"`
+	HidePasswords  bool   `json:"hidePasswords"`
+	Object         string `json:"object"`
+}
+
+type GlobalDomainsResponseModel struct {
+	Type     int      `json:"type"`
+	Domains  []string `json:"domains"`
+	Excluded bool     `json:"excluded"`
+}
+
+type DomainsResponseModel struct {
+	EquivalentDomains       [][]string                   `json:"equivalentDomains"`
+	GlobalEquivalentDomains []GlobalDomainsResponseModel `json:"globalEquivalentDomains"`
+	Object                  string                       `json:"object"`
+}
+
+type PolicyResponseModel struct {
+	Id             uint64                 `json:"id,string"`
+	OrganizationId uint64                 `json:"organizationId,string"`
+	Type           int                    `json:"type"`
+	Data           map[string]interface{} `json:"data"`
+	Enabled        bool                   `json:"enabled"`
+	Object         string                 `json:"object"`
+}
+
+type SyncResponseModel struct {
+	Profile     ProfileResponseModel      `json:"profile"`
+	Folders     []FolderResponseModel     `json:"folders"`
+	Collections []CollectionResponseModel `json:"collections"`
+	Ciphers     []CipherResponseModel     `json:"ciphers"`
+	Domains     *DomainsResponseModel     `json:"domains"`
+	Policies    []PolicyResponseModel     `json:"policies"`
+	Object      string                    `json:"object"`
+}
+
+type ListResponseModel struct {
+	Data              interface{} `json:"data"`
+	ContinuationToken *string     `json:"continuationToken"`
+	Object            string      `json:"object"`
+}
+
+type FolderRequestModel struct {
+	Name string `json:"name"`
+}
+
+type FolderWithIdRequestModel struct {
+	Id   uint64 `json:"id,string"`
+	Name string `json:"name"`
+}
+
+type CipherRequestModel struct {
+	Type                  int                          `json:"type"`
+	OrganizationId        *uint64                      `json:"organizationId,string"`
+	FolderId              *uint64                      `json:"folderId,string"`
+	Favorite              bool                         `json:"favorite"`
+	Name                  string                       `json:"name"`
+	Notes                 *string                      `json:"notes"`
+	Fields                []CipherFieldModel           `json:"fields"`
+	PasswordHistory       []CipherPasswordHistoryModel `json:"passwordHistory"`
+	Login                 *CipherLoginModel            `json:"login"`
+	Card                  *CipherCardModel             `json:"card"`
+	Identity              *CipherIdentityModel         `json:"identity"`
+	SecureNote            *CipherSecureNoteModel       `json:"secureNote"`
+	LastKnownRevisionDate *time.Time                   `json:"lastKnownRevisionDate"`
+}
+
+type CipherAttachmentRequestModel struct {
+	FileName string `json:"fileName"`
+	Key      string `json:"key"`
+}
+
+type CipherWithIdRequestModel struct {
+	CipherRequestModel
+	Id           uint64                                  `json:"id,string"`
+	Attachments2 map[string]CipherAttachmentRequestModel `json:"attachments2"`
+}
+
+type CipherCreateRequestModel struct {
+	Cipher        CipherRequestModel `json:"cipher"`
+	CollectionIds []string           `json:"collectionIds"`
+}
+
+type KeyValuePairModel struct {
+	Key   int `json:"key"`
+	Value int `json:"value"`
+}
+
+type ImportCiphersRequestModel struct {
+	Ciphers             []CipherRequestModel `json:"ciphers"`
+	Folders             []FolderRequestModel `json:"folders"`
+	FolderRelationships []KeyValuePairModel  `json:"folderRelationships"`
+}
+
+type AttachmentResponseModel struct {
+	Id       string `json:"id"`
+	Url      string `json:"url"`
+	FileName string `json:"fileName"`
+	Key      string `json:"key"`
+	Size     string `json:"size"`
+	SizeName string `json:"sizeName"`
+	Object   string `json:"object"`
+}
+
+type ProfileOrganizationResponseModel struct {
+	Id              uint64  `json:"id,string"`
+	Name            string  `json:"name"`
+	UsePolicies     bool    `json:"usePolicies"`
+	UseGroups       bool    `json:"useGroups"`
+	UseDirectory    bool    `json:"useDirectory"`
+	UseEvents       bool    `json:"useEvents"`
+	UseTotp         bool    `json:"useTotp"`
+	Use2fa          bool    `json:"use2fa"`
+	UseApi          bool    `json:"useApi"`
+	SelfHost        bool    `json:"selfHost"`
+	UsersGetPremium bool    `json:"usersGetPremium"`
+	Seats           *int    `json:"seats"`
+	MaxCollections  *int    `json:"maxCollections"`
+	MaxStorageGb    int     `json:"maxStorageGb"`
+	Key             *string `json:"key"`
+	Status          int     `json:"status"`
+	Type            int     `json:"type"`
+	Enabled         bool    `json:"enabled"`
+	Object          string  `json:"object"`
+}
+
+type OrganizationKeysModel struct {
+	PublicKey           string `json:"publicKey"`
+	EncryptedPrivateKey string `json:"encryptedPrivateKey"`
+}
+
+type OrganizationCreateRequestModel struct {
+	Name         string                `json:"name"`
+	BillingEmail string                `json:"billingEmail"`
+	PlanType     int                   `json:"planType"`
+	Key          string                `json:"key"`
+	Keys         OrganizationKeysModel `json:"keys"`
+}
+
+type OrganizationResponseModel struct {
+	Id              uint64 `json:"id,string"`
+	Name            string `json:"name"`
+	BillingEmail    string `json:"billingEmail"`
+	PlanType        int    `json:"planType"`
+	Seats           *int   `json:"seats"`
+	MaxCollections  *int   `json:"maxCollections"`
+	MaxStorageGb    int    `json:"maxStorageGb"`
+	UsePolicies     bool   `json:"usePolicies"`
+	UseGroups       bool   `json:"useGroups"`
+	UseDirectory    bool   `json:"useDirectory"`
+	UseEvents       bool   `json:"useEvents"`
+	UseTotp         bool   `json:"useTotp"`
+	Use2fa          bool   `json:"use2fa"`
+	UseApi          bool   `json:"useApi"`
+	SelfHost        bool   `json:"selfHost"`
+	UsersGetPremium bool   `json:"usersGetPremium"`
+	Object          string `json:"object"`
+}
+
+type OrganizationKeysResponseModel struct {
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+	Object     string `json:"object"`
+}
+
+type OrganizationUserInviteRequestModel struct {
+	Emails    []string `json:"emails"`
+	Type      int      `json:"type"`
+	AccessAll bool     `json:"accessAll"`
+}
+
+type OrganizationUserAcceptRequestModel struct {
+	Token string `json:"token"`
+}
+
+type OrganizationUserConfirmRequestModel struct {
+	Key string `json:"key"`
+}
+
+type OrganizationUserResponseModel struct {
+	Id        uint64  `json:"id,string"`
+	UserId    *uint64 `json:"userId,string"`
+	Name      string  `json:"name"`
+	Email     string  `json:"email"`
+	Type      int     `json:"type"`
+	Status    int     `json:"status"`
+	AccessAll bool    `json:"accessAll"`
+	Object    string  `json:"object"`
+}
+
+type UserKeyResponseModel struct {
+	UserId    uint64 `json:"userId,string"`
+	PublicKey string `json:"publicKey"`
+	Object    string `json:"object"`
+}
+
+type SelectionReadOnlyModel struct {
+	Id            uint64 `json:"id,string"`
+	ReadOnly      bool   `json:"readOnly"`
+	HidePasswords bool   `json:"hidePasswords"`
+}
+
+type CollectionRequestModel struct {
+	Name       string                   `json:"name"`
+	ExternalId string                   `json:"externalId"`
+	Users      []SelectionReadOnlyModel `json:"users"` // nil keeps the current assignments
+}
+
+type CipherCollectionsRequestModel struct {
+	CollectionIds []string `json:"collectionIds"`
+}
+
+type ErrorModel struct {
+	Message string `json:"Message"`
+	Object  string `json:"Object"`
+}
+
+type TwoFactorChallengeModel struct {
+	Error               string                 `json:"error"`
+	ErrorDescription    string                 `json:"error_description"`
+	TwoFactorProviders  []int                  `json:"TwoFactorProviders,omitempty"`
+	TwoFactorProviders2 map[string]interface{} `json:"TwoFactorProviders2,omitempty"`
+	ErrorModel          *ErrorModel            `json:"ErrorModel,omitempty"`
+}
+
+type UpdateProfileRequestModel struct {
+	Name               string  `json:"name"`
+	MasterPasswordHint *string `json:"masterPasswordHint"`
+	Culture            string  `json:"culture"`
+}
+
+type UpdateKeyRequestModel struct {
+	MasterPasswordHash string                     `json:"masterPasswordHash"`
+	Key                string                     `json:"key"`
+	PrivateKey         string                     `json:"privateKey"`
+	Ciphers            []CipherWithIdRequestModel `json:"ciphers"`
+	Folders            []FolderWithIdRequestModel `json:"folders"`
+}
+
+type PasswordRequestModel struct {
+	MasterPasswordHash    string  `json:"masterPasswordHash"`
+	NewMasterPasswordHash string  `json:"newMasterPasswordHash"`
+	MasterPasswordHint    *string `json:"masterPasswordHint"`
+	Key                   string  `json:"key"`
+}
+
+type KdfRequestModel struct {
+	MasterPasswordHash    string `json:"masterPasswordHash"`
+	NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+	Key                   string `json:"key"`
+	Kdf                   int    `json:"kdf"`
+	KdfIterations         int    `json:"kdfIterations"`
+	KdfMemory             *int   `json:"kdfMemory"`
+	KdfParallelism        *int   `json:"kdfParallelism"`
+}
+
+type EmailTokenRequestModel struct {
+	NewEmail           string `json:"newEmail"`
+	MasterPasswordHash string `json:"masterPasswordHash"`
+}
+
+type EmailRequestModel struct {
+	NewEmail              string `json:"newEmail"`
+	MasterPasswordHash    string `json:"masterPasswordHash"`
+	NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+	Token                 string `json:"token"`
+	Key                   string `json:"key"`
+}
+
+type PasswordHintRequestModel struct {
+	Email string `json:"email"`
+}
+
+type DeleteRecoverRequestModel struct {
+	Email string `json:"email"`
+}
+
+type DeleteRecoverTokenRequestModel struct {
+	UserId uint64 `json:"userId"`
+	Token  string `json:"token"`
+}
+
+type VerifyEmailRequestModel struct {
+	UserId uint64 `json:"userId"`
+	Token  string `json:"token"`
+}
+
+type SecretVerificationRequestModel struct {
+	MasterPasswordHash string `json:"masterPasswordHash"`
+}
+
+type TwoFactorAuthenticatorRequestModel struct {
+	MasterPasswordHash string `json:"masterPasswordHash"`
+	Key                string `json:"key"`
+	Token              string `json:"token"`
+}
+
+type TwoFactorAuthenticatorResponseModel struct {
+	Enabled bool   `json:"enabled"`
+	Key     string `json:"key"`
+	Object  string `json:"object"`
+}
+
+type TwoFactorDisableRequestModel struct {
+	MasterPasswordHash string `json:"masterPasswordHash"`
+	Type               int    `json:"type"`
+}
+
+type TwoFactorProviderResponseModel struct {
+	Enabled bool   `json:"enabled"`
+	Type    int    `json:"type"`
+	Object  string `json:"object"`
+}
+
+type TwoFactorEmailRequestModel struct {
+	MasterPasswordHash string `json:"masterPasswordHash"`
+	Email              string `json:"email"`
+	Token              string `json:"token"`
+}
+
+type TwoFactorEmailResponseModel struct {
+	Enabled bool   `json:"enabled"`
+	Email   string `json:"email"`
+	Object  string `json:"object"`
+}
+
+type TwoFactorRecoverResponseModel struct {
+	Code   string `json:"code"`
+	Object string `json:"object"`
+}
+
+type TwoFactorRecoveryRequestModel struct {
+	Email              string `json:"email"`
+	MasterPasswordHash string `json:"masterPasswordHash"`
+	RecoveryCode       string `json:"recoveryCode"`
+}
+
+type TwoFactorWebAuthnKeyModel struct {
+	Name     string `json:"name"`
+	Id       int    `json:"id"`
+	Migrated bool   `json:"migrated"`
+}
+
+type TwoFactorWebAuthnResponseModel struct {
+	Enabled bool                        `json:"enabled"`
+	Keys    []TwoFactorWebAuthnKeyModel `json:"keys"`
+	Object  string                      `json:"object"`
+}
+
+type WebAuthnCredentialDescriptorModel struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+type WebAuthnCredentialParameterModel struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type WebAuthnRelyingPartyModel struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUserModel struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebAuthnAuthenticatorSelectionModel struct {
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type WebAuthnCreateOptionsModel struct {
+	Rp                     WebAuthnRelyingPartyModel           `json:"rp"`
+	User                   WebAuthnUserModel                   `json:"user"`
+	Challenge              string                              `json:"challenge"`
+	PubKeyCredParams       []WebAuthnCredentialParameterModel  `json:"pubKeyCredParams"`
+	Timeout                int                                 `json:"timeout"`
+	Attestation            string                              `json:"attestation"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelectionModel `json:"authenticatorSelection"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptorModel `json:"excludeCredentials"`
+	Status                 string                              `json:"status"`
+	ErrorMessage           string                              `json:"errorMessage"`
+}
+
+type WebAuthnAssertionOptionsModel struct {
+	Challenge        string                              `json:"challenge"`
+	Timeout          int                                 `json:"timeout"`
+	RpId             string                              `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptorModel `json:"allowCredentials"`
+	UserVerification string                              `json:"userVerification"`
+	Status           string                              `json:"status"`
+	ErrorMessage     string                              `json:"errorMessage"`
+}
+
+type WebAuthnAttestationResponseModel struct {
+	Id       string `json:"id"`
+	RawId    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		AttestationObject string `json:"attestationObject"`
+		ClientDataJson    string `json:"clientDataJson"`
+	} `json:"response"`
+}
+
+type WebAuthnAssertionResponseModel struct {
+	Id       string `json:"id"`
+	RawId    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		ClientDataJson    string `json:"clientDataJson"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+type TwoFactorWebAuthnRequestModel struct {
+	MasterPasswordHash string                           `json:"masterPasswordHash"`
+	Id                 int                              `json:"id"`
+	Name               string                           `json:"name"`
+	DeviceResponse     WebAuthnAttestationResponseModel `json:"deviceResponse"`
+}
+
+type TwoFactorWebAuthnDeleteRequestModel struct {
+	MasterPasswordHash string `json:"masterPasswordHash"`
+	Id                 int    `json:"id"`
+}
+
+type DeviceResponseModel struct {
+	Id           uint64    `json:"id,string"`
+	Name         string    `json:"name"`
+	Type         int       `json:"type"`
+	Identifier   string    `json:"identifier"`
+	CreationDate time.Time `json:"creationDate"`
+	Object       string    `json:"object"`
+}
+
+type DeviceTokenRequestModel struct {
+	PushToken string `json:"pushToken"`
 }
@@ -26,7 +26,7 @@ func New(cfg *bw.Configuration) *Wrapper {
 
 func (db *Wrapper) Initialize() error {
 	// Migrate the schema
-	db.DB.AutoMigrate(&User{}, &Folder{}, &Cipher{}, &Device{}, &U2f{}, &Grant{})
+	db.DB.AutoMigrate(&User{}, &Folder{}, &Cipher{}, &Organization{}, &OrganizationUser{}, &Collection{}, &CollectionUser{}, &CollectionCipher{}, &Device{}, &U2f{}, &Grant{})
 	return nil
 }
 
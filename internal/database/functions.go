@@ -1,19 +1,28 @@
 package database
 
 import (
+	"strconv"
 	"time"
 
+	"github.com/jinzhu/gorm"
+
 	"github.com/h44z/bitwarden-go/internal/common"
 )
 
 func (db *Wrapper) CreateUserFromRegistrationModel(model *common.RegisterModel) (*User, error) {
+	masterPassword, err := HashMasterPassword(model.MasterPasswordHash)
+	if err != nil {
+		return nil, err
+	}
+
 	currentTime := time.Now()
 	user := &User{
 		Name:                            model.Name,
 		Email:                           model.Email,
 		EmailVerified:                   false,
-		MasterPassword:                  model.MasterPasswordHash,
-		MasterPasswordHint:              truncateString(model.MasterPasswordHint, 50),
+		EmailVerificationPending:        db.Configuration.Core.DeleteUnverifiedAfterDays > 0,
+		MasterPassword:                  masterPassword,
+		MasterPasswordHint:              TruncateMasterPasswordHint(model.MasterPasswordHint),
 		Culture:                         "en-US",
 		SecurityStamp:                   "",
 		TwoFactorProviders:              "",
@@ -37,15 +46,22 @@ func (db *Wrapper) CreateUserFromRegistrationModel(model *common.RegisterModel)
 		RenewalReminderDate:             nil,
 		Kdf:                             model.Kdf,
 		KdfIterations:                   model.KdfIterations,
+		KdfMemory:                       model.KdfMemory,
+		KdfParallelism:                  model.KdfParallelism,
 		Folders:                         nil,
 		Ciphers:                         nil,
 	}
 
-	err := db.DB.Create(user).Error
+	err = db.DB.Create(user).Error
 
 	return user, err
 }
 
+// TruncateMasterPasswordHint shortens the hint to the size of its column.
+func TruncateMasterPasswordHint(hint string) string {
+	return truncateString(hint, 50)
+}
+
 func truncateString(str string, num int) string {
 	result := str
 	if len(str) > num {
@@ -56,3 +72,113 @@ func truncateString(str string, num int) string {
 	}
 	return result
 }
+
+// UpdateAccountRevisionDate marks the vault of the user as changed, clients use this date to decide if they need to sync.
+func UpdateAccountRevisionDate(tx *gorm.DB, userId uint64) error {
+	return tx.Model(&User{}).Where("id = ?", userId).UpdateColumn("account_revision_date", time.Now()).Error
+}
+
+// UpdateStorage adds delta bytes to the storage used by the attachments of the user.
+func UpdateStorage(tx *gorm.DB, userId uint64, delta int64) error {
+	return tx.Model(&User{}).Where("id = ?", userId).UpdateColumn("storage", gorm.Expr("storage + ?", delta)).Error
+}
+
+// UpdateOrganizationRevisionDate marks the vaults of all confirmed members of the organization as changed.
+func UpdateOrganizationRevisionDate(tx *gorm.DB, organizationId uint64) error {
+	members := tx.Model(&OrganizationUser{}).Select("user_id").
+		Where("organization_id = ? AND status = ?", organizationId, OrganizationUserStatusConfirmed).SubQuery()
+	return tx.Model(&User{}).Where("id IN ?", members).UpdateColumn("account_revision_date", time.Now()).Error
+}
+
+// UpdateCipherRevisionDate marks the vaults of all users that can access the cipher as changed.
+func UpdateCipherRevisionDate(tx *gorm.DB, cipher *Cipher) error {
+	if cipher.OrganizationId != nil {
+		return UpdateOrganizationRevisionDate(tx, *cipher.OrganizationId)
+	}
+	if cipher.UserId != nil {
+		return UpdateAccountRevisionDate(tx, *cipher.UserId)
+	}
+	return nil
+}
+
+// UpdateCipherStorage adds delta bytes to the storage used by the owner of the cipher.
+func UpdateCipherStorage(tx *gorm.DB, cipher *Cipher, delta int64) error {
+	if cipher.OrganizationId != nil {
+		return tx.Model(&Organization{}).Where("id = ?", *cipher.OrganizationId).
+			UpdateColumn("storage", gorm.Expr("storage + ?", delta)).Error
+	}
+	if cipher.UserId != nil {
+		return UpdateStorage(tx, *cipher.UserId, delta)
+	}
+	return nil
+}
+
+// ReserveCipherStorage adds size bytes to the storage used by the owner of the cipher. Nothing is changed and
+// false is returned if the quota of the owner would be exceeded.
+func ReserveCipherStorage(tx *gorm.DB, cipher *Cipher, size int64) (bool, error) {
+	var owner *gorm.DB
+	switch {
+	case cipher.OrganizationId != nil:
+		owner = tx.Model(&Organization{}).Where("id = ?", *cipher.OrganizationId)
+	case cipher.UserId != nil:
+		owner = tx.Model(&User{}).Where("id = ?", *cipher.UserId)
+	default:
+		return false, nil
+	}
+
+	result := owner.Where("storage + ? <= max_storage_gb * ?", size, int64(1024*1024*1024)).
+		UpdateColumn("storage", gorm.Expr("storage + ?", size))
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetCiphersWithUserSettings returns all ciphers that may store a folder or the favorite flag of the user,
+// regardless of whether the user can still access them. The JSON columns are matched textually, callers have to
+// check the decoded values.
+func GetCiphersWithUserSettings(tx *gorm.DB, userId uint64) ([]Cipher, error) {
+	pattern := "%\"" + strconv.FormatUint(userId, 10) + "\":%"
+	var ciphers []Cipher
+	err := tx.Where("folders LIKE ? OR favorites LIKE ?", pattern, pattern).Find(&ciphers).Error
+	return ciphers, err
+}
+
+// GetConfirmedMemberships returns all organization memberships of the user that were confirmed by an administrator.
+func (db *Wrapper) GetConfirmedMemberships(userId uint64) ([]OrganizationUser, error) {
+	var memberships []OrganizationUser
+	err := db.DB.Where("user_id = ? AND status = ?", userId, OrganizationUserStatusConfirmed).Find(&memberships).Error
+	return memberships, err
+}
+
+// GetCipherUserIds returns the ids of all users whose vault contains the cipher. Organization ciphers are
+// visible to the members with access to all collections and to the members of the given collections.
+func (db *Wrapper) GetCipherUserIds(cipher *Cipher, collectionIds []uint64) ([]uint64, error) {
+	if cipher.OrganizationId == nil {
+		if cipher.UserId != nil {
+			return []uint64{*cipher.UserId}, nil
+		}
+		return nil, nil
+	}
+
+	conditions := "access_all = ? OR type IN (?)"
+	values := []interface{}{true, []int{OrganizationUserTypeOwner, OrganizationUserTypeAdmin}}
+	if len(collectionIds) > 0 {
+		conditions += " OR id IN ?"
+		values = append(values, db.DB.Model(&CollectionUser{}).Select("organization_user_id").
+			Where("collection_id IN (?)", collectionIds).SubQuery())
+	}
+
+	var userIds []uint64
+	err := db.DB.Model(&OrganizationUser{}).
+		Where("organization_id = ? AND status = ?", *cipher.OrganizationId, OrganizationUserStatusConfirmed).
+		Where(conditions, values...).
+		Pluck("user_id", &userIds).Error
+	return userIds, err
+}
+
+// GetOrganizationUserIds returns the ids of all confirmed members of the organization.
+func (db *Wrapper) GetOrganizationUserIds(organizationId uint64) ([]uint64, error) {
+	var userIds []uint64
+	err := db.DB.Model(&OrganizationUser{}).
+		Where("organization_id = ? AND status = ?", organizationId, OrganizationUserStatusConfirmed).
+		Pluck("user_id", &userIds).Error
+	return userIds, err
+}
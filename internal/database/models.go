@@ -1,6 +1,9 @@
 package database
 
 import (
+	"encoding/json"
+	"sort"
+	"strconv"
 	"time"
 )
 
@@ -9,6 +12,7 @@ type User struct {
 	Name                            string `gorm:"type:varchar(50)"`
 	Email                           string `gorm:"type:varchar(50);unique_index;not null"`
 	EmailVerified                   bool   `gorm:"not null"`
+	EmailVerificationPending        bool   `gorm:"not null;default:false"` // registered while unverified accounts are deleted
 	MasterPassword                  string `gorm:"type:varchar(300)"`
 	MasterPasswordHint              string `gorm:"type:varchar(50)"`
 	Culture                         string `gorm:"type:varchar(10);not null"`
@@ -36,13 +40,21 @@ type User struct {
 	RevisionDate        time.Time
 	RenewalReminderDate *time.Time
 
-	Kdf           int
-	KdfIterations int
+	Kdf            int
+	KdfIterations  int
+	KdfMemory      *int // Argon2id only, in MiB
+	KdfParallelism *int // Argon2id only
 
 	Folders []Folder
 	Ciphers []Cipher
 }
 
+// Key derivation functions the clients apply to the master password
+const (
+	KdfTypePBKDF2   = 0
+	KdfTypeArgon2id = 1
+)
+
 type Folder struct {
 	Id     uint64 `gorm:"primary_key"`
 	UserId uint64
@@ -54,28 +66,104 @@ type Folder struct {
 }
 
 type Cipher struct {
-	Id     uint64 `gorm:"primary_key"`
-	UserId uint64
-	User   User `gorm:"foreignkey:UserId"` // Belongs to
-	//OrganizationId uint64
-	//Organization Organization
-	Type        int
-	Data        string `gorm:"type:varchar(65000)"` // JSON
-	Favorites   string `gorm:"type:varchar(65000)"` // JSON
-	Folders     string `gorm:"type:varchar(65000)"` // JSON
-	Attachments string `gorm:"type:varchar(65000)"` // JSON
+	Id             uint64 `gorm:"primary_key"`
+	UserId         *uint64
+	User           User `gorm:"foreignkey:UserId"` // Belongs to, personal ciphers only
+	OrganizationId *uint64
+	Organization   Organization `gorm:"foreignkey:OrganizationId"` // Belongs to, organization ciphers only
+	Type           int
+	Data           string `gorm:"type:varchar(65000)"` // JSON
+	Favorites      string `gorm:"type:varchar(65000)"` // JSON
+	Folders        string `gorm:"type:varchar(65000)"` // JSON
+	Attachments    string `gorm:"type:varchar(65000)"` // JSON
+
+	CreationDate time.Time
+	RevisionDate time.Time
+}
+
+type Organization struct {
+	Id           uint64 `gorm:"primary_key"`
+	Name         string `gorm:"type:varchar(50)"`
+	BillingEmail string `gorm:"type:varchar(50)"`
+	PlanType     int
+	UseTotp      bool `gorm:"not null"`
+	Enabled      bool `gorm:"not null"`
+	Storage      int64
+	MaxStorageGb int
+
+	PublicKey  string `gorm:"type:varchar(65000)"`
+	PrivateKey string `gorm:"type:varchar(65000)"` // encrypted with the organization key
+
+	CreationDate time.Time
+	RevisionDate time.Time
+
+	Users []OrganizationUser
+}
+
+const (
+	OrganizationUserStatusInvited   = 0
+	OrganizationUserStatusAccepted  = 1
+	OrganizationUserStatusConfirmed = 2
+
+	OrganizationUserTypeOwner   = 0
+	OrganizationUserTypeAdmin   = 1
+	OrganizationUserTypeUser    = 2
+	OrganizationUserTypeManager = 3
+)
+
+type OrganizationUser struct {
+	Id             uint64 `gorm:"primary_key"`
+	OrganizationId uint64
+	Organization   Organization `gorm:"foreignkey:OrganizationId"` // Belongs to
+	UserId         *uint64      // not set until the invitation was accepted
+	User           User         `gorm:"foreignkey:UserId"`   // Belongs to
+	Email          string       `gorm:"type:varchar(50)"`    // only used for invitations
+	Key            string       `gorm:"type:varchar(65000)"` // organization key, encrypted with the public key of the user
+	Status         int
+	Type           int
+	AccessAll      bool `gorm:"not null"`
 
 	CreationDate time.Time
 	RevisionDate time.Time
 }
 
+// IsAdmin returns true if the member may manage the organization.
+func (o *OrganizationUser) IsAdmin() bool {
+	return o.Type == OrganizationUserTypeOwner || o.Type == OrganizationUserTypeAdmin
+}
+
+type Collection struct {
+	Id             uint64 `gorm:"primary_key"`
+	OrganizationId uint64
+	Organization   Organization `gorm:"foreignkey:OrganizationId"` // Belongs to
+	Name           string       `gorm:"type:varchar(1000)"`        // encrypted with the organization key
+	ExternalId     string       `gorm:"type:varchar(300)"`
+
+	CreationDate time.Time
+	RevisionDate time.Time
+}
+
+// CollectionUser grants a member, that has no access to all collections, access to a single collection.
+type CollectionUser struct {
+	CollectionId       uint64 `gorm:"primary_key;auto_increment:false"`
+	OrganizationUserId uint64 `gorm:"primary_key;auto_increment:false"`
+	ReadOnly           bool   `gorm:"not null"`
+	HidePasswords      bool   `gorm:"not null"`
+}
+
+// CollectionCipher assigns an organization cipher to a collection.
+type CollectionCipher struct {
+	CollectionId uint64 `gorm:"primary_key;auto_increment:false"`
+	CipherId     uint64 `gorm:"primary_key;auto_increment:false"`
+}
+
 type Device struct {
 	Id         uint64 `gorm:"primary_key"`
-	UserId     uint64
+	UserId     uint64 `gorm:"unique_index:idx_device_user_identifier"`
 	User       User   `gorm:"foreignkey:UserId"` // Belongs to
 	Name       string `gorm:"type:varchar(50)"`
 	Type       int
-	Identifier string `gorm:"type:varchar(50)"`
+	Identifier string `gorm:"type:varchar(50);unique_index:idx_device_user_identifier"` // generated by the client
 	PushToken  string `gorm:"type:varchar(255)"`
 
 	CreationDate time.Time
@@ -94,11 +182,19 @@ type U2f struct {
 	CreationDate time.Time
 }
 
+// Grant types
+const (
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeTwoFactorRemember = "two_factor_remember" // Data contains the device identifier
+	GrantTypeEmailChange       = "email_change"        // Data contains the pending code for the new address
+)
+
 type Grant struct {
 	Key       string `gorm:"type:varchar(200);primary_key"`
 	Type      string `gorm:"type:varchar(50)"`
 	SubjectId string `gorm:"type:varchar(50)"`
 	ClientId  string `gorm:"type:varchar(200)"`
+	DeviceId  uint64 `gorm:"index"` // refresh tokens only
 	Data      string `gorm:"type:varchar(65000)"`
 
 	CreationDate   time.Time
@@ -108,3 +204,154 @@ type Grant struct {
 func (g *Grant) IsExpired() bool {
 	return g.ExpirationDate.Before(time.Now())
 }
+
+// GetFolderId returns the folder the cipher is stored in for the given user, or nil if there is none.
+func (c *Cipher) GetFolderId(userId uint64) *uint64 {
+	folders := make(map[string]uint64)
+	if c.Folders == "" || json.Unmarshal([]byte(c.Folders), &folders) != nil {
+		return nil
+	}
+
+	folderId, ok := folders[strconv.FormatUint(userId, 10)]
+	if !ok {
+		return nil
+	}
+	return &folderId
+}
+
+// SetFolderId updates the folder of the cipher for the given user. A nil folderId removes the folder.
+func (c *Cipher) SetFolderId(userId uint64, folderId *uint64) error {
+	folders := make(map[string]uint64)
+	if c.Folders != "" {
+		if err := json.Unmarshal([]byte(c.Folders), &folders); err != nil {
+			return err
+		}
+	}
+
+	if folderId == nil {
+		delete(folders, strconv.FormatUint(userId, 10))
+	} else {
+		folders[strconv.FormatUint(userId, 10)] = *folderId
+	}
+
+	foldersJSON, err := json.Marshal(folders)
+	if err != nil {
+		return err
+	}
+	c.Folders = string(foldersJSON)
+	return nil
+}
+
+// IsFavorite returns true if the given user marked the cipher as favorite.
+func (c *Cipher) IsFavorite(userId uint64) bool {
+	favorites := make(map[string]bool)
+	if c.Favorites == "" || json.Unmarshal([]byte(c.Favorites), &favorites) != nil {
+		return false
+	}
+
+	return favorites[strconv.FormatUint(userId, 10)]
+}
+
+// SetFavorite updates the favorite flag of the cipher for the given user.
+func (c *Cipher) SetFavorite(userId uint64, favorite bool) error {
+	favorites := make(map[string]bool)
+	if c.Favorites != "" {
+		if err := json.Unmarshal([]byte(c.Favorites), &favorites); err != nil {
+			return err
+		}
+	}
+
+	if favorite {
+		favorites[strconv.FormatUint(userId, 10)] = true
+	} else {
+		delete(favorites, strconv.FormatUint(userId, 10))
+	}
+
+	favoritesJSON, err := json.Marshal(favorites)
+	if err != nil {
+		return err
+	}
+	c.Favorites = string(favoritesJSON)
+	return nil
+}
+
+// Attachment contains the meta data of a file attached to a cipher. The file content is kept in the blob storage.
+type Attachment struct {
+	FileName string `json:"fileName"` // encrypted
+	Key      string `json:"key"`      // encrypted
+	Size     int64  `json:"size"`
+}
+
+// GetAttachments returns the attachments of the cipher, indexed by the attachment id.
+func (c *Cipher) GetAttachments() map[string]Attachment {
+	attachments := make(map[string]Attachment)
+	if c.Attachments == "" || json.Unmarshal([]byte(c.Attachments), &attachments) != nil {
+		return make(map[string]Attachment)
+	}
+
+	return attachments
+}
+
+// SetAttachments replaces the attachments of the cipher.
+func (c *Cipher) SetAttachments(attachments map[string]Attachment) error {
+	attachmentsJSON, err := json.Marshal(attachments)
+	if err != nil {
+		return err
+	}
+	c.Attachments = string(attachmentsJSON)
+	return nil
+}
+
+// AttachmentStorageName returns the name of the blob that holds the content of the attachment.
+func AttachmentStorageName(cipherId uint64, attachmentId string) string {
+	return strconv.FormatUint(cipherId, 10) + "/" + attachmentId
+}
+
+const (
+	TwoFactorProviderAuthenticator = 0
+	TwoFactorProviderEmail         = 1
+	TwoFactorProviderDuo           = 2
+	TwoFactorProviderYubiKey       = 3
+	TwoFactorProviderU2f           = 4
+	TwoFactorProviderRemember      = 5
+	TwoFactorProviderWebAuthn      = 7
+)
+
+// TwoFactorProvider contains the state of a single two-factor provider of a user. The meta data is specific
+// to the provider type.
+type TwoFactorProvider struct {
+	Enabled  bool            `json:"Enabled"`
+	MetaData json.RawMessage `json:"MetaData"`
+}
+
+// GetTwoFactorProviders returns the two-factor providers of the user, indexed by the provider type.
+func (u *User) GetTwoFactorProviders() map[int]TwoFactorProvider {
+	providers := make(map[int]TwoFactorProvider)
+	if u.TwoFactorProviders == "" || json.Unmarshal([]byte(u.TwoFactorProviders), &providers) != nil {
+		return make(map[int]TwoFactorProvider)
+	}
+
+	return providers
+}
+
+// SetTwoFactorProviders replaces the two-factor providers of the user.
+func (u *User) SetTwoFactorProviders(providers map[int]TwoFactorProvider) error {
+	providersJSON, err := json.Marshal(providers)
+	if err != nil {
+		return err
+	}
+	u.TwoFactorProviders = string(providersJSON)
+	return nil
+}
+
+// EnabledTwoFactorProviders returns the types of all enabled two-factor providers, in ascending order.
+func (u *User) EnabledTwoFactorProviders() []int {
+	var enabled []int
+	for providerType, provider := range u.GetTwoFactorProviders() {
+		if provider.Enabled {
+			enabled = append(enabled, providerType)
+		}
+	}
+	sort.Ints(enabled)
+	return enabled
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The master password hash sent by the clients is hashed again before it is stored, a leaked database does not
+// contain working login credentials. The stored value has the form pbkdf2_sha256$<iterations>$<salt>$<hash>.
+const (
+	masterPasswordScheme     = "pbkdf2_sha256"
+	masterPasswordIterations = 100000
+	masterPasswordSaltSize   = 16
+	masterPasswordKeySize    = 32
+)
+
+// HashMasterPassword derives the value stored for the master password hash sent by a client. Every call uses
+// a new random salt.
+func HashMasterPassword(password string) (string, error) {
+	salt := make([]byte, masterPasswordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, masterPasswordIterations, masterPasswordKeySize, sha256.New)
+
+	return masterPasswordScheme + "$" + strconv.Itoa(masterPasswordIterations) + "$" +
+		base64.StdEncoding.EncodeToString(salt) + "$" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// VerifyMasterPassword checks the master password hash sent by a client against the stored value. Rows written
+// before the server-side hash was introduced contain the hash of the client, they are compared directly.
+func VerifyMasterPassword(stored, password string) bool {
+	if !strings.HasPrefix(stored, masterPasswordScheme+"$") {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	derived := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha256.New)
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+// MasterPasswordNeedsRehash returns true if the stored value is not hashed with the current parameters. It is
+// replaced after the next successful login.
+func MasterPasswordNeedsRehash(stored string) bool {
+	return !strings.HasPrefix(stored, masterPasswordScheme+"$"+strconv.Itoa(masterPasswordIterations)+"$")
+}
@@ -0,0 +1,199 @@
+package notifications
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MessageType tells the clients what kind of data changed.
+type MessageType int
+
+// Message types known by the Bitwarden clients
+const (
+	SyncCipherUpdate MessageType = 0
+	SyncCipherCreate MessageType = 1
+	SyncLoginDelete  MessageType = 2
+	SyncFolderDelete MessageType = 3
+	SyncCiphers      MessageType = 4
+	SyncVault        MessageType = 5
+	SyncOrgKeys      MessageType = 6
+	SyncFolderCreate MessageType = 7
+	SyncFolderUpdate MessageType = 8
+	SyncCipherDelete MessageType = 9
+	SyncSettings     MessageType = 10
+	LogOut           MessageType = 11
+)
+
+// Message is a change notification. ContextId is the identifier of the device that made the change, the
+// client of that device ignores the message.
+type Message struct {
+	Type      MessageType
+	ContextId string
+	Payload   map[string]interface{}
+}
+
+const (
+	// sendBufferSize is the number of messages queued for a client. Clients that fall further behind are
+	// disconnected, they sync again when they reconnect.
+	sendBufferSize = 16
+	pingInterval   = 15 * time.Second
+	// readTimeout closes connections of clients that stopped sending their pings.
+	readTimeout = 60 * time.Second
+)
+
+// client is a single WebSocket connection of a user.
+type client struct {
+	conn      *websocketConn
+	protocol  protocol
+	send      chan []byte
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Hub fans out the notifications to all connected clients of the users. It is safe for concurrent use.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[uint64]map[*client]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[uint64]map[*client]struct{})}
+}
+
+// Serve takes over the connection of the request and registers it for the notifications of the user. The
+// connection is handled in the background, Serve returns as soon as the WebSocket handshake is done.
+func (h *Hub) Serve(w http.ResponseWriter, req *http.Request, userId uint64) error {
+	conn, err := upgradeWebSocket(w, req)
+	if err != nil {
+		return err
+	}
+
+	go h.run(conn, userId)
+	return nil
+}
+
+// Publish sends the message to all connected clients of the users.
+func (h *Hub) Publish(message Message, userIds ...uint64) {
+	var encoded [protocolCount][]byte
+	var failed [protocolCount]bool
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, userId := range userIds {
+		for c := range h.clients[userId] {
+			if failed[c.protocol] {
+				continue
+			}
+			if encoded[c.protocol] == nil {
+				data, err := c.protocol.encodeMessage(message)
+				if err != nil {
+					log.Errorf("notification of type %d could not be encoded, skipping: %s", message.Type, err.Error())
+					failed[c.protocol] = true
+					continue
+				}
+				encoded[c.protocol] = data
+			}
+			select {
+			case c.send <- encoded[c.protocol]:
+			default:
+				log.Warnf("notification client of user %d is too slow, disconnecting", userId)
+				c.close()
+			}
+		}
+	}
+}
+
+// Connections returns the number of connected clients of the user.
+func (h *Hub) Connections(userId uint64) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userId])
+}
+
+func (h *Hub) register(userId uint64, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[userId] == nil {
+		h.clients[userId] = make(map[*client]struct{})
+	}
+	h.clients[userId][c] = struct{}{}
+}
+
+func (h *Hub) unregister(userId uint64, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients[userId], c)
+	if len(h.clients[userId]) == 0 {
+		delete(h.clients, userId)
+	}
+}
+
+// run completes the SignalR handshake and reads from the connection until it is closed.
+func (h *Hub) run(conn *websocketConn, userId uint64) {
+	conn.conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	p, err := handshake(conn)
+	if err != nil {
+		log.Errorf("notification handshake of user %d failed: %s", userId, err.Error())
+		conn.close()
+		return
+	}
+
+	c := &client{
+		conn:     conn,
+		protocol: p,
+		send:     make(chan []byte, sendBufferSize),
+		done:     make(chan struct{}),
+	}
+	h.register(userId, c)
+	defer h.unregister(userId, c)
+	go c.writeLoop()
+
+	for {
+		conn.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, data, err := conn.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("notification connection of user %d failed: %s", userId, err.Error())
+			}
+			c.close()
+			return
+		}
+		if p.isCloseMessage(data) {
+			c.close()
+			return
+		}
+	}
+}
+
+// writeLoop sends the queued messages and keeps the connection alive with pings.
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		var data []byte
+		select {
+		case <-c.done:
+			return
+		case data = <-c.send:
+		case <-ticker.C:
+			data = c.protocol.encodePing()
+		}
+		if err := c.conn.writeFrame(c.protocol.opcode(), data); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// close ends the connection, the reading goroutine unregisters the client.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.close()
+	})
+}
@@ -0,0 +1,103 @@
+package notifications
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// encodeMsgPack encodes the value in the MessagePack format. Only the types used by the notifications are
+// supported: nil, booleans, integers, strings, lists, string keyed maps and times.
+func encodeMsgPack(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case int:
+		return encodeMsgPackInt(buf, int64(v)), nil
+	case int64:
+		return encodeMsgPackInt(buf, v), nil
+	case string:
+		return encodeMsgPackString(buf, v), nil
+	case []string:
+		buf = encodeMsgPackHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			buf = encodeMsgPackString(buf, item)
+		}
+		return buf, nil
+	case []interface{}:
+		buf = encodeMsgPackHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if buf, err = encodeMsgPack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf = encodeMsgPackHeader(buf, len(v), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			buf = encodeMsgPackString(buf, key)
+			var err error
+			if buf, err = encodeMsgPack(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case time.Time:
+		// Timestamp extension type -1 with 96 bits: nanoseconds and seconds
+		buf = append(buf, 0xc7, 12, 0xff)
+		buf = append(buf, make([]byte, 12)...)
+		binary.BigEndian.PutUint32(buf[len(buf)-12:], uint32(v.Nanosecond()))
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(v.Unix()))
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("unsupported msgpack type %T", value)
+	}
+}
+
+func encodeMsgPackInt(buf []byte, v int64) []byte {
+	if v >= 0 && v < 128 || v < 0 && v >= -32 {
+		return append(buf, byte(v))
+	}
+	buf = append(buf, 0xd3, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(v))
+	return buf
+}
+
+func encodeMsgPackString(buf []byte, v string) []byte {
+	switch length := len(v); {
+	case length < 32:
+		buf = append(buf, 0xa0|byte(length))
+	case length < 1<<8:
+		buf = append(buf, 0xd9, byte(length))
+	case length < 1<<16:
+		buf = append(buf, 0xda, byte(length>>8), byte(length))
+	default:
+		buf = append(buf, 0xdb, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+	return append(buf, v...)
+}
+
+// encodeMsgPackHeader writes the header of a list or map with the fixed, 16 bit or 32 bit length format.
+func encodeMsgPackHeader(buf []byte, length int, fixed, format16, format32 byte) []byte {
+	switch {
+	case length < 16:
+		return append(buf, fixed|byte(length))
+	case length < 1<<16:
+		return append(buf, format16, byte(length>>8), byte(length))
+	default:
+		return append(buf, format32, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+}
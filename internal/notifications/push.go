@@ -0,0 +1,105 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+)
+
+// PushNotifier wakes up the mobile apps of the users. The apps cannot keep a WebSocket connection open while
+// they run in the background.
+type PushNotifier interface {
+	// Push delivers the message to the devices registered with the given push tokens.
+	Push(message Message, pushTokens []string) error
+}
+
+// NewPushNotifier creates the push notifier selected in the configuration. Without a relay URL push
+// notifications are disabled.
+func NewPushNotifier(cfg *bw.Configuration) (PushNotifier, error) {
+	if cfg.Push.RelayURL == "" {
+		return NoopPushNotifier{}, nil
+	}
+	return NewRelayPushNotifier(cfg.Push.RelayURL, cfg.Push.RelayKey)
+}
+
+// NoopPushNotifier drops all messages.
+type NoopPushNotifier struct{}
+
+func (NoopPushNotifier) Push(message Message, pushTokens []string) error {
+	return nil
+}
+
+// RelayPushNotifier hands the messages to a relay service over HTTP. The relay owns the credentials of the
+// platform push services and forwards the messages to the devices.
+type RelayPushNotifier struct {
+	sendURL string
+	key     string
+	client  *http.Client
+}
+
+type relayRequest struct {
+	PushTokens []string
+	Type       MessageType
+	ContextId  string
+	Payload    map[string]interface{}
+}
+
+// NewRelayPushNotifier creates a push notifier that posts the messages to the relay at baseURL. The key, if
+// set, is sent as bearer token.
+func NewRelayPushNotifier(baseURL, key string) (*RelayPushNotifier, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, errors.New("invalid push relay url: " + baseURL)
+	}
+
+	return &RelayPushNotifier{
+		sendURL: strings.TrimSuffix(baseURL, "/") + "/push/send",
+		key:     key,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (r *RelayPushNotifier) Push(message Message, pushTokens []string) error {
+	body, err := json.Marshal(&relayRequest{
+		PushTokens: pushTokens,
+		Type:       message.Type,
+		ContextId:  message.ContextId,
+		Payload:    message.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.sendURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.key != "" {
+		req.Header.Set("Authorization", "Bearer "+r.key)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // allow the connection to be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("push relay responded with status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// recordSeparator terminates the JSON messages of the SignalR protocol.
+const recordSeparator = '\x1e'
+
+// SignalR message types
+const (
+	signalrInvocation = 1
+	signalrPing       = 6
+	signalrClose      = 7
+)
+
+// protocol is the SignalR hub protocol negotiated in the handshake.
+type protocol int
+
+const (
+	protocolJSON protocol = iota
+	protocolMessagePack
+	protocolCount
+)
+
+type handshakeRequest struct {
+	Protocol string `json:"protocol"`
+	Version  int    `json:"version"`
+}
+
+// handshake reads the handshake request of the client and confirms the protocol.
+func handshake(conn *websocketConn) (protocol, error) {
+	_, data, err := conn.readMessage()
+	if err != nil {
+		return 0, err
+	}
+	end := bytes.IndexByte(data, recordSeparator)
+	if end < 0 {
+		return 0, errors.New("incomplete handshake")
+	}
+
+	var request handshakeRequest
+	if err := json.Unmarshal(data[:end], &request); err != nil {
+		return 0, err
+	}
+
+	var p protocol
+	switch {
+	case request.Protocol == "json" && request.Version == 1:
+		p = protocolJSON
+	case request.Protocol == "messagepack" && request.Version == 1:
+		p = protocolMessagePack
+	default:
+		conn.writeFrame(opText, []byte(`{"error":"unsupported protocol"}`+string(recordSeparator)))
+		return 0, errors.New("unsupported protocol " + request.Protocol)
+	}
+
+	if err := conn.writeFrame(opText, []byte("{}"+string(recordSeparator))); err != nil {
+		return 0, err
+	}
+	return p, nil
+}
+
+// opcode returns the WebSocket opcode of the frames carrying the messages.
+func (p protocol) opcode() byte {
+	if p == protocolMessagePack {
+		return opBinary
+	}
+	return opText
+}
+
+// encodeMessage wraps the notification in an invocation of the ReceiveMessage method of the client.
+func (p protocol) encodeMessage(message Message) ([]byte, error) {
+	var contextId interface{}
+	if message.ContextId != "" {
+		contextId = message.ContextId
+	}
+	payload := message.Payload
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	argument := map[string]interface{}{
+		"ContextId": contextId,
+		"Type":      int(message.Type),
+		"Payload":   payload,
+	}
+
+	if p == protocolMessagePack {
+		// [type, headers, invocation id, target, arguments]
+		data, err := encodeMsgPack(nil, []interface{}{
+			signalrInvocation, map[string]interface{}{}, nil, "ReceiveMessage", []interface{}{argument},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return frameMsgPack(data), nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type":      signalrInvocation,
+		"target":    "ReceiveMessage",
+		"arguments": []interface{}{argument},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(data, recordSeparator), nil
+}
+
+func (p protocol) encodePing() []byte {
+	if p == protocolMessagePack {
+		// [type]
+		return frameMsgPack([]byte{0x91, signalrPing})
+	}
+	return []byte(`{"type":6}` + string(recordSeparator))
+}
+
+// isCloseMessage checks if the client sent a close message. All other messages, like pings, are ignored.
+func (p protocol) isCloseMessage(data []byte) bool {
+	if p == protocolMessagePack {
+		for len(data) > 0 {
+			length, n := readVarint(data)
+			if n == 0 || uint64(len(data)-n) < length {
+				return false
+			}
+			message := data[n : n+int(length)]
+			// The message is an array, its first element is the message type
+			if len(message) >= 2 && message[0]&0xf0 == 0x90 && message[1] == signalrClose {
+				return true
+			}
+			data = data[n+int(length):]
+		}
+		return false
+	}
+
+	for _, record := range bytes.Split(data, []byte{recordSeparator}) {
+		var message struct {
+			Type int `json:"type"`
+		}
+		if json.Unmarshal(record, &message) == nil && message.Type == signalrClose {
+			return true
+		}
+	}
+	return false
+}
+
+// frameMsgPack prefixes the MessagePack message with its length as variable length integer.
+func frameMsgPack(message []byte) []byte {
+	length := len(message)
+	var prefix []byte
+	for {
+		b := byte(length & 0x7f)
+		length >>= 7
+		if length == 0 {
+			prefix = append(prefix, b)
+			break
+		}
+		prefix = append(prefix, b|0x80)
+	}
+	return append(prefix, message...)
+}
+
+// readVarint decodes the length prefix of a MessagePack message. It returns the number of bytes read, zero
+// if the prefix is invalid.
+func readVarint(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(data) && i < 5; i++ {
+		value |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,210 @@
+package notifications
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the key of the client to calculate the accept header, see RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxMessageSize limits the messages of the clients, they only send handshakes and pings.
+const maxMessageSize = 64 * 1024
+
+const writeTimeout = 10 * time.Second
+
+var errMessageTooLarge = errors.New("websocket message too large")
+
+// websocketConn is a server side WebSocket connection. Reading is done by a single goroutine, writes are
+// serialized.
+type websocketConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the connection of the request.
+func upgradeWebSocket(w http.ResponseWriter, req *http.Request) (*websocketConn, error) {
+	if req.Method != http.MethodGet ||
+		!headerContainsToken(req.Header, "Connection", "upgrade") ||
+		!headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a websocket handshake")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("websocket key is missing")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection cannot be taken over")
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &websocketConn{conn: conn, reader: buffer.Reader}, nil
+}
+
+// readMessage returns the next data message of the client. Fragmented messages are joined, pings are
+// answered. io.EOF is returned when the client closes the connection.
+func (c *websocketConn) readMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOpcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return 0, nil, io.EOF
+		case opText, opBinary:
+			if message != nil {
+				return 0, nil, errors.New("unexpected websocket data frame")
+			}
+			opcode = frameOpcode
+			message = payload
+		case opContinuation:
+			if message == nil {
+				return 0, nil, errors.New("unexpected websocket continuation frame")
+			}
+			if len(message)+len(payload) > maxMessageSize {
+				return 0, nil, errMessageTooLarge
+			}
+			message = append(message, payload...)
+		default:
+			return 0, nil, errors.New("unknown websocket opcode")
+		}
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame. Frames of clients have to be masked.
+func (c *websocketConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("unmasked websocket frame")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, errMessageTooLarge
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends an unfragmented, unmasked frame.
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *websocketConn) close() error {
+	return c.conn.Close()
+}
+
+// headerContainsToken checks if the comma separated header contains the token, ignoring the case.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
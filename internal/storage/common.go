@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	bw "github.com/h44z/bitwarden-go/internal/common"
+)
+
+// ErrNotFound is returned if the requested object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Storage is a blob store for cipher attachments.
+type Storage interface {
+	// Put stores size bytes read from data under the given name, existing objects are overwritten.
+	Put(name string, data io.Reader, size int64) error
+	// Get returns a reader for the object with the given name. The caller has to close the reader.
+	Get(name string) (io.ReadCloser, error)
+	// Delete removes the object with the given name. Deleting a non existent object is not an error.
+	Delete(name string) error
+}
+
+// New creates the storage backend selected in the configuration.
+func New(cfg *bw.Configuration) (Storage, error) {
+	switch cfg.Storage.Type {
+	case bw.StorageTypeLocal:
+		return NewLocal(cfg.Storage.Location), nil
+	case bw.StorageTypeS3:
+		return NewS3(cfg.Storage.Endpoint, cfg.Storage.Region, cfg.Storage.Bucket,
+			cfg.Storage.AccessKey, cfg.Storage.SecretKey)
+	default:
+		return nil, errors.New("unsupported storage type: " + cfg.Storage.Type)
+	}
+}
+
+// validateName makes sure that object names cannot escape the storage location.
+func validateName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return errors.New("invalid object name")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return errors.New("invalid object name")
+		}
+	}
+	return nil
+}
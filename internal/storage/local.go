@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Local stores objects as files below a base directory.
+type Local struct {
+	baseDir string
+}
+
+// NewLocal creates a storage that keeps all objects below baseDir.
+func NewLocal(baseDir string) *Local {
+	return &Local{baseDir: baseDir}
+}
+
+func (l *Local) Put(name string, data io.Reader, size int64) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	path := filepath.Join(l.baseDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that readers never see partial objects
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := io.CopyN(tmp, data, size); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Get(name string) (io.ReadCloser, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(l.baseDir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+func (l *Local) Delete(name string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(l.baseDir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 stores objects in a bucket of an S3 compatible object store (AWS, MinIO, ...).
+// Requests are signed with AWS signature version 4 and use path style addressing.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 creates a storage that keeps all objects in the given bucket.
+func NewS3(endpoint, region, bucket, accessKey, secretKey string) (*S3, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpointURL.Scheme == "" || endpointURL.Host == "" {
+		return nil, errors.New("invalid s3 endpoint: " + endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("s3 bucket is missing")
+	}
+
+	return &S3{
+		endpoint:  endpointURL,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    newS3Client(),
+		now:       time.Now,
+	}, nil
+}
+
+// newS3Client returns a client that fails on unreachable or unresponsive endpoints. The transfer of the body
+// itself is not limited, downloads are streamed to slow clients and may take a long time.
+func newS3Client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+			ExpectContinueTimeout: time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          100,
+		},
+	}
+}
+
+func (s *S3) Put(name string, data io.Reader, size int64) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodPut, name, io.LimitReader(data, size), size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+func (s *S3) Get(name string) (io.ReadCloser, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(http.MethodGet, name, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(name string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodDelete, name, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = checkResponse(resp)
+	if err == ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// do sends a signed request for the object with the given name.
+func (s *S3) do(method, name string, body io.Reader, size int64) (*http.Response, error) {
+	objectURL := *s.endpoint
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + s.bucket + "/" + name
+
+	req, err := http.NewRequest(method, objectURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req)
+
+	return s.client.Do(req)
+}
+
+// sign adds the AWS signature version 4 authorization header to the request. The payload is not signed so
+// that uploads can be streamed.
+func (s *S3) sign(req *http.Request) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// checkResponse converts S3 error responses to errors.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+}
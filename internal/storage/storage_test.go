@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal in-memory stand-in for an S3 compatible object store.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") ||
+		req.Header.Get("X-Amz-Date") == "" {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodPut:
+		if req.ContentLength < 0 {
+			http.Error(w, "MissingContentLength", http.StatusLengthRequired)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		f.objects[req.URL.Path] = data
+	case http.MethodGet:
+		data, ok := f.objects[req.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testRoundTrip(t *testing.T, s Storage) {
+	if err := s.Put("1/abc", strings.NewReader("encrypted content"), int64(len("encrypted content"))); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Get("1/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "encrypted content" {
+		t.Errorf("unexpected object content: got %q", string(data))
+	}
+
+	if err := s.Delete("1/abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("1/abc"); err != ErrNotFound {
+		t.Errorf("deleted object is still available: %v", err)
+	}
+	if err := s.Delete("1/abc"); err != nil {
+		t.Errorf("deleting a missing object failed: %v", err)
+	}
+
+	if err := s.Put("../escape", strings.NewReader("x"), 1); err == nil {
+		t.Errorf("object name with path traversal was accepted")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitwarden-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	testRoundTrip(t, NewLocal(dir))
+}
+
+func TestS3(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	t.Cleanup(server.Close)
+
+	s, err := NewS3(server.URL, "us-east-1", "attachments", "access", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRoundTrip(t, s)
+
+	// Wrong credentials must be reported
+	s, _ = NewS3(server.URL, "us-east-1", "attachments", "wrong", "secret")
+	if err := s.Put("1/abc", strings.NewReader("x"), 1); err == nil {
+		t.Errorf("request with invalid credentials succeeded")
+	}
+}
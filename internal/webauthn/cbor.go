@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// maxCBORDepth limits the nesting of decoded items, authenticator data is never nested deeply.
+const maxCBORDepth = 16
+
+var errInvalidCBOR = errors.New("invalid cbor data")
+
+// decodeCBOR decodes the first CBOR item of the data and returns the remaining bytes. Only the subset used
+// by WebAuthn is supported: integers, byte and text strings, arrays, maps, booleans and null. Integers are
+// returned as int64, maps as map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, errInvalidCBOR
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		default:
+			return nil, nil, errInvalidCBOR
+		}
+	}
+
+	length, data, err := decodeCBORLength(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0: // unsigned integer
+		if length > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(length), data, nil
+	case 1: // negative integer
+		if length > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(length), data, nil
+	case 2, 3: // byte string, text string
+		if length > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		value := data[:length]
+		if major == 3 {
+			return string(value), data[length:], nil
+		}
+		return append([]byte(nil), value...), data[length:], nil
+	case 4: // array
+		if length > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make([]interface{}, length)
+		for i := range items {
+			if items[i], data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, data, nil
+	case 5: // map
+		if length > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make(map[interface{}]interface{}, length)
+		for i := uint64(0); i < length; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errInvalidCBOR
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default: // tags are not used by WebAuthn
+		return nil, nil, errInvalidCBOR
+	}
+}
+
+// decodeCBORLength decodes the argument of an item header. Indefinite lengths are not supported.
+func decodeCBORLength(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errInvalidCBOR
+	}
+}
@@ -0,0 +1,270 @@
+// Package webauthn verifies the registration and the login assertions of WebAuthn security keys. Attestation
+// statements are not verified, the server asks the clients for attestation "none".
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported public keys.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+const (
+	flagUserPresent      = 0x01
+	flagAttestedCredData = 0x40
+)
+
+var (
+	ErrInvalidClientData = errors.New("invalid client data")
+	ErrInvalidAuthData   = errors.New("invalid authenticator data")
+	ErrInvalidPublicKey  = errors.New("invalid or unsupported public key")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrSignCounter       = errors.New("sign counter did not increase, the key may be cloned")
+)
+
+// RelyingParty identifies the web vault the keys are registered for.
+type RelyingParty struct {
+	ID     string // domain of the web vault
+	Origin string // scheme, domain and port of the web vault
+}
+
+// Credential is a registered security key.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE encoded
+	Counter   uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	flags        byte
+	counter      uint32
+	credentialId []byte
+	publicKey    []byte
+}
+
+// NewChallenge generates a random challenge for a registration or a login.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ClientDataChallenge returns the challenge the client signed. It is used to find the pending challenge
+// before the response is verified.
+func ClientDataChallenge(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, ErrInvalidClientData
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, ErrInvalidClientData
+	}
+	return challenge, nil
+}
+
+// VerifyRegistration checks the response of the authenticator to a registration challenge and returns the
+// new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidAuthData
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidAuthData
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredData == 0 {
+		return nil, ErrInvalidAuthData
+	}
+	if _, _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{ID: authData.credentialId, PublicKey: authData.publicKey, Counter: authData.counter}, nil
+}
+
+// VerifyAssertion checks the response of the authenticator to a login challenge. It returns the new sign
+// counter of the credential, the counter has to increase with every login unless the key does not count.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential *Credential, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	alg, publicKey, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if !verifySignature(alg, publicKey, signed, signature) {
+		return 0, ErrInvalidSignature
+	}
+
+	if (authData.counter != 0 || credential.Counter != 0) && authData.counter <= credential.Counter {
+		return 0, ErrSignCounter
+	}
+	return authData.counter, nil
+}
+
+// verifyClientData checks that the client signed the expected challenge on the web vault.
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return ErrInvalidClientData
+	}
+
+	expected := base64.RawURLEncoding.EncodeToString(challenge)
+	if data.Type != ceremony || data.Origin != rp.Origin ||
+		subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(expected)) != 1 {
+		return ErrInvalidClientData
+	}
+	return nil
+}
+
+// parseAuthenticatorData decodes the authenticator data and checks that it belongs to the relying party and
+// the user was present.
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidAuthData
+	}
+	rpIdHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpIdHash[:]) != 1 {
+		return nil, ErrInvalidAuthData
+	}
+
+	authData := authenticatorData{flags: data[32], counter: binary.BigEndian.Uint32(data[33:37])}
+	if authData.flags&flagUserPresent == 0 {
+		return nil, ErrInvalidAuthData
+	}
+
+	if authData.flags&flagAttestedCredData != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthData
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || len(rest) < idLength {
+			return nil, ErrInvalidAuthData
+		}
+		authData.credentialId = append([]byte(nil), rest[:idLength]...)
+		rest = rest[idLength:]
+
+		_, extensions, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidAuthData
+		}
+		authData.publicKey = append([]byte(nil), rest[:len(rest)-len(extensions)]...)
+	}
+
+	return &authData, nil
+}
+
+// parsePublicKey decodes a COSE encoded public key.
+func parsePublicKey(coseKey []byte) (int64, crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return 0, nil, ErrInvalidPublicKey
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, ErrInvalidPublicKey
+	}
+	keyType, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	curve, _ := key[int64(-1)].(int64)
+
+	switch {
+	case keyType == 2 && alg == AlgES256 && curve == 1: // EC2, P-256
+		x, okX := key[int64(-2)].([]byte)
+		y, okY := key[int64(-3)].([]byte)
+		if !okX || !okY || len(x) != 32 || len(y) != 32 {
+			return 0, nil, ErrInvalidPublicKey
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return 0, nil, ErrInvalidPublicKey
+		}
+		return alg, publicKey, nil
+	case keyType == 1 && alg == AlgEdDSA && curve == 6: // OKP, Ed25519
+		x, ok := key[int64(-2)].([]byte)
+		if !ok || len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrInvalidPublicKey
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case keyType == 3 && alg == AlgRS256: // RSA
+		n, okN := key[int64(-1)].([]byte)
+		e, okE := key[int64(-2)].([]byte)
+		if !okN || !okE || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, ErrInvalidPublicKey
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+	default:
+		return 0, nil, ErrInvalidPublicKey
+	}
+}
+
+// verifySignature checks the signature of the data with the public key.
+func verifySignature(alg int64, publicKey crypto.PublicKey, data, signature []byte) bool {
+	switch alg {
+	case AlgES256:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return false
+		}
+		hash := sha256.Sum256(data)
+		return ecdsa.Verify(publicKey.(*ecdsa.PublicKey), hash[:], sig.R, sig.S)
+	case AlgEdDSA:
+		return ed25519.Verify(publicKey.(ed25519.PublicKey), data, signature)
+	case AlgRS256:
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	default:
+		return false
+	}
+}